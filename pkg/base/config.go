@@ -16,8 +16,10 @@ package base
 
 import (
 	"crypto/tls"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -138,6 +140,12 @@ type Config struct {
 	// See https://github.com/grpc/grpc-go/issues/586.
 	HTTPAddr string
 
+	// HTTPAdvertiseAddr, if set, is the externally reachable address of the
+	// admin UI, e.g. when it is served through a reverse proxy. It is either a
+	// host:port or a full URL and is only used by AdminURL; the HTTP listener
+	// still binds to HTTPAddr.
+	HTTPAdvertiseAddr string
+
 	// The certificate manager. Must be accessed through GetCertificateManager.
 	certificateManager lazyCertificateManager
 
@@ -177,12 +185,40 @@ func (cfg *Config) HTTPRequestScheme() string {
 
 // AdminURL returns the URL for the admin UI.
 func (cfg *Config) AdminURL() *url.URL {
+	if cfg.HTTPAdvertiseAddr != "" {
+		if u, err := ParseHTTPAdvertiseAddr(cfg.HTTPAdvertiseAddr, cfg.HTTPRequestScheme()); err == nil {
+			return u
+		}
+	}
 	return &url.URL{
 		Scheme: cfg.HTTPRequestScheme(),
 		Host:   cfg.HTTPAddr,
 	}
 }
 
+// ParseHTTPAdvertiseAddr parses an advertised HTTP address, which is either
+// a host:port, in which case the given scheme is used, or an http(s) URL.
+func ParseHTTPAdvertiseAddr(addr, scheme string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, errors.Wrapf(err, "invalid advertised HTTP address %q", addr)
+		}
+		return &url.URL{Scheme: scheme, Host: addr}, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid advertised HTTP address %q", addr)
+	}
+	if u.Scheme != httpScheme && u.Scheme != httpsScheme {
+		return nil, errors.Errorf("invalid advertised HTTP address %q: unsupported scheme %q",
+			addr, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid advertised HTTP address %q: missing host", addr)
+	}
+	return u, nil
+}
+
 // GetClientCertPaths returns the paths to the client cert and key.
 func (cfg *Config) GetClientCertPaths(user string) (string, string, error) {
 	cm, err := cfg.GetCertificateManager()
@@ -434,6 +470,9 @@ type TempStorageConfig struct {
 	// use. If InMemory is set, than this has to be a memory monitor; otherwise it
 	// has to be a disk monitor.
 	Mon *mon.BytesMonitor
+	// MaxSizeBytes is the budget of Mon, i.e. the maximum number of bytes the
+	// temp storage may use.
+	MaxSizeBytes int64
 }
 
 // TempStorageConfigFromEnv creates a TempStorageConfig.
@@ -467,8 +506,9 @@ func TempStorageConfigFromEnv(
 	}
 
 	return TempStorageConfig{
-		InMemory: inMem,
-		Mon:      &monitor,
+		InMemory:     inMem,
+		Mon:          &monitor,
+		MaxSizeBytes: maxSizeBytes,
 	}
 }
 
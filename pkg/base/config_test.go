@@ -113,3 +113,40 @@ func TestServerSSLSettings(t *testing.T) {
 		}
 	}
 }
+
+func TestAdminURLHTTPAdvertiseAddr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		insecure   bool
+		advertise  string
+		expected   string
+		parseError bool
+	}{
+		{true, "", "http://127.0.0.1:8080", false},
+		{false, "", "https://127.0.0.1:8080", false},
+		{true, "proxy.example.com:80", "http://proxy.example.com:80", false},
+		{false, "proxy.example.com:443", "https://proxy.example.com:443", false},
+		{false, "https://proxy.example.com/crdb", "https://proxy.example.com/crdb", false},
+		{true, "proxy.example.com", "http://127.0.0.1:8080", true},
+		{true, "ftp://proxy.example.com", "http://127.0.0.1:8080", true},
+		{true, "http:///crdb", "http://127.0.0.1:8080", true},
+	}
+	for i, tc := range testCases {
+		cfg := &base.Config{
+			Insecure:          tc.insecure,
+			HTTPAddr:          "127.0.0.1:8080",
+			HTTPAdvertiseAddr: tc.advertise,
+		}
+		if actual := cfg.AdminURL().String(); actual != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, actual)
+		}
+		if tc.advertise == "" {
+			continue
+		}
+		_, err := base.ParseHTTPAdvertiseAddr(tc.advertise, cfg.HTTPRequestScheme())
+		if (err != nil) != tc.parseError {
+			t.Errorf("%d: expected parse error=%t, got err=%v", i, tc.parseError, err)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -99,6 +100,27 @@ func (ss StoreSpec) String() string {
 // a separate check.
 var fractionRegex = regexp.MustCompile(`^([0-9]+\.[0-9]*|[0-9]*\.[0-9]+|[0-9]+(\.[0-9]*)?%)$`)
 
+// validateStoreAttribute checks the syntax of a single store attribute. Empty
+// attributes and whitespace are rejected, as are the characters which have a
+// special meaning in zone config constraints ("+"/"-" prefixes and "="), since
+// such an attribute could never be matched by a constraint.
+func validateStoreAttribute(attribute string) error {
+	if attribute == "" {
+		return fmt.Errorf("empty attribute given for store")
+	}
+	if strings.IndexFunc(attribute, unicode.IsSpace) != -1 {
+		return fmt.Errorf("attribute %q given for store contains whitespace", attribute)
+	}
+	if attribute[0] == '+' || attribute[0] == '-' {
+		return fmt.Errorf("attribute %q given for store cannot start with '%c'",
+			attribute, attribute[0])
+	}
+	if strings.Contains(attribute, "=") {
+		return fmt.Errorf("attribute %q given for store cannot contain '='", attribute)
+	}
+	return nil
+}
+
 // NewStoreSpec parses the string passed into a --store flag and returns a
 // StoreSpec if it is correctly parsed.
 // There are four possible fields that can be passed in, comma separated:
@@ -194,6 +216,9 @@ func NewStoreSpec(value string) (StoreSpec, error) {
 			// Check to make sure there are no duplicate attributes.
 			attrMap := make(map[string]struct{})
 			for _, attribute := range strings.Split(value, ":") {
+				if err := validateStoreAttribute(attribute); err != nil {
+					return StoreSpec{}, err
+				}
 				if _, ok := attrMap[attribute]; ok {
 					return StoreSpec{}, fmt.Errorf("duplicate attribute given for store: %s", attribute)
 				}
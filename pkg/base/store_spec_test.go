@@ -53,6 +53,12 @@ func TestNewStoreSpec(t *testing.T) {
 		{"path=/mnt/hda1,attrs=", "no value specified for attrs", StoreSpec{}},
 		{"path=/mnt/hda1,attrs=hdd:hdd", "duplicate attribute given for store: hdd", StoreSpec{}},
 		{"path=/mnt/hda1,attrs=hdd,attrs=ssd", "attrs field was used twice in store definition", StoreSpec{}},
+		{"path=/mnt/hda1,attrs=hdd::ssd", "empty attribute given for store", StoreSpec{}},
+		{"path=/mnt/hda1,attrs=hdd:", "empty attribute given for store", StoreSpec{}},
+		{"path=/mnt/hda1,attrs=hdd:s sd", `attribute "s sd" given for store contains whitespace`, StoreSpec{}},
+		{"path=/mnt/hda1,attrs=+ssd", `attribute "+ssd" given for store cannot start with '+'`, StoreSpec{}},
+		{"path=/mnt/hda1,attrs=-ssd", `attribute "-ssd" given for store cannot start with '-'`, StoreSpec{}},
+		{"path=/mnt/hda1,attrs=disk=ssd", `attribute "disk=ssd" given for store cannot contain '='`, StoreSpec{}},
 
 		// size
 		{"path=/mnt/hda1,size=671088640", "", StoreSpec{"/mnt/hda1", 671088640, 0, false, roachpb.Attributes{}}},
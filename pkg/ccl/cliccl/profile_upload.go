@@ -0,0 +1,39 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cliccl
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/cli"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+)
+
+func init() {
+	cli.SetProfileUploader(uploadProfile)
+}
+
+// uploadProfile copies a profile to the export storage designated by uri.
+func uploadProfile(
+	ctx context.Context, st *cluster.Settings, uri, name string, content io.ReadSeeker,
+) error {
+	conf, err := storageccl.ExportStorageConfFromURI(uri)
+	if err != nil {
+		return err
+	}
+	es, err := storageccl.MakeExportStorage(ctx, conf, st)
+	if err != nil {
+		return err
+	}
+	defer es.Close()
+	return es.WriteFile(ctx, name, content)
+}
@@ -15,6 +15,7 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
@@ -84,6 +85,16 @@ Output build version information.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		info := build.GetInfo()
+		if versionCtx.outputFormat == versionOutputJSON {
+			// Emit the whole build.Info so that automation can record
+			// exactly which binary is in use without parsing the text.
+			b, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
 		tw := tabwriter.NewWriter(os.Stdout, 2, 1, 2, ' ', 0)
 		fmt.Fprintf(tw, "Build Tag:    %s\n", info.Tag)
 		fmt.Fprintf(tw, "Build Time:   %s\n", info.Time)
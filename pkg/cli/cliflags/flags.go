@@ -66,6 +66,15 @@ Including more tiers is better than including fewer. For example:
   --locality=planet=earth,province=manitoba,colo=secondary,power=3`,
 	}
 
+	LocalityFile = FlagInfo{
+		Name: "locality-file",
+		Description: `
+File to read the locality of the node from, in the same format as --locality,
+either on a single line or with one key-value pair per line. Tiers specified
+via --locality take precedence over the tiers with the same key in the file;
+the other tiers of --locality are added after those of the file.`,
+	}
+
 	ZoneConfig = FlagInfo{
 		Name:      "file",
 		Shorthand: "f",
@@ -89,6 +98,56 @@ control is not returned to the shell until the server is ready to
 accept requests.`,
 	}
 
+	GoMem = FlagInfo{
+		Name: "max-go-memory",
+		Description: `
+Soft limit for the memory used by the Go runtime. As the limit is approached,
+the garbage collector runs more often, trading CPU for a lower risk of running
+out of memory. Accepts numbers interpreted as bytes, size suffixes (e.g. 1GB
+and 1GiB) or a percentage of physical memory (e.g. 25%).
+If left unspecified, no limit is set. Requires a Go version supporting
+soft memory limits; otherwise a warning is logged.
+`,
+	}
+
+	GoProcs = FlagInfo{
+		Name: "max-go-procs",
+		Description: `
+The maximum number of CPUs executing Go code simultaneously (GOMAXPROCS). If
+left unspecified, the Go runtime's default, or the GOMAXPROCS environment
+variable, is used. A warning is logged if the value is far off from the CPU
+limit of the container the node runs in.`,
+	}
+
+	CPUProfileOnStart = FlagInfo{
+		Name: "cpu-profile-on-start",
+		Description: `
+If specified, profiles the cpu usage of the node startup, from the beginning of
+the start command until the node is ready or the given duration elapses,
+whichever comes first. The profile is written as startupcpu.<timestamp> along
+with the other profiles. Periodic cpu profiles (COCKROACH_CPUPROF_INTERVAL) are
+skipped until it completes.`,
+	}
+
+	DisableUpdateCheck = FlagInfo{
+		Name: "disable-update-check",
+		Description: `
+If specified, the node doesn't periodically check for new releases of
+CockroachDB, e.g. for air-gapped deployments. The startup summary then shows
+that update checks are disabled. When not specified, the
+COCKROACH_SKIP_UPDATE_CHECK environment variable is used if set.`,
+	}
+
+	DisableProfiling = FlagInfo{
+		Name: "disable-profiling",
+		Description: `
+If specified, turns off all the background profilers regardless of their
+individual settings: the periodic memory and cpu profiles, the heap profiles
+taken on memory thresholds, and the block and mutex profiles. This can also be
+requested with COCKROACH_DISABLE_PROFILING=true. Useful to get a clean baseline
+for performance measurements.`,
+	}
+
 	SQLMem = FlagInfo{
 		Name: "max-sql-memory",
 		Description: `
@@ -106,9 +165,11 @@ If left unspecified, defaults to 128MiB.
 Maximum storage capacity available to store temporary disk-based data for SQL
 queries that exceed the memory budget (e.g. join, sorts, etc are sometimes able
 to spill intermediate results to disk).
-Accepts numbers interpreted as bytes, size suffixes (e.g. 32GB and 32GiB) or a
-percentage of disk size (e.g. 10%).
-If left unspecified, defaults to 32GiB.
+Accepts numbers interpreted as bytes, size suffixes (e.g. 32GB and 32GiB), a
+percentage of disk size (e.g. 10%) or a percentage of the currently available
+disk space (e.g. 10%free).
+If left unspecified, defaults to 32GiB. A value of 0 disables temp storage:
+queries that exceed their memory budget then fail instead of spilling to disk.
 
 The location of the temporary files is within the first store dir (see --store).
 If expressed as a percentage, --max-disk-temp-storage is interpreted relative to
@@ -146,6 +207,23 @@ can also be specified (e.g. 25%).`,
 		Description: `Database server port to connect to.`,
 	}
 
+	ConnectRetries = FlagInfo{
+		Name:   "connect-retries",
+		EnvVar: "COCKROACH_CONNECT_RETRIES",
+		Description: `
+The number of times to retry, with exponential backoff, a failed attempt to
+connect to the server. Useful when the server is still starting up or is
+reached through a load balancer during a rolling restart.`,
+	}
+
+	ConnectTimeout = FlagInfo{
+		Name:   "connect-timeout",
+		EnvVar: "COCKROACH_CONNECT_TIMEOUT",
+		Description: `
+The maximum total amount of time to spend trying to connect to the server,
+including retries. Zero means no limit.`,
+	}
+
 	Database = FlagInfo{
 		Name:        "database",
 		Shorthand:   "d",
@@ -227,7 +305,15 @@ An unspecified type means ip address or dns. Type is one of:
 
   - tcp: (default if type is omitted): plain ip address or hostname.
   - http-lb: HTTP load balancer: we query
-             http(s)://<address>/_status/details/local`,
+             http(s)://<address>/_status/details/local
+</PRE>
+Addresses can also be obtained when the node starts, either from a file
+containing one address per line, or from a DNS SRV record, for example:
+<PRE>
+
+  --join=@/etc/cockroach/join-list --join=srv+_cockroach._tcp.example.com
+
+</PRE>`,
 	}
 
 	ServerHost = FlagInfo{
@@ -251,6 +337,15 @@ The hostname to advertise to other CockroachDB nodes for intra-cluster
 communication; it must resolve from other nodes in the cluster.`,
 	}
 
+	ResolveAdvertiseHost = FlagInfo{
+		Name: "resolve-advertise-host",
+		Description: `
+Resolve --advertise-host to an IP address when the node starts, and advertise
+that address rather than the hostname. The hostname is resolved again on every
+restart, which suits environments where a node keeps its hostname but not its
+IP address. The node refuses to start if the hostname doesn't resolve.`,
+	}
+
 	AdvertisePort = FlagInfo{
 		Name: "advertise-port",
 		Description: `
@@ -268,6 +363,15 @@ communication.`,
 		Description: `The port to bind to for HTTP requests.`,
 	}
 
+	HTTPAdvertiseAddr = FlagInfo{
+		Name: "listen-http-advertise-addr",
+		Description: `
+The externally reachable address of the admin UI, as host:port or as a full
+http(s) URL, e.g. when it is served through a reverse proxy. It is used for the
+admin URL printed at startup and written to the URL files; the HTTP listener
+still binds to --http-host and --http-port.`,
+	}
+
 	ListeningURLFile = FlagInfo{
 		Name: "listening-url-file",
 		Description: `
@@ -282,6 +386,160 @@ After the CockroachDB node has started up successfully, it will
 write its process ID to the specified file.`,
 	}
 
+	StartupSummaryFile = FlagInfo{
+		Name: "startup-summary-file",
+		Description: `
+After the CockroachDB node has started up successfully, it will
+write the human-readable startup summary to the specified file.`,
+	}
+
+	LogFormat = FlagInfo{
+		Name: "log-format",
+		Description: `
+The format of the startup summary in the logs: "text" logs the human-readable
+table, and "json" logs its fields as a single-line JSON object, for log
+pipelines that expect structured entries. The summary printed on stdout and
+written to --startup-summary-file is not affected.`,
+	}
+
+	StartupMetricsFile = FlagInfo{
+		Name: "startup-metrics-file",
+		Description: `
+After the CockroachDB node has started up successfully, it will write
+the startup duration, whether this was the node's initial boot, and the
+node ID to the specified file in the Prometheus text format, suitable
+for node_exporter's textfile collector.`,
+	}
+
+	ClusterID = FlagInfo{
+		Name: "cluster-id",
+		Description: `
+If specified, refuses to start the node if any of its stores was last used by a
+cluster with a different ID.`,
+	}
+
+	VerifyTempStorage = FlagInfo{
+		Name: "verify-temp-storage",
+		Description: `
+If specified, writes and removes a test file of up to 64 MiB in the temp
+storage directory before starting, and refuses to start the node if that fails,
+e.g. because of a quota or of permissions. Without it, such problems only
+surface when a query spills to disk.`,
+	}
+
+	VerifyStoreClusterID = FlagInfo{
+		Name: "verify-store-cluster-id",
+		Description: `
+If specified, asks the --join targets for the ID of their cluster before
+starting, and refuses to start the node if any of its stores was last used by a
+different cluster. The check is skipped with a warning if no --join target
+responds. All the stores must belong to the same cluster in any case.`,
+	}
+
+	JoinClockCheck = FlagInfo{
+		Name: "join-clock-check",
+		Description: `
+What to do if, when the node starts, the clock of a --join target is offset from
+the local clock by more than half of --max-offset: "warn" logs a warning,
+"strict" refuses to start, and "off" skips the measurement. The offsets are
+measured with a few pings to each target, and shown in the startup summary.
+Targets that don't respond within a couple of seconds are ignored.`,
+	}
+
+	StrictLogDir = FlagInfo{
+		Name: "strict-log-dir",
+		Description: `
+If specified, refuses to start when multiple on-disk stores are configured and
+--log-dir is not specified, instead of defaulting the log directory to the first
+store with a warning.`,
+	}
+
+	StrictStoreSize = FlagInfo{
+		Name: "strict-store-size",
+		Description: `
+If specified, refuses to start when the sizes configured for the stores (the
+size field of --store) exceed the capacity of their device, instead of logging
+a warning. The sizes of stores sharing a device are added up. In-memory stores
+are checked, along with --cache and --max-sql-memory, against three quarters of
+the total memory.`,
+	}
+
+	ShutdownLog = FlagInfo{
+		Name: "shutdown-log",
+		Description: `
+What to log every 5 seconds during a graceful shutdown: "silent" logs nothing,
+"count" logs the number of running tasks, and "tasks" also lists the running
+tasks by call site, which helps diagnose a stuck shutdown.`,
+	}
+
+	StartConfigFile = FlagInfo{
+		Name: "config",
+		Description: `
+Path to a YAML file mapping flag names to values, e.g. "cache: 25%" or
+"store: [/mnt/1, /mnt/2]", for the flags not specified on the command line.
+Defaults to $XDG_CONFIG_HOME/cockroach/config.yaml (or
+$HOME/.config/cockroach/config.yaml) or /etc/cockroach/config.yaml, whichever
+exists first.`,
+	}
+
+	PerStoreLogs = FlagInfo{
+		Name: "per-store-logs",
+		Description: `
+If specified, store-specific events, such as the node starting and stopping
+along with the free space of the store, are also written to a
+cockroach-store.log file in the "logs" subdirectory of each on-disk store,
+which is created if needed. The main log is unaffected: unless --log-dir is
+specified, it is always written to the "logs" subdirectory of the first on-disk
+store, and multiple stores are no longer reported as ambiguous.`,
+	}
+
+	ReportConfigFile = FlagInfo{
+		Name: "report-config-file",
+		Description: `
+During startup, the CockroachDB node will write the fully resolved server
+configuration, as reported in the logs, to the specified file as a JSON object.
+This allows comparing the effective configuration across restarts and nodes.`,
+	}
+
+	ConnectionInfoFile = FlagInfo{
+		Name: "connection-info-file",
+		Description: `
+After the CockroachDB node has started up successfully, it will write
+the parameters needed to connect to it to the specified file, as a JSON
+object with the fields sql_url, admin_url, cluster_id, node_id, secure
+and socket.`,
+	}
+
+	ShutdownOn = FlagInfo{
+		Name: "shutdown-on",
+		Description: `
+Comma-separated list of the signals that trigger a graceful shutdown of the
+node. Possible values: int, term and quit. Any of these signals received
+during the graceful shutdown terminates the node forcefully. Signals that are
+not listed are left to their default behavior, which is to terminate the
+process immediately. When this flag is specified, the exit code after a
+graceful shutdown is 128 plus the number of the signal that triggered it, e.g.
+143 for SIGTERM and 130 for SIGINT. If left unspecified, all three signals
+trigger a graceful shutdown, and the exit code is then 1 if it was triggered by
+SIGINT and 0 otherwise.`,
+	}
+
+	OnReadyExec = FlagInfo{
+		Name: "on-ready-exec",
+		Description: `
+Path to an executable that is run once the node has started up successfully,
+e.g. to register the node with an external service discovery system. The
+environment variables COCKROACH_SQL_URL, COCKROACH_ADMIN_URL, COCKROACH_NODE_ID
+and COCKROACH_CLUSTER_ID are passed to it. A failure of the executable is
+logged but does not stop the node, unless --on-ready-strict is specified.`,
+	}
+
+	OnReadyStrict = FlagInfo{
+		Name: "on-ready-strict",
+		Description: `
+If specified, a failure of the --on-ready-exec executable stops the node.`,
+	}
+
 	Socket = FlagInfo{
 		Name:   "socket",
 		EnvVar: "COCKROACH_SOCKET",
@@ -309,6 +567,24 @@ strongly discouraged for production usage and should never be used on
 a public network without combining it with --host.`,
 	}
 
+	InsecureAllow = FlagInfo{
+		Name: "insecure-allow",
+		Description: `
+Restrict the connections accepted by an insecure node to those coming from the
+given comma-separated networks (e.g. 10.0.0.0/8,192.168.1.7). This covers SQL
+clients, the admin UI and the other nodes of the cluster, which must therefore
+be included. Connections from the loopback interface are always accepted. This
+only provides minimal safety for test clusters; it requires --insecure.`,
+	}
+
+	ServerSecure = FlagInfo{
+		Name: "secure",
+		Description: `
+Start a secure node, using TLS connections and password authentication. This
+is the default; the flag makes the choice explicit. Combining --secure with
+--insecure is an error.`,
+	}
+
 	// KeySize, CertificateLifetime, AllowKeyReuse, and OverwriteFiles are used for
 	// certificate generation functions.
 	KeySize = FlagInfo{
@@ -364,8 +640,13 @@ disabled by setting the environment variable COCKROACH_SKIP_KEY_PERMISSION_CHECK
 
 	// Server version of the certs directory flag, cannot be set through environment.
 	ServerCertsDir = FlagInfo{
-		Name:        "certs-dir",
-		Description: CertsDir.Description,
+		Name: "certs-dir",
+		Description: CertsDir.Description + `
+
+Sending SIGHUP to a running node reloads the certificates from this directory
+without dropping existing connections. A replacement set which is incomplete or
+unusable (e.g. a key that does not match its certificate) is rejected and the
+previously loaded certificates remain in use.`,
 	}
 
 	CAKey = FlagInfo{
@@ -477,6 +758,36 @@ If this flag is unspecified, the temporary subdirectory will be located under
 the root of the first store.`,
 	}
 
+	TempDirPrefix = FlagInfo{
+		Name: "temp-dir-prefix",
+		Description: `
+The file name prefix of the temporary subdirectory created inside --temp-dir,
+e.g. to tell apart the subdirectories of several nodes sharing a scratch
+volume. Abandoned subdirectories are cleaned up regardless of their prefix.`,
+	}
+
+	TempDirCleanup = FlagInfo{
+		Name: "temp-dir-cleanup",
+		Description: `
+What to do at startup with temporary subdirectories abandoned by a previous
+run of the node. Possible values: on (remove them), dry-run (log which
+directories would be removed but keep them) and off (leave them alone).
+Keeping them can be useful when investigating a node that crashed.`,
+	}
+
+	DirMode = FlagInfo{
+		Name: "dir-mode",
+		Description: `
+The file mode, in octal, of the directories created by the node at startup:
+the store and log directories, and the missing parents of each. When this flag
+is given, the external I/O directory is created at startup as well, rather than
+when it is first written to. For example, 0750 or 0700 keep other users from
+reading the logs and data of the node. The mode must grant all
+permissions to the owner. Existing directories are left alone, and the umask of
+the process further restricts the mode. The temporary subdirectories of
+--temp-dir are always private to the owner.`,
+	}
+
 	ExternalIODir = FlagInfo{
 		Name: "external-io-dir",
 		Description: `
@@ -490,10 +801,20 @@ or writing to any given node's local file system in a distributed cluster is not
 usually useful unless that filesystem is actually backed by something like NFS.
 
 If left empty, defaults to the "extern" subdirectory of the first store directory.
+The path itself is resolved to its real location, without symlinks, at startup.
 
 The value "disabled" will disable all local file I/O. `,
 	}
 
+	ExternalIORoot = FlagInfo{
+		Name: "external-io-root",
+		Description: `
+If specified, the node refuses to start if the real location of
+--external-io-dir, after resolving symlinks, is outside of this directory. This
+prevents a symlinked --external-io-dir from exposing sensitive locations.
+Symlinks inside --external-io-dir are still followed.`,
+	}
+
 	URL = FlagInfo{
 		Name:   "url",
 		EnvVar: "COCKROACH_URL",
@@ -562,6 +883,132 @@ If specified, decommissions the node and waits for it to rebalance before
 shutting down the node.`,
 	}
 
+	TransferLeasesFirst = FlagInfo{
+		Name: "transfer-leases-first",
+		Description: `
+If specified, asks the node to transfer its range leases to other nodes and
+waits, for up to a minute, for the number of leases it holds to drop to zero
+before draining and shutting it down. The lease count is reported as it drops.
+This makes the shutdown less disruptive to clients. If the lease count can't be
+tracked, the node is asked to stop shedding its leases and is not shut down.`,
+	}
+
+	QuitWait = FlagInfo{
+		Name: "wait",
+		Description: `
+If specified, waits after the shutdown until the node stops accepting
+connections on its address, for at most --drain-wait. This makes sure the
+process released its resources, e.g. before a new node is started on the same
+ports.`,
+	}
+
+	QuitDrainWait = FlagInfo{
+		Name: "drain-wait",
+		Description: `
+The maximum amount of time to wait for the node to drain before proceeding with
+a hard shutdown. The time given to SQL clients with --max-client-drain is added
+to it. This also bounds the time --wait waits for the node to stop accepting
+connections, and the time the node waits for its jobs to checkpoint with the
+jobs drain mode, which counts against the drain. When not specified, the
+COCKROACH_QUIT_TIMEOUT environment variable is used if set.`,
+	}
+
+	MaxClientDrain = FlagInfo{
+		Name: "max-client-drain",
+		Description: `
+If non-zero, the maximum amount of time the node gives SQL clients to finish
+their work while draining. Sessions still open after that are forcibly closed
+and their number is reported. Defaults to the server's own limit.`,
+	}
+
+	DrainStrategy = FlagInfo{
+		Name: "drain-strategy",
+		Description: `
+How the node should order the transfers of its range leases while draining:
+"fast" transfers them as quickly as possible, and "balanced" spreads them
+across the other nodes to avoid hot-spotting the nodes that would otherwise
+receive most of them. This is a hint: nodes that don't support the requested
+strategy fall back to "fast", which is the default.`,
+	}
+
+	DrainNoopCheckTimeout = FlagInfo{
+		Name: "drain-noop-check-timeout",
+		Description: `
+The maximum amount of time to wait for the node to respond to the no-op drain
+request sent to check that it is responsive before draining it. If the node
+accepts the connection but doesn't respond in time, a hard shutdown is
+attempted right away. Zero disables the timeout.`,
+	}
+
+	DecommissionWait = FlagInfo{
+		Name: "decommission-wait",
+		Description: `
+When used with --decommission, the maximum amount of time to wait for the node
+to rebalance its data away. If the time runs out, the ranges still on the node
+are reported and, unless --decommission-strict is specified, the node is shut
+down anyway. Zero means wait indefinitely.`,
+	}
+
+	DecommissionStrict = FlagInfo{
+		Name: "decommission-strict",
+		Description: `
+When used with --decommission-wait, abort instead of shutting down the node if
+decommissioning does not complete in time.`,
+	}
+
+	DrainMode = FlagInfo{
+		Name: "drain-mode",
+		Description: `
+Restricts the drain phases requested before shutting down the node. Takes a
+comma-separated list of any of the following values, and may be specified
+multiple times:
+<PRE>
+
+  - client: stop accepting new SQL clients and wait for existing ones.
+  - leases: transfer range leases away from the node.
+  - jobs: wait, for at most --drain-wait, for the jobs running on the node to
+    checkpoint their progress, so that less work is lost when they resume
+    elsewhere. This should come before leases.
+
+</PRE>
+If left unspecified, all graceful drain phases (client and leases) are run in
+order.`,
+	}
+
+	QuitVerify = FlagInfo{
+		Name: "verify",
+		Description: `
+If specified, waits after the shutdown until another node in the cluster
+reports the node as no longer live, and prints its status. This requires the
+cluster to contain at least one other node.`,
+	}
+
+	QuitOutputFormat = FlagInfo{
+		Name: "format",
+		Description: `
+Selects how the outcome of the shutdown is reported. Possible values: text
+(human-readable progress messages) and json (a single JSON object on standard
+output describing whether the graceful drain succeeded, whether a hard shutdown
+was needed, the elapsed time and the error, if any; progress messages are sent
+to standard error instead). json cannot be combined with --decommission.`,
+	}
+
+	VersionOutputFormat = FlagInfo{
+		Name: "format",
+		Description: `
+Selects how the build information is printed. Possible values: text (a
+human-readable table) and json (a single JSON object with all the fields of the
+build information, for automation that records which binary a node runs).`,
+	}
+
+	QuitYes = FlagInfo{
+		Name:      "yes",
+		Shorthand: "y",
+		Description: `
+Skips the confirmation prompt that is otherwise shown when standard input is
+a terminal.`,
+	}
+
 	Wait = FlagInfo{
 		Name: "wait",
 		Description: `
@@ -591,6 +1038,27 @@ Takes any of the following values:
 		Description: `Show node disk usage details.`,
 	}
 
+	DrainWaitTimeout = FlagInfo{
+		Name: "timeout",
+		Description: `
+The maximum amount of time to wait for the node to be drained. Zero means
+wait indefinitely.`,
+	}
+
+	HealthcheckTimeout = FlagInfo{
+		Name: "timeout",
+		Description: `
+The maximum amount of time to wait for the node to respond. Zero means wait
+indefinitely.`,
+	}
+
+	HealthcheckReadiness = FlagInfo{
+		Name: "readiness",
+		Description: `
+Also require the node to be ready to accept SQL clients, i.e. not to be
+draining, rather than only to be live.`,
+	}
+
 	NodeAll = FlagInfo{
 		Name: "all", Description: `Show all node details.
 When no node ID is specified, also lists all nodes that have been decommissioned
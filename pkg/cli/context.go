@@ -16,13 +16,17 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/server"
+	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/storage/engine"
@@ -56,6 +60,14 @@ type cliContext struct {
 
 	// showTimes indicates whether to display query times after each result line.
 	showTimes bool
+
+	// connectRetries is the number of times a failed RPC connection attempt
+	// is retried, with exponential backoff.
+	connectRetries int
+
+	// connectTimeout, if non-zero, bounds the total time spent trying to
+	// establish an RPC connection, including retries.
+	connectTimeout time.Duration
 }
 
 var serverCfg = func() server.Config {
@@ -308,11 +320,549 @@ var startCtx struct {
 	// server-specific values of some flags.
 	serverInsecure    bool
 	serverSSLCertsDir string
+	// serverSecure is the value of --secure, which must not contradict
+	// serverInsecure when both are given.
+	serverSecure bool
+
+	// startupSummaryFile, if set, is the file to which the startup summary is
+	// written once the node has started.
+	startupSummaryFile string
+	// logFormat is the format of the log entries that are meant to be parsed
+	// by a log pipeline, currently only the startup summary.
+	logFormat logFormat
+
+	// tempDirPrefix is the file name prefix of the temporary subdirectory
+	// created inside the temp dir.
+	tempDirPrefix string
+
+	// configFile is the config file given by --config, and loadedConfigFile
+	// the config file that flags were actually read from, if any.
+	configFile       string
+	loadedConfigFile string
+
+	// perStoreLogs makes the node write store-specific events to the "logs"
+	// subdirectory of each on-disk store.
+	perStoreLogs bool
+
+	// expectedClusterID, if set, is the ID of the cluster the stores must
+	// belong to.
+	expectedClusterID string
+	// verifyTempStorage makes the node check, before starting, that large
+	// files can be written to the temp storage directory.
+	verifyTempStorage bool
+	// verifyStoreClusterID makes the node check, before starting, that its
+	// stores belong to the cluster of the --join targets.
+	verifyStoreClusterID bool
+
+	// strictLogDir turns the ambiguity of a defaulted log directory with
+	// multiple stores into an error.
+	strictLogDir bool
+	// strictStoreSize turns store sizes exceeding the capacity of their
+	// device into an error instead of a warning.
+	strictStoreSize bool
+
+	// reportConfigFile, if set, is the file to which the resolved server
+	// configuration is written as JSON during startup.
+	reportConfigFile string
+
+	// startupMetricsFile, if set, is the file to which startup metrics are
+	// written in the Prometheus text format once the node has started.
+	startupMetricsFile string
+
+	// localityFile, if set, is a file from which locality tiers are read and
+	// merged with --locality.
+	localityFile string
+
+	// shutdownSignals, if non-empty, restricts the signals that trigger a
+	// graceful shutdown. If empty, SIGINT, SIGTERM and SIGQUIT all do.
+	shutdownSignals shutdownSignalsValue
+	// shutdownLog selects what is logged periodically during a graceful
+	// shutdown.
+	shutdownLog shutdownLogMode
+	// joinClockCheck selects what happens when the clock of a --join target
+	// is offset from the local clock by too much.
+	joinClockCheck joinClockCheckMode
+	// resolveAdvertiseHost makes the node advertise the IP address its
+	// advertised host name resolves to when it starts.
+	resolveAdvertiseHost bool
+
+	// onReadyExec, if set, is an executable run once the node has started.
+	onReadyExec string
+	// onReadyStrict makes a failure of onReadyExec fatal.
+	onReadyStrict bool
+
+	// maxGoMemory, if positive, is the soft memory limit for the Go runtime.
+	maxGoMemory int64
+	// maxGoProcs, if positive, overrides GOMAXPROCS.
+	maxGoProcs int
+	// cpuProfileOnStart, if positive, is the maximum duration of the cpu
+	// profile of the node startup.
+	cpuProfileOnStart time.Duration
+	// disableUpdateCheck turns off the periodic check for new releases.
+	disableUpdateCheck bool
+	// disableProfiling turns off all the background profilers. See
+	// profilingDisabled.
+	disableProfiling bool
+
+	// connectionInfoFile, if set, is the file to which the parameters needed
+	// to connect to the node are written, as JSON, once the node has started.
+	connectionInfoFile string
+
+	// tempDirCleanup controls what happens to abandoned temporary
+	// directories listed in the first store's temp dirs record file.
+	tempDirCleanup tempDirCleanupMode
+
+	// dirMode is the mode of the directories created by the start command.
+	dirMode dirModeValue
+}
+
+// tempDirCleanupMode is an implementation of pflag.Value for the
+// --temp-dir-cleanup flag.
+type tempDirCleanupMode int
+
+const (
+	// tempDirCleanupOn removes abandoned temporary directories. This is the
+	// default.
+	tempDirCleanupOn tempDirCleanupMode = iota
+	// tempDirCleanupDryRun logs the abandoned temporary directories that
+	// would be removed, but leaves them in place.
+	tempDirCleanupDryRun
+	// tempDirCleanupOff leaves abandoned temporary directories alone.
+	tempDirCleanupOff
+)
+
+// Type implements the pflag.Value interface.
+func (m *tempDirCleanupMode) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (m *tempDirCleanupMode) String() string {
+	switch *m {
+	case tempDirCleanupOn:
+		return "on"
+	case tempDirCleanupDryRun:
+		return "dry-run"
+	case tempDirCleanupOff:
+		return "off"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (m *tempDirCleanupMode) Set(s string) error {
+	switch s {
+	case "on":
+		*m = tempDirCleanupOn
+	case "dry-run":
+		*m = tempDirCleanupDryRun
+	case "off":
+		*m = tempDirCleanupOff
+	default:
+		return fmt.Errorf("invalid temp dir cleanup mode: %s (possible values: on, dry-run, off)", s)
+	}
+	return nil
+}
+
+// dirModeValue is an implementation of pflag.Value for the --dir-mode flag of
+// the start command, which accepts a file mode in octal. The zero value stands
+// for defaultDirMode.
+type dirModeValue os.FileMode
+
+// defaultDirMode is the mode of the directories created by the start command
+// when --dir-mode isn't given.
+const defaultDirMode os.FileMode = 0755
+
+// mode returns the file mode to create directories with.
+func (m *dirModeValue) mode() os.FileMode {
+	if *m == 0 {
+		return defaultDirMode
+	}
+	return os.FileMode(*m)
+}
+
+// Type implements the pflag.Value interface.
+func (m *dirModeValue) Type() string { return "octal" }
+
+// String implements the pflag.Value interface.
+func (m *dirModeValue) String() string {
+	return fmt.Sprintf("%04o", uint32(m.mode()))
+}
+
+// Set implements the pflag.Value interface.
+func (m *dirModeValue) Set(s string) error {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid directory mode: %s (must be an octal mode such as 0750)", s)
+	}
+	// The node must be able to use the directories it creates.
+	if mode&0700 != 0700 {
+		return fmt.Errorf("invalid directory mode: %s (must grant all permissions to the owner)", s)
+	}
+	*m = dirModeValue(mode)
+	return nil
+}
+
+// shutdownSignalNames maps the names accepted by --shutdown-on to signals.
+var shutdownSignalNames = map[string]os.Signal{
+	"int":  syscall.SIGINT,
+	"term": syscall.SIGTERM,
+	"quit": syscall.SIGQUIT,
+}
+
+// shutdownSignalsValue is an implementation of pflag.Value that accepts
+// signal names, either comma-separated or via repeated flags.
+type shutdownSignalsValue []os.Signal
+
+// String implements the pflag.Value interface.
+func (v *shutdownSignalsValue) String() string {
+	var names []string
+	for _, sig := range *v {
+		for name, s := range shutdownSignalNames {
+			if s == sig {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Type implements the pflag.Value interface.
+func (v *shutdownSignalsValue) Type() string {
+	return "string"
+}
+
+// Set implements the pflag.Value interface.
+func (v *shutdownSignalsValue) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		sig, ok := shutdownSignalNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("invalid signal: %s (possible values: int, term, quit)", name)
+		}
+		found := false
+		for _, s := range *v {
+			found = found || s == sig
+		}
+		if !found {
+			*v = append(*v, sig)
+		}
+	}
+	return nil
+}
+
+// shutdownLogMode is an implementation of pflag.Value for the --shutdown-log
+// flag of the start command.
+type shutdownLogMode int
+
+const (
+	// shutdownLogCount periodically logs the number of running tasks. This is
+	// the default.
+	shutdownLogCount shutdownLogMode = iota
+	// shutdownLogSilent logs nothing periodically.
+	shutdownLogSilent
+	// shutdownLogTasks periodically logs the running tasks by call site.
+	shutdownLogTasks
+)
+
+// Type implements the pflag.Value interface.
+func (m *shutdownLogMode) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (m *shutdownLogMode) String() string {
+	switch *m {
+	case shutdownLogCount:
+		return "count"
+	case shutdownLogSilent:
+		return "silent"
+	case shutdownLogTasks:
+		return "tasks"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (m *shutdownLogMode) Set(s string) error {
+	switch s {
+	case "count":
+		*m = shutdownLogCount
+	case "silent":
+		*m = shutdownLogSilent
+	case "tasks":
+		*m = shutdownLogTasks
+	default:
+		return fmt.Errorf("invalid shutdown log mode: %s (possible values: silent, count, tasks)", s)
+	}
+	return nil
+}
+
+// joinClockCheckMode is an implementation of pflag.Value for the
+// --join-clock-check flag of the start command.
+type joinClockCheckMode int
+
+const (
+	// joinClockCheckWarn warns about excessive clock offsets. This is the
+	// default.
+	joinClockCheckWarn joinClockCheckMode = iota
+	// joinClockCheckStrict refuses to start on excessive clock offsets.
+	joinClockCheckStrict
+	// joinClockCheckOff doesn't measure the clock offsets.
+	joinClockCheckOff
+)
+
+// Type implements the pflag.Value interface.
+func (m *joinClockCheckMode) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (m *joinClockCheckMode) String() string {
+	switch *m {
+	case joinClockCheckWarn:
+		return "warn"
+	case joinClockCheckStrict:
+		return "strict"
+	case joinClockCheckOff:
+		return "off"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (m *joinClockCheckMode) Set(s string) error {
+	switch s {
+	case "warn":
+		*m = joinClockCheckWarn
+	case "strict":
+		*m = joinClockCheckStrict
+	case "off":
+		*m = joinClockCheckOff
+	default:
+		return fmt.Errorf("invalid join clock check mode: %s (possible values: warn, strict, off)", s)
+	}
+	return nil
+}
+
+// logFormat is an implementation of pflag.Value for the --log-format flag
+// of the start command.
+type logFormat int
+
+const (
+	// logFormatText logs multi-line, human-readable messages. This is the
+	// default.
+	logFormatText logFormat = iota
+	// logFormatJSON logs structured messages as single-line JSON objects.
+	logFormatJSON
+)
+
+// Type implements the pflag.Value interface.
+func (f *logFormat) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (f *logFormat) String() string {
+	switch *f {
+	case logFormatText:
+		return "text"
+	case logFormatJSON:
+		return "json"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (f *logFormat) Set(s string) error {
+	switch s {
+	case "text":
+		*f = logFormatText
+	case "json":
+		*f = logFormatJSON
+	default:
+		return fmt.Errorf("invalid log format: %s (possible values: text, json)", s)
+	}
+	return nil
 }
 
 // quitCtx captures the command-line parameters of the `quit` command.
 var quitCtx struct {
 	serverDecommission bool
+	// decommissionWait, if non-zero, bounds the time spent waiting for
+	// decommissioning to complete before shutting down.
+	decommissionWait time.Duration
+	// decommissionStrict, when set, aborts the shutdown if decommissioning
+	// doesn't complete within decommissionWait.
+	decommissionStrict bool
+	// drainModes, if non-empty, restricts the drain phases requested from
+	// the server. If empty, server.GracefulDrainModes is used.
+	drainModes drainModesValue
+	// verify, when set, requests that quit confirms with the rest of the
+	// cluster that the node is no longer live before returning.
+	verify bool
+	// outputFormat selects how quit reports its outcome.
+	outputFormat quitOutputFormat
+	// yes skips the confirmation prompt shown when stdin is a terminal.
+	yes bool
+	// transferLeasesFirst, when set, moves the node's leases away before
+	// draining it.
+	transferLeasesFirst bool
+	// wait, when set, makes quit wait for the node to stop accepting
+	// connections on its address, for at most drainWait, before returning.
+	wait      bool
+	drainWait time.Duration
+	// maxClientDrain, if non-zero, bounds the time the server gives SQL
+	// clients to finish before their sessions are forcibly closed.
+	maxClientDrain time.Duration
+	// drainStrategy hints the server how to order the lease transfers.
+	drainStrategy drainStrategyValue
+	// probeTimeout, if non-zero, bounds the no-op drain request used to check
+	// that the node is responsive before draining it.
+	probeTimeout time.Duration
+}
+
+// quitOutputFormat is an implementation of pflag.Value for the --format flag
+// of the quit command.
+type quitOutputFormat int
+
+const (
+	// quitOutputText prints human-readable progress messages. This is the
+	// default.
+	quitOutputText quitOutputFormat = iota
+	// quitOutputJSON prints a single JSON object summarizing the outcome.
+	quitOutputJSON
+)
+
+// Type implements the pflag.Value interface.
+func (f *quitOutputFormat) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (f *quitOutputFormat) String() string {
+	switch *f {
+	case quitOutputText:
+		return "text"
+	case quitOutputJSON:
+		return "json"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (f *quitOutputFormat) Set(s string) error {
+	switch s {
+	case "text":
+		*f = quitOutputText
+	case "json":
+		*f = quitOutputJSON
+	default:
+		return fmt.Errorf("invalid quit output format: %s (possible values: text, json)", s)
+	}
+	return nil
+}
+
+// versionCtx captures the command-line parameters of the `version` command.
+var versionCtx struct {
+	// outputFormat selects how the build information is printed.
+	outputFormat versionOutputFormat
+}
+
+// versionOutputFormat is an implementation of pflag.Value for the --format
+// flag of the version command.
+type versionOutputFormat int
+
+const (
+	// versionOutputText prints the build information as a human-readable
+	// table. This is the default.
+	versionOutputText versionOutputFormat = iota
+	// versionOutputJSON prints the build information as a single JSON object.
+	versionOutputJSON
+)
+
+// Type implements the pflag.Value interface.
+func (f *versionOutputFormat) Type() string { return "string" }
+
+// String implements the pflag.Value interface.
+func (f *versionOutputFormat) String() string {
+	switch *f {
+	case versionOutputText:
+		return "text"
+	case versionOutputJSON:
+		return "json"
+	}
+	return ""
+}
+
+// Set implements the pflag.Value interface.
+func (f *versionOutputFormat) Set(s string) error {
+	switch s {
+	case "text":
+		*f = versionOutputText
+	case "json":
+		*f = versionOutputJSON
+	default:
+		return fmt.Errorf("invalid version output format: %s (possible values: text, json)", s)
+	}
+	return nil
+}
+
+// drainModesValue is an implementation of pflag.Value that accepts
+// drain mode names, either comma-separated or via repeated flags.
+type drainModesValue []serverpb.DrainMode
+
+// String implements the pflag.Value interface.
+func (d *drainModesValue) String() string {
+	names := make([]string, len(*d))
+	for i, m := range *d {
+		names[i] = strings.ToLower(m.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// Type implements the pflag.Value interface.
+func (d *drainModesValue) Type() string {
+	return "string"
+}
+
+// Set implements the pflag.Value interface.
+func (d *drainModesValue) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		mode, ok := serverpb.DrainMode_value[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			var names []string
+			for _, row := range drainModesToRows() {
+				names = append(names, row[0])
+			}
+			return fmt.Errorf("invalid drain mode: %s "+
+				"(possible values: %s)", name, strings.Join(names, ", "))
+		}
+		found := false
+		for _, m := range *d {
+			if m == serverpb.DrainMode(mode) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*d = append(*d, serverpb.DrainMode(mode))
+		}
+	}
+	return nil
+}
+
+// drainStrategyValue is an implementation of pflag.Value for the
+// --drain-strategy flag of the quit command.
+type drainStrategyValue serverpb.DrainStrategy
+
+// String implements the pflag.Value interface.
+func (d *drainStrategyValue) String() string {
+	return strings.ToLower(serverpb.DrainStrategy(*d).String())
+}
+
+// Type implements the pflag.Value interface.
+func (d *drainStrategyValue) Type() string {
+	return "string"
+}
+
+// Set implements the pflag.Value interface.
+func (d *drainStrategyValue) Set(value string) error {
+	strategy, ok := serverpb.DrainStrategy_value[strings.ToUpper(value)]
+	if !ok {
+		return fmt.Errorf("invalid drain strategy: %s (possible values: fast, balanced)", value)
+	}
+	*d = drainStrategyValue(strategy)
+	return nil
 }
 
 // nodeCtx captures the command-line parameters of the `node` command.
@@ -322,6 +872,15 @@ var nodeCtx = struct {
 	statusShowStats        bool
 	statusShowDecommission bool
 	statusShowAll          bool
+	// drainWaitTimeout, if non-zero, bounds the time node wait-for-drain
+	// waits for the node to be drained.
+	drainWaitTimeout time.Duration
+	// healthcheckTimeout bounds the time node healthcheck waits for the
+	// node to respond.
+	healthcheckTimeout time.Duration
+	// healthcheckReadiness makes node healthcheck also require the node to
+	// accept SQL clients.
+	healthcheckReadiness bool
 }{
 	nodeDecommissionWait: nodeDecommissionWaitAll,
 }
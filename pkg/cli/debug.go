@@ -18,7 +18,10 @@ package cli
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -44,6 +47,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/gogo/protobuf/jsonpb"
+	"github.com/google/pprof/profile"
 	"github.com/kr/pretty"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -704,7 +708,13 @@ var debugEnvCmd = &cobra.Command{
 	Use:   "env",
 	Short: "output environment settings",
 	Long: `
-Output environment variables that influence configuration.
+Output environment variables that influence configuration, sorted by name,
+along with their current value and their default. Values that may hold secrets
+(passwords, tokens, credentials) are redacted. COCKROACH_* variables that are
+set but not used, e.g. because they are misspelled, are listed last.
+
+Only the variables read when the binary initializes are listed; a few are read
+only once a node starts, and are logged by the node instead.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		env := envutil.GetEnvReport()
@@ -712,6 +722,126 @@ Output environment variables that influence configuration.
 	},
 }
 
+var debugProfileNameCmd = &cobra.Command{
+	Use:   "profile-name [file]",
+	Short: "decode the name of a profile file",
+	Long: `
+Print the prefix and the time encoded in the name of a profile file written by
+a node, e.g. memprof.2006-01-02T15_04_05.999 or memprof.n7.2006-01-02T15_04_05.999.
+`,
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return usageAndError(cmd)
+		}
+		prefix, t, err := parseProfileName(filepath.Base(args[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", prefix, t)
+		return nil
+	},
+}
+
+var debugVerifyProfilesCmd = &cobra.Command{
+	Use:   "verify-profiles [directory]",
+	Short: "check profile files for corruption",
+	Long: `
+Check that the jemalloc, go heap and cpu profiles written by a node in the given
+directory (e.g. a log directory from a debug zip) can be parsed, and report
+the ones that are corrupt or truncated. Jemalloc profiles are not pprof
+profiles; only their header and their trailing list of mapped libraries are
+checked.
+`,
+	RunE: runDebugVerifyProfiles,
+}
+
+func runDebugVerifyProfiles(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return usageAndError(cmd)
+	}
+	checked, corrupt, err := verifyProfiles(args[0], os.Stdout)
+	if err != nil {
+		return err
+	}
+	if corrupt > 0 {
+		return errors.Errorf("%d of %d profiles are corrupt", corrupt, checked)
+	}
+	fmt.Printf("%d profiles ok\n", checked)
+	return nil
+}
+
+// verifyProfiles parses each profile file in dir, as recognized by
+// parseProfileName, and reports the corrupt ones to out. It returns the number
+// of profiles checked and the number of corrupt ones.
+func verifyProfiles(dir string, out io.Writer) (checked, corrupt int, _ error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		// Profiles may be named with a COCKROACH_PROFILE_PREFIX, which need not
+		// be the one of this process.
+		prefix, _, err := parseProfileName(fi.Name())
+		if err != nil || !(strings.HasSuffix(prefix, jeprof) ||
+			strings.HasSuffix(prefix, memprof) || strings.HasSuffix(prefix, cpuprof)) {
+			continue
+		}
+		checked++
+		verify := verifyProfile
+		if strings.HasSuffix(prefix, jeprof) {
+			verify = verifyJemallocProfile
+		}
+		if err := verify(filepath.Join(dir, fi.Name())); err != nil {
+			corrupt++
+			fmt.Fprintf(out, "%s: %s\n", fi.Name(), err)
+		}
+	}
+	return checked, corrupt, nil
+}
+
+// verifyProfile returns an error if the profile at path can't be parsed.
+func verifyProfile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return err
+	}
+	return p.CheckValid()
+}
+
+// jemallocProfileHeader starts the profiles written by jemalloc's prof.dump,
+// and jemallocMappedLibraries introduces the copy of /proc/self/maps that ends
+// them.
+const (
+	jemallocProfileHeader   = "heap_v2/"
+	jemallocMappedLibraries = "\nMAPPED_LIBRARIES:\n"
+)
+
+// verifyJemallocProfile returns an error if the jemalloc profile at path
+// lacks its header or its trailing list of mapped libraries. These profiles
+// are in jemalloc's own format, which the pprof library doesn't read.
+func verifyJemallocProfile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(jemallocProfileHeader)) {
+		return errors.Errorf("not a jemalloc profile: missing %q header", jemallocProfileHeader)
+	}
+	if !bytes.Contains(data, []byte(jemallocMappedLibraries)) || !bytes.HasSuffix(data, []byte("\n")) {
+		return errors.New("truncated jemalloc profile: missing the mapped libraries")
+	}
+	return nil
+}
+
 var debugCompactCmd = &cobra.Command{
 	Use:   "compact [directory]",
 	Short: "compact the sstables in a store",
@@ -807,7 +937,7 @@ func runDebugGossipValues(cmd *cobra.Command, args []string) error {
 			return errors.Wrap(err, "failed to parse provided file as gossip.InfoStatus")
 		}
 	} else {
-		conn, _, stopper, err := getClientGRPCConn()
+		conn, _, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 		if err != nil {
 			return err
 		}
@@ -907,6 +1037,8 @@ var debugCmds = []*cobra.Command{
 	rangeCmd,
 	debugEnvCmd,
 	debugZipCmd,
+	debugProfileNameCmd,
+	debugVerifyProfilesCmd,
 }
 
 var debugCmd = &cobra.Command{
@@ -18,17 +18,24 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/kr/text"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/cli/cliflags"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/server"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logflags"
@@ -42,6 +49,7 @@ var serverHTTPHost, serverHTTPPort string
 var clientConnHost, clientConnPort string
 var tempDir string
 var externalIODir string
+var externalIORoot string
 
 const usageIndentation = 8
 const wrapWidth = 79 - usageIndentation
@@ -108,6 +116,27 @@ func setFlagFromEnv(f *pflag.FlagSet, flagInfo cliflags.FlagInfo) {
 	}
 }
 
+// applyQuitTimeoutEnv sets drainWait, the value of --drain-wait, from the
+// COCKROACH_QUIT_TIMEOUT environment variable unless the flag was specified.
+// The variable can't be the EnvVar of the flag since its name doesn't match
+// the flag's.
+func applyQuitTimeoutEnv(f *pflag.FlagSet, drainWait *time.Duration) {
+	if f.Changed(cliflags.QuitDrainWait.Name) {
+		return
+	}
+	*drainWait = envutil.EnvOrDefaultDuration("COCKROACH_QUIT_TIMEOUT", *drainWait)
+}
+
+// applySkipUpdateCheckEnv sets disable, the value of --disable-update-check,
+// from the COCKROACH_SKIP_UPDATE_CHECK environment variable unless the flag
+// was specified. The variable predates the flag, whose name it doesn't match.
+func applySkipUpdateCheckEnv(f *pflag.FlagSet, disable *bool) {
+	if f.Changed(cliflags.DisableUpdateCheck.Name) {
+		return
+	}
+	*disable = envutil.EnvOrDefaultBool("COCKROACH_SKIP_UPDATE_CHECK", *disable)
+}
+
 func stringFlag(f *pflag.FlagSet, valPtr *string, flagInfo cliflags.FlagInfo, defaultVal string) {
 	f.StringVarP(valPtr, flagInfo.Name, flagInfo.Shorthand, defaultVal, makeUsageString(flagInfo))
 
@@ -161,6 +190,9 @@ func init() {
 
 	// The following only runs for `start`.
 	startCmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		if err := loadStartConfigFile(cmd.Flags()); err != nil {
+			return err
+		}
 		extraServerFlagInit()
 		return setDefaultStderrVerbosity(cmd, log.Severity_INFO)
 	}
@@ -207,12 +239,15 @@ func init() {
 		stringFlag(f, &serverConnPort, cliflags.ServerPort, base.DefaultPort)
 		stringFlag(f, &serverAdvertiseHost, cliflags.AdvertiseHost, "")
 		stringFlag(f, &serverAdvertisePort, cliflags.AdvertisePort, "")
+		boolFlag(f, &startCtx.resolveAdvertiseHost, cliflags.ResolveAdvertiseHost, false)
 		// The advertise port flag is used for testing purposes only and is kept hidden.
 		_ = f.MarkHidden(cliflags.AdvertisePort.Name)
 		stringFlag(f, &serverHTTPHost, cliflags.ServerHTTPHost, "")
 		stringFlag(f, &serverHTTPPort, cliflags.ServerHTTPPort, base.DefaultHTTPPort)
+		stringFlag(f, &serverCfg.HTTPAdvertiseAddr, cliflags.HTTPAdvertiseAddr, "")
 		stringFlag(f, &serverCfg.Attrs, cliflags.Attrs, serverCfg.Attrs)
 		varFlag(f, &serverCfg.Locality, cliflags.Locality)
+		stringFlag(f, &startCtx.localityFile, cliflags.LocalityFile, "")
 
 		varFlag(f, &serverCfg.Stores, cliflags.Store)
 		varFlag(f, &serverCfg.MaxOffset, cliflags.MaxOffset)
@@ -228,9 +263,29 @@ func init() {
 
 		stringFlag(f, &serverCfg.PIDFile, cliflags.PIDFile, "")
 
+		stringFlag(f, &startCtx.startupSummaryFile, cliflags.StartupSummaryFile, "")
+		varFlag(f, &startCtx.logFormat, cliflags.LogFormat)
+		stringFlag(f, &startCtx.startupMetricsFile, cliflags.StartupMetricsFile, "")
+		stringFlag(f, &startCtx.reportConfigFile, cliflags.ReportConfigFile, "")
+		boolFlag(f, &startCtx.strictLogDir, cliflags.StrictLogDir, false)
+		boolFlag(f, &startCtx.strictStoreSize, cliflags.StrictStoreSize, false)
+		stringFlag(f, &startCtx.configFile, cliflags.StartConfigFile, "")
+		varFlag(f, &startCtx.shutdownLog, cliflags.ShutdownLog)
+		boolFlag(f, &startCtx.perStoreLogs, cliflags.PerStoreLogs, false)
+		stringFlag(f, &startCtx.expectedClusterID, cliflags.ClusterID, "")
+		boolFlag(f, &startCtx.verifyStoreClusterID, cliflags.VerifyStoreClusterID, false)
+		boolFlag(f, &startCtx.verifyTempStorage, cliflags.VerifyTempStorage, false)
+		varFlag(f, &startCtx.joinClockCheck, cliflags.JoinClockCheck)
+		stringFlag(f, &startCtx.connectionInfoFile, cliflags.ConnectionInfoFile, "")
+		varFlag(f, &startCtx.shutdownSignals, cliflags.ShutdownOn)
+		stringFlag(f, &startCtx.onReadyExec, cliflags.OnReadyExec, "")
+		boolFlag(f, &startCtx.onReadyStrict, cliflags.OnReadyStrict, false)
+
 		// Use a separate variable to store the value of ServerInsecure.
 		// We share the default with the ClientInsecure flag.
 		boolFlag(f, &startCtx.serverInsecure, cliflags.ServerInsecure, baseCfg.Insecure)
+		boolFlag(f, &startCtx.serverSecure, cliflags.ServerSecure, !baseCfg.Insecure)
+		varFlag(f, &serverCfg.InsecureAllow, cliflags.InsecureAllow)
 
 		// Certificates directory. Use a server-specific flag and value to ignore environment
 		// variables, but share the same default.
@@ -242,12 +297,21 @@ func init() {
 		// Engine flags.
 		varFlag(f, cacheSizeValue, cliflags.Cache)
 		varFlag(f, sqlSizeValue, cliflags.SQLMem)
+		varFlag(f, goMemoryValue, cliflags.GoMem)
+		intFlag(f, &startCtx.maxGoProcs, cliflags.GoProcs, 0)
+		durationFlag(f, &startCtx.cpuProfileOnStart, cliflags.CPUProfileOnStart, 0)
+		boolFlag(f, &startCtx.disableProfiling, cliflags.DisableProfiling, false)
+		boolFlag(f, &startCtx.disableUpdateCheck, cliflags.DisableUpdateCheck, false)
 		// N.B. diskTempStorageSizeValue.ResolvePercentage() will be called after
 		// the stores flag has been parsed and the storage device that a percentage
 		// refers to becomes known.
 		varFlag(f, diskTempStorageSizeValue, cliflags.SQLTempStorage)
 		stringFlag(f, &tempDir, cliflags.TempDir, "")
+		varFlag(f, &startCtx.tempDirCleanup, cliflags.TempDirCleanup)
+		varFlag(f, &startCtx.dirMode, cliflags.DirMode)
+		stringFlag(f, &startCtx.tempDirPrefix, cliflags.TempDirPrefix, server.TempDirPrefix)
 		stringFlag(f, &externalIODir, cliflags.ExternalIODir, "")
+		stringFlag(f, &externalIORoot, cliflags.ExternalIORoot, "")
 	}
 
 	for _, cmd := range certCmds {
@@ -304,6 +368,22 @@ func init() {
 		stringFlag(f, &baseCfg.SSLCertsDir, cliflags.CertsDir, base.DefaultCertsDirectory)
 	}
 
+	// Commands that connect to the server via RPC.
+	rpcClientCmds := []*cobra.Command{
+		debugGossipValuesCmd,
+		debugZipCmd,
+		genHAProxyCmd,
+		quitCmd,
+		initCmd,
+	}
+	rpcClientCmds = append(rpcClientCmds, rangeCmds...)
+	rpcClientCmds = append(rpcClientCmds, nodeCmds...)
+	for _, cmd := range rpcClientCmds {
+		f := cmd.PersistentFlags()
+		intFlag(f, &cliCtx.connectRetries, cliflags.ConnectRetries, 0)
+		durationFlag(f, &cliCtx.connectTimeout, cliflags.ConnectTimeout, 0)
+	}
+
 	// Node Status command.
 	{
 		f := statusNodeCmd.Flags()
@@ -316,8 +396,33 @@ func init() {
 	// Decommission command.
 	varFlag(decommissionNodeCmd.Flags(), &nodeCtx.nodeDecommissionWait, cliflags.Wait)
 
+	// Wait-for-drain command.
+	durationFlag(waitForDrainNodeCmd.Flags(), &nodeCtx.drainWaitTimeout, cliflags.DrainWaitTimeout, 0)
+
+	// Node healthcheck command.
+	{
+		f := healthcheckNodeCmd.Flags()
+		durationFlag(f, &nodeCtx.healthcheckTimeout, cliflags.HealthcheckTimeout, 5*time.Second)
+		boolFlag(f, &nodeCtx.healthcheckReadiness, cliflags.HealthcheckReadiness, false)
+	}
+
 	// Quit command.
 	boolFlag(quitCmd.Flags(), &quitCtx.serverDecommission, cliflags.Decommission, false)
+	durationFlag(quitCmd.Flags(), &quitCtx.decommissionWait, cliflags.DecommissionWait, 0)
+	boolFlag(quitCmd.Flags(), &quitCtx.decommissionStrict, cliflags.DecommissionStrict, false)
+	varFlag(quitCmd.Flags(), &quitCtx.drainModes, cliflags.DrainMode)
+	boolFlag(quitCmd.Flags(), &quitCtx.verify, cliflags.QuitVerify, false)
+	varFlag(quitCmd.Flags(), &quitCtx.outputFormat, cliflags.QuitOutputFormat)
+	boolFlag(quitCmd.Flags(), &quitCtx.yes, cliflags.QuitYes, false)
+	boolFlag(quitCmd.Flags(), &quitCtx.transferLeasesFirst, cliflags.TransferLeasesFirst, false)
+	boolFlag(quitCmd.Flags(), &quitCtx.wait, cliflags.QuitWait, false)
+	durationFlag(quitCmd.Flags(), &quitCtx.drainWait, cliflags.QuitDrainWait, time.Minute)
+	durationFlag(quitCmd.Flags(), &quitCtx.maxClientDrain, cliflags.MaxClientDrain, 0)
+	varFlag(quitCmd.Flags(), &quitCtx.drainStrategy, cliflags.DrainStrategy)
+	durationFlag(quitCmd.Flags(), &quitCtx.probeTimeout, cliflags.DrainNoopCheckTimeout, 5*time.Second)
+
+	// Version command.
+	varFlag(versionCmd.Flags(), &versionCtx.outputFormat, cliflags.VersionOutputFormat)
 
 	zf := setZoneCmd.Flags()
 	stringFlag(zf, &zoneCtx.zoneConfig, cliflags.ZoneConfig, "")
@@ -386,6 +491,11 @@ func init() {
 	}
 }
 
+// extraServerFlagInit computes the server addresses from the individual
+// host/port flags. The listen address is always --host:--port. The advertised
+// address defaults to the listen address, with --advertise-host and
+// --advertise-port taking precedence when given. The HTTP address uses
+// --http-host, falling back to --host.
 func extraServerFlagInit() {
 	serverCfg.Addr = net.JoinHostPort(serverConnHost, serverConnPort)
 	if serverAdvertiseHost == "" {
@@ -410,6 +520,86 @@ func extraClientFlagInit() {
 	serverCfg.HTTPAddr = net.JoinHostPort(serverHTTPHost, serverHTTPPort)
 }
 
+// defaultStartConfigFiles returns the locations, in order of preference, of
+// the config file read by start when --config is not specified.
+func defaultStartConfigFiles() []string {
+	var res []string
+	if configHome, err := envutil.ConfigHomeDir(); err == nil {
+		res = append(res, filepath.Join(configHome, "cockroach", "config.yaml"))
+	}
+	return append(res, "/etc/cockroach/config.yaml")
+}
+
+// loadStartConfigFile sets the start flags that were not specified on the
+// command line from the config file given by --config or, failing that, the
+// first of the default config files that exists, if any.
+func loadStartConfigFile(fs *pflag.FlagSet) error {
+	path := startCtx.configFile
+	if path == "" {
+		for _, p := range defaultStartConfigFiles() {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			return nil
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to read config file")
+	}
+	if err := applyConfigFile(fs, data); err != nil {
+		return errors.Wrapf(err, "invalid config file %s", path)
+	}
+	startCtx.loadedConfigFile = path
+	return nil
+}
+
+// applyConfigFile sets the flags in fs from the YAML mapping of flag names to
+// values in data. Flags that were already specified are left alone, so that
+// the command line overrides the config file. A list of values sets a flag
+// that can be repeated (e.g. --store) once per value.
+func applyConfigFile(fs *pflag.FlagSet, data []byte) error {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Apply the flags in a deterministic order.
+	sort.Strings(names)
+	for _, name := range names {
+		if name == cliflags.StartConfigFile.Name {
+			return errors.Errorf("--%s cannot be set from a config file", name)
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return errors.Errorf("unknown flag %q", name)
+		}
+		if f.Changed {
+			continue
+		}
+		vals, ok := values[name].([]interface{})
+		if !ok {
+			vals = []interface{}{values[name]}
+		}
+		for _, v := range vals {
+			switch v.(type) {
+			case []interface{}, map[interface{}]interface{}:
+				return errors.Errorf("invalid value for %q: %v", name, v)
+			}
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return errors.Wrapf(err, "invalid value for %q", name)
+			}
+		}
+	}
+	return nil
+}
+
 func setDefaultStderrVerbosity(cmd *cobra.Command, defaultSeverity log.Severity) error {
 	pf := cmd.Flags()
 
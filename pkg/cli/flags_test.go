@@ -16,15 +16,20 @@ package cli
 
 import (
 	"flag"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/cli/cliflags"
+	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/spf13/pflag"
 )
 
 func TestStdFlagToPflag(t *testing.T) {
@@ -107,6 +112,45 @@ func TestClockOffsetFlagValue(t *testing.T) {
 	}
 }
 
+func TestApplyConfigFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	newFlagSet := func() (*pflag.FlagSet, *string, *int, *[]string) {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		return fs, fs.String("host", "", ""), fs.Int("port", 0, ""), fs.StringSlice("store", nil, "")
+	}
+
+	fs, host, port, stores := newFlagSet()
+	if err := fs.Parse([]string{"--port", "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("host: example.com\nport: 26257\nstore: [/mnt/1, /mnt/2]\n")
+	if err := applyConfigFile(fs, data); err != nil {
+		t.Fatal(err)
+	}
+	// The command line overrides the config file.
+	if *host != "example.com" || *port != 1234 || strings.Join(*stores, ",") != "/mnt/1,/mnt/2" {
+		t.Errorf("unexpected flags: host=%s port=%d store=%v", *host, *port, *stores)
+	}
+
+	for _, tc := range []struct {
+		data     string
+		expected string
+	}{
+		{"cache: 1GiB\n", `unknown flag "cache"`},
+		{"port: abc\n", `invalid value for "port"`},
+		{"host: {a: b}\n", `invalid value for "host"`},
+		{"config: other.yaml\n", "--config cannot be set from a config file"},
+		{"- host\n", "cannot unmarshal"},
+	} {
+		fs, _, _, _ := newFlagSet()
+		fs.String("config", "", "")
+		if err := applyConfigFile(fs, []byte(tc.data)); !testutils.IsError(err, tc.expected) {
+			t.Errorf("%q: expected %q, got %v", tc.data, tc.expected, err)
+		}
+	}
+}
+
 func TestServerConnSettings(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -248,3 +292,301 @@ func TestHttpHostFlagValue(t *testing.T) {
 		}
 	}
 }
+
+func TestDrainModeFlagValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testData := []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{nil, "", ""},
+		{[]string{"--drain-mode", "client"}, "client", ""},
+		{[]string{"--drain-mode", "leases,client"}, "leases,client", ""},
+		{[]string{"--drain-mode", "client", "--drain-mode", "leases"}, "client,leases", ""},
+		{[]string{"--drain-mode", "client,client"}, "client", ""},
+		{[]string{"--drain-mode", "bogus"}, "", "invalid drain mode: bogus"},
+	}
+
+	f := quitCmd.Flags()
+	for i, td := range testData {
+		quitCtx.drainModes = nil
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if actual := quitCtx.drainModes.String(); td.expected != actual {
+			t.Errorf("%d: expected %q, but got %q", i, td.expected, actual)
+		}
+	}
+	quitCtx.drainModes = nil
+}
+
+func TestDrainStrategyFlag(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testData := []struct {
+		args     []string
+		expected serverpb.DrainStrategy
+		err      string
+	}{
+		{nil, serverpb.DrainStrategy_FAST, ""},
+		{[]string{"--drain-strategy", "fast"}, serverpb.DrainStrategy_FAST, ""},
+		{[]string{"--drain-strategy", "balanced"}, serverpb.DrainStrategy_BALANCED, ""},
+		{[]string{"--drain-strategy", "Balanced"}, serverpb.DrainStrategy_BALANCED, ""},
+		{[]string{"--drain-strategy", "bogus"}, 0,
+			`invalid drain strategy: bogus \(possible values: fast, balanced\)`},
+	}
+
+	f := quitCmd.Flags()
+	for i, td := range testData {
+		quitCtx.drainStrategy = 0
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if actual := serverpb.DrainStrategy(quitCtx.drainStrategy); td.expected != actual {
+			t.Errorf("%d: expected %s, but got %s", i, td.expected, actual)
+		}
+	}
+	quitCtx.drainStrategy = 0
+}
+
+func TestDrainModesToRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	expected := [][]string{
+		{"client", "0", "1", "stop accepting new SQL clients and wait for existing ones"},
+		{"leases", "1", "2", "transfer range leases away from the node"},
+		{"jobs", "2", "", "wait, for at most --drain-wait, for the jobs running on the node to checkpoint their progress"},
+	}
+	if rows := drainModesToRows(); !reflect.DeepEqual(expected, rows) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+	if err := new(drainModesValue).Set("bogus"); !testutils.IsError(err,
+		`invalid drain mode: bogus \(possible values: client, leases, jobs\)`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestShutdownOnFlagValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testData := []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{nil, "", ""},
+		{[]string{"--shutdown-on", "term"}, "term", ""},
+		{[]string{"--shutdown-on", "term,INT"}, "term,int", ""},
+		{[]string{"--shutdown-on", "term", "--shutdown-on", "term,quit"}, "term,quit", ""},
+		{[]string{"--shutdown-on", "hup"}, "", "invalid signal: hup"},
+	}
+
+	f := startCmd.Flags()
+	for i, td := range testData {
+		startCtx.shutdownSignals = nil
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if actual := startCtx.shutdownSignals.String(); td.expected != actual {
+			t.Errorf("%d: expected %q, but got %q", i, td.expected, actual)
+		}
+	}
+	startCtx.shutdownSignals = nil
+}
+
+func TestShutdownLogFlagValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testData := []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{nil, "count", ""},
+		{[]string{"--shutdown-log", "silent"}, "silent", ""},
+		{[]string{"--shutdown-log", "tasks"}, "tasks", ""},
+		{[]string{"--shutdown-log", "verbose"}, "", "invalid shutdown log mode: verbose"},
+	}
+
+	f := startCmd.Flags()
+	for i, td := range testData {
+		startCtx.shutdownLog = shutdownLogCount
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if actual := startCtx.shutdownLog.String(); td.expected != actual {
+			t.Errorf("%d: expected %q, but got %q", i, td.expected, actual)
+		}
+	}
+	startCtx.shutdownLog = shutdownLogCount
+}
+
+func TestDirModeFlagValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testData := []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{nil, "0755", ""},
+		{[]string{"--dir-mode", "0750"}, "0750", ""},
+		{[]string{"--dir-mode", "700"}, "0700", ""},
+		{[]string{"--dir-mode", "0755"}, "0755", ""},
+		{[]string{"--dir-mode", "0789"}, "", "invalid directory mode: 0789"},
+		{[]string{"--dir-mode", "rwxr-x---"}, "", "invalid directory mode: rwxr-x---"},
+		{[]string{"--dir-mode", "01755"}, "", "invalid directory mode: 01755"},
+		{[]string{"--dir-mode", "0640"}, "", "must grant all permissions to the owner"},
+	}
+
+	f := startCmd.Flags()
+	for i, td := range testData {
+		startCtx.dirMode = 0
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if actual := startCtx.dirMode.String(); td.expected != actual {
+			t.Errorf("%d: expected %q, but got %q", i, td.expected, actual)
+		}
+	}
+	startCtx.dirMode = 0
+}
+
+func TestDiskTempStoragePercentOfFreeFlagValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	f := startCmd.Flags()
+	testData := []struct {
+		args          []string
+		percentOfFree bool
+		err           string
+	}{
+		{[]string{"--max-disk-temp-storage", "10%"}, false, ""},
+		{[]string{"--max-disk-temp-storage", "10%free"}, true, ""},
+		{[]string{"--max-disk-temp-storage", "100%free"}, false, "out of range"},
+		{[]string{"--max-disk-temp-storage", "1GiB"}, false, ""},
+		{[]string{"--cache", "10%free"}, false, "unhandled size name"},
+	}
+
+	for i, td := range testData {
+		err := f.Parse(td.args)
+		if !testutils.IsError(err, td.err) {
+			t.Fatalf("%d: expected %q, but found %v", i, td.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if a := diskTempStorageSizeValue.isPercentOfFree(); a != td.percentOfFree {
+			t.Errorf("%d: expected percent of free %t, but got %t", i, td.percentOfFree, a)
+		}
+	}
+	diskTempStorageSizeValue.origVal = ""
+}
+
+func TestQuitDrainWaitPrecedence(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const envVar = "COCKROACH_QUIT_TIMEOUT"
+	defer envutil.ClearEnvCache()
+	defer func() {
+		if err := os.Unsetenv(envVar); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	testData := []struct {
+		env      string
+		args     []string
+		expected time.Duration
+	}{
+		{"", nil, time.Minute},
+		{"2m", nil, 2 * time.Minute},
+		{"2m", []string{"--drain-wait=30s"}, 30 * time.Second},
+		{"", []string{"--drain-wait=30s"}, 30 * time.Second},
+	}
+	for i, td := range testData {
+		envutil.ClearEnvCache()
+		if td.env != "" {
+			if err := os.Setenv(envVar, td.env); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Unsetenv(envVar); err != nil {
+			t.Fatal(err)
+		}
+		f := pflag.NewFlagSet("quit", pflag.ContinueOnError)
+		var drainWait time.Duration
+		durationFlag(f, &drainWait, cliflags.QuitDrainWait, time.Minute)
+		if err := f.Parse(td.args); err != nil {
+			t.Fatal(err)
+		}
+		applyQuitTimeoutEnv(f, &drainWait)
+		if drainWait != td.expected {
+			t.Errorf("%d: expected %s, but found %s", i, td.expected, drainWait)
+		}
+	}
+}
+
+func TestDisableUpdateCheckPrecedence(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const envVar = "COCKROACH_SKIP_UPDATE_CHECK"
+	defer envutil.ClearEnvCache()
+	defer func() {
+		if err := os.Unsetenv(envVar); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	testData := []struct {
+		env      string
+		args     []string
+		expected bool
+	}{
+		{"", nil, false},
+		{"1", nil, true},
+		{"1", []string{"--disable-update-check=false"}, false},
+		{"", []string{"--disable-update-check"}, true},
+	}
+	for i, td := range testData {
+		envutil.ClearEnvCache()
+		if td.env != "" {
+			if err := os.Setenv(envVar, td.env); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Unsetenv(envVar); err != nil {
+			t.Fatal(err)
+		}
+		f := pflag.NewFlagSet("start", pflag.ContinueOnError)
+		var disabled bool
+		boolFlag(f, &disabled, cliflags.DisableUpdateCheck, false)
+		if err := f.Parse(td.args); err != nil {
+			t.Fatal(err)
+		}
+		applySkipUpdateCheckEnv(f, &disabled)
+		if disabled != td.expected {
+			t.Errorf("%d: expected %t, but found %t", i, td.expected, disabled)
+		}
+	}
+}
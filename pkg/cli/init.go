@@ -59,7 +59,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 
 func getInitClient() (serverpb.InitClient, *stop.Stopper, error) {
 	// TODO(adam): This depends on servercfg which is a bit weird..
-	conn, _, stopper, err := getClientGRPCConn()
+	conn, _, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,27 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build go1.19
+
+package cli
+
+import "runtime/debug"
+
+// setGoMemoryLimit sets a soft memory limit for the Go runtime, which makes
+// the garbage collector work harder as the heap approaches the limit. It
+// returns whether the limit is supported by the Go version in use.
+func setGoMemoryLimit(limit int64) bool {
+	debug.SetMemoryLimit(limit)
+	return true
+}
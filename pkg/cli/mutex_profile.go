@@ -30,5 +30,6 @@ func init() {
 	// average 1/X events.
 	//
 	// The mutex profile can be viewed with `pprof http://HOST:PORT/debug/pprof/mutex`
-	runtime.SetMutexProfileFraction(envutil.EnvOrDefaultInt("COCKROACH_MUTEX_PROFILE_RATE", 0))
+	profilingConfig.mutexProfileFraction = envutil.EnvOrDefaultInt("COCKROACH_MUTEX_PROFILE_RATE", 0)
+	runtime.SetMutexProfileFraction(profilingConfig.mutexProfileFraction)
 }
@@ -16,19 +16,23 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server"
 	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
 	"github.com/cockroachdb/cockroach/pkg/server/status"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
@@ -458,12 +462,311 @@ func runRecommissionNode(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+var waitForDrainNodeCmd = &cobra.Command{
+	Use:   "wait-for-drain",
+	Short: "waits until the node is drained",
+	Long: `
+Waits until the node the command connects to reports that it is fully drained,
+i.e. that it no longer accepts client connections and that it no longer holds
+any range lease. The node is not asked to drain; this is meant to follow a
+drain that was initiated elsewhere.
+	`,
+	RunE: MaybeDecorateGRPCError(runWaitForDrainNode),
+}
+
+func runWaitForDrainNode(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return usageAndError(cmd)
+	}
+	c, stopper, err := getAdminClient()
+	if err != nil {
+		return err
+	}
+	ctx := stopperContext(stopper)
+	defer stopper.Stop(ctx)
+	sc, statusStopper, err := getStatusClient()
+	if err != nil {
+		return err
+	}
+	defer statusStopper.Stop(ctx)
+
+	if nodeCtx.drainWaitTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, nodeCtx.drainWaitTimeout)
+		defer cancel()
+	}
+	opts := retry.Options{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     5 * time.Second,
+	}
+	for r := retry.StartWithCtx(ctx, opts); r.Next(); {
+		drained, err := nodeDrained(ctx, c, sc)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Fprintln(stderr)
+			return err
+		}
+		if drained {
+			fmt.Fprintln(stderr)
+			fmt.Fprintln(os.Stdout, "ok")
+			return nil
+		}
+		fmt.Fprintf(stderr, ".")
+	}
+	fmt.Fprintln(stderr)
+	return errors.Errorf("node not drained after %s", nodeCtx.drainWaitTimeout)
+}
+
+// nodeDrained returns whether the node behind c and sc is fully drained, i.e.
+// whether the graceful drain modes are active and the node no longer holds
+// any range lease.
+func nodeDrained(
+	ctx context.Context, c serverpb.AdminClient, sc serverpb.StatusClient,
+) (bool, error) {
+	on, err := getDrainModes(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	if !drainModesActive(on, server.GracefulDrainModes) {
+		return false, nil
+	}
+	resp, err := sc.Ranges(ctx, &serverpb.RangesRequest{NodeId: "local"})
+	if err != nil {
+		return false, errors.Wrap(err, "unable to count the leases held by the node")
+	}
+	return countLocalLeases(resp.Ranges) == 0, nil
+}
+
+var healthcheckNodeCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "checks whether the node is healthy",
+	Long: `
+Checks whether the node the command connects to is live and exits with a
+non-zero status if it is not. With --readiness, the node must also be ready to
+accept SQL clients, i.e. not draining. The output is kept minimal so that the
+command can be used as a liveness or readiness probe.
+	`,
+	RunE: runHealthcheckNode,
+}
+
+func runHealthcheckNode(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return usageAndError(cmd)
+	}
+	c, stopper, err := getAdminClient()
+	if err != nil {
+		return err
+	}
+	ctx := stopperContext(stopper)
+	defer stopper.Stop(ctx)
+
+	if nodeCtx.healthcheckTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, nodeCtx.healthcheckTimeout)
+		defer cancel()
+	}
+	if _, err := c.Health(ctx, &serverpb.HealthRequest{Ready: nodeCtx.healthcheckReadiness}); err != nil {
+		if ctx.Err() != nil {
+			return errors.Errorf("no response after %s", nodeCtx.healthcheckTimeout)
+		}
+		return errors.Errorf("unhealthy: %s", grpc.ErrorDesc(err))
+	}
+	fmt.Fprintln(os.Stdout, "ok")
+	return nil
+}
+
+var quiesceNodeCmd = &cobra.Command{
+	Use:   "quiesce",
+	Short: "takes the node out of the SQL serving rotation",
+	Long: `
+Asks the node the command connects to to stop accepting new SQL clients, as in
+the client drain mode of quit, without transferring its range leases nor
+shutting it down: the node remains a full member of the cluster and keeps
+serving the ranges it holds leases for. The open SQL sessions are given the
+server's default drain wait to finish, after which they are canceled. This is
+meant for temporary maintenance and is reverted by unquiesce. A node restarted while
+quiesced accepts SQL clients again.
+	`,
+	RunE: MaybeDecorateGRPCError(runQuiesceNode),
+}
+
+func runQuiesceNode(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return usageAndError(cmd)
+	}
+	c, stopper, err := getAdminClient()
+	if err != nil {
+		return err
+	}
+	ctx := stopperContext(stopper)
+	defer stopper.Stop(ctx)
+
+	on, err := getDrainModesWithRequest(ctx, c, &serverpb.DrainRequest{
+		On: []int32{int32(serverpb.DrainMode_CLIENT)},
+	})
+	if err != nil {
+		return err
+	}
+	if !drainModesActive(on, []serverpb.DrainMode{serverpb.DrainMode_CLIENT}) {
+		return errors.New("the node did not enter the client drain mode")
+	}
+	fmt.Fprintln(os.Stdout, "ok")
+	return nil
+}
+
+var unquiesceNodeCmd = &cobra.Command{
+	Use:   "unquiesce",
+	Short: "puts the node back in the SQL serving rotation",
+	Long: `
+Asks the node the command connects to to accept new SQL clients again, after
+quiesce. The other drain modes, if any, are left alone.
+	`,
+	RunE: MaybeDecorateGRPCError(runUnquiesceNode),
+}
+
+func runUnquiesceNode(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return usageAndError(cmd)
+	}
+	c, stopper, err := getAdminClient()
+	if err != nil {
+		return err
+	}
+	ctx := stopperContext(stopper)
+	defer stopper.Stop(ctx)
+
+	on, err := getDrainModesWithRequest(ctx, c, &serverpb.DrainRequest{
+		Off: []int32{int32(serverpb.DrainMode_CLIENT)},
+	})
+	if err != nil {
+		return err
+	}
+	if drainModesActive(on, []serverpb.DrainMode{serverpb.DrainMode_CLIENT}) {
+		return errors.New("the node did not leave the client drain mode")
+	}
+	fmt.Fprintln(os.Stdout, "ok")
+	return nil
+}
+
+// getDrainModes returns the drain modes active on the node behind c, using
+// a Drain request that doesn't change them.
+func getDrainModes(ctx context.Context, c serverpb.AdminClient) ([]int32, error) {
+	return getDrainModesWithRequest(ctx, c, &serverpb.DrainRequest{})
+}
+
+// getDrainModesWithRequest sends req, which must not request a shutdown, and
+// returns the drain modes active on the node once it has been carried out.
+func getDrainModesWithRequest(
+	ctx context.Context, c serverpb.AdminClient, req *serverpb.DrainRequest,
+) ([]int32, error) {
+	stream, err := c.Drain(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error sending drain request")
+	}
+	var on []int32
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return on, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading drain status")
+		}
+		on = resp.On
+	}
+}
+
+// drainModesActive returns whether all the modes in want are among the
+// active drain modes in on.
+func drainModesActive(on []int32, want []serverpb.DrainMode) bool {
+	for _, w := range want {
+		found := false
+		for _, m := range on {
+			if serverpb.DrainMode(m) == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// drainModeDescriptions documents the drain modes defined by
+// serverpb.DrainMode. They match the help of --drain-mode.
+var drainModeDescriptions = map[serverpb.DrainMode]string{
+	serverpb.DrainMode_CLIENT: "stop accepting new SQL clients and wait for existing ones",
+	serverpb.DrainMode_LEASES: "transfer range leases away from the node",
+	serverpb.DrainMode_JOBS:   "wait, for at most --drain-wait, for the jobs running on the node to checkpoint their progress",
+}
+
+var drainModesColumnHeaders = []string{
+	"name",
+	"value",
+	"graceful_order",
+	"description",
+}
+
+var drainModesNodeCmd = &cobra.Command{
+	Use:   "drain-modes",
+	Short: "lists the drain modes a node supports",
+	Long: `
+Lists the drain modes that can be passed to quit --drain-mode, along with their
+numeric value and, for the modes entered by a graceful shutdown, the order in
+which they are entered.
+	`,
+	RunE: runDrainModesNode,
+}
+
+func runDrainModesNode(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return usageAndError(cmd)
+	}
+	return printQueryOutput(os.Stdout, drainModesColumnHeaders, newRowSliceIter(drainModesToRows()))
+}
+
+// drainModesToRows describes each drain mode, ordered by value.
+func drainModesToRows() [][]string {
+	var modes []serverpb.DrainMode
+	for value := range serverpb.DrainMode_name {
+		modes = append(modes, serverpb.DrainMode(value))
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+
+	rows := make([][]string, 0, len(modes))
+	for _, mode := range modes {
+		order := ""
+		for i, m := range server.GracefulDrainModes {
+			if m == mode {
+				order = strconv.Itoa(i + 1)
+			}
+		}
+		rows = append(rows, []string{
+			strings.ToLower(mode.String()),
+			strconv.Itoa(int(mode)),
+			order,
+			drainModeDescriptions[mode],
+		})
+	}
+	return rows
+}
+
 // Sub-commands for node command.
 var nodeCmds = []*cobra.Command{
 	lsNodesCmd,
 	statusNodeCmd,
 	decommissionNodeCmd,
 	recommissionNodeCmd,
+	waitForDrainNodeCmd,
+	drainModesNodeCmd,
+	healthcheckNodeCmd,
+	quiesceNodeCmd,
+	unquiesceNodeCmd,
 }
 
 var nodeCmd = &cobra.Command{
@@ -480,7 +783,7 @@ func init() {
 }
 
 func getStatusClient() (serverpb.StatusClient, *stop.Stopper, error) {
-	conn, _, stopper, err := getClientGRPCConn()
+	conn, _, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -32,7 +32,7 @@ import (
 func MakeDBClient() (*client.DB, *stop.Stopper, error) {
 	// The KV endpoints require the node user.
 	baseCfg.User = security.NodeUser
-	conn, clock, stopper, err := getClientGRPCConn()
+	conn, clock, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -15,40 +15,53 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"math"
 	"net"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/elastic/gosigar"
+	"github.com/mattn/go-isatty"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/cli/cliflags"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/rpc"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/server"
 	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/grpcutil"
@@ -56,9 +69,12 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logflags"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/sdnotify"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 )
 
 // jemallocHeapDump is an optional function to be called at heap dump time.
@@ -79,6 +95,11 @@ If no cluster exists yet and this is the first node, no additional
 flags are required. If the cluster already exists, and this node is
 uninitialized, specify the --join flag to point to any healthy node
 (or list of nodes) already part of the cluster.
+
+Except on Windows, the periodic profilers enabled by COCKROACH_MEMPROF_INTERVAL
+and COCKROACH_CPUPROF_INTERVAL can be controlled with signals while the node
+runs. SIGUSR1 pauses them, or resumes them if they are paused. SIGUSR2 ends the
+current cpu profile and starts the next one right away.
 `,
 	Example: `  cockroach start --insecure --store=attrs=ssd,path=/mnt/ssd1 [--join=host:port,[host:port]]`,
 	RunE:    MaybeShoutError(MaybeDecorateGRPCError(runStart)),
@@ -89,17 +110,55 @@ uninitialized, specify the --join flag to point to any healthy node
 var maxSizePerProfile = envutil.EnvOrDefaultInt64(
 	"COCKROACH_MAX_SIZE_PER_PROFILE", 100<<20 /* 100 MB */)
 
+// profileDirMu serializes gcProfiles with the moves of completed profiles
+// into place, so that a scan of the profile directory never observes a
+// profile in the middle of being published by another profiler.
+var profileDirMu syncutil.Mutex
+
+// memProfileMu serializes writeMemProfiles calls, which may come from both the
+// periodic memory profiler and the RSS threshold profiler.
+var memProfileMu syncutil.Mutex
+
+// maxProfileAge is the age beyond which profiles are removed, regardless of
+// their total size. Zero disables age-based removal.
+var maxProfileAge = envutil.EnvOrDefaultDuration("COCKROACH_MAX_PROFILE_AGE", 0)
+
+// minProfilesToKeep is the number of most recent profiles for each prefix that
+// are kept regardless of their total size and age. The most recent profile is
+// always kept.
+var minProfilesToKeep = envutil.EnvOrDefaultInt("COCKROACH_PROFILE_MIN_KEEP", 1)
+
 // gcProfiles removes old profiles matching the specified prefix when the sum
-// of newer profiles is larger than maxSize. Requires that the suffix used for
-// the profiles indicates age (e.g. by using a date/timestamp suffix) such that
-// sorting the filenames corresponds to ordering the profiles from oldest to
-// newest.
+// of newer profiles is larger than maxSize, or when they are older than
+// maxProfileAge. Requires that the suffix used for the profiles indicates age
+// (e.g. by using a date/timestamp suffix) such that sorting the filenames
+// corresponds to ordering the profiles from oldest to newest. Profiles whose
+// age can't be determined through parseProfileName are only subject to the
+// size limit. The minProfilesToKeep most recent profiles are never removed.
+// A profile's checksum file, if any, counts towards its size and is removed
+// along with it. The node ID in the names of the profiles, if any, is
+// ignored, so that profiles named before and after it was known are ordered
+// by timestamp.
 func gcProfiles(dir, prefix string, maxSize int64) {
+	profileDirMu.Lock()
+	defer profileDirMu.Unlock()
+
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		log.Warning(context.Background(), err)
 		return
 	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return stripProfileNodeID(files[i].Name()) < stripProfileNodeID(files[j].Name())
+	})
+	checksums := make(map[string]int64)
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasPrefix(f.Name(), prefix) &&
+			strings.HasSuffix(f.Name(), profileChecksumSuffix) {
+			checksums[f.Name()] = f.Size()
+		}
+	}
+	now := timeutil.Now()
 	var sum int64
 	var found int
 	for i := len(files) - 1; i >= 0; i-- {
@@ -110,313 +169,2076 @@ func gcProfiles(dir, prefix string, maxSize int64) {
 		if !strings.HasPrefix(f.Name(), prefix) {
 			continue
 		}
+		if _, ok := checksums[f.Name()]; ok {
+			continue
+		}
+		checksumName := f.Name() + profileChecksumSuffix
+		checksumSize, hasChecksum := checksums[checksumName]
 		found++
-		sum += f.Size()
-		if found == 1 {
-			// Always keep the most recent profile.
+		sum += f.Size() + checksumSize
+		if found == 1 || found <= minProfilesToKeep {
+			// Always keep the most recent profiles.
 			continue
 		}
-		if sum <= maxSize {
+		if sum <= maxSize && !profileExpired(f.Name(), now) {
 			continue
 		}
 		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
 			log.Info(context.Background(), err)
+			continue
+		}
+		if hasChecksum {
+			if err := os.Remove(filepath.Join(dir, checksumName)); err != nil {
+				log.Info(context.Background(), err)
+			}
 		}
 	}
 }
 
-func initMemProfile(ctx context.Context, dir string) {
-	const jeprof = "jeprof."
-	const memprof = "memprof."
-
-	gcProfiles(dir, jeprof, maxSizePerProfile)
-	gcProfiles(dir, memprof, maxSizePerProfile)
+// profileExpired returns whether the profile with the given file name is
+// older than maxProfileAge.
+func profileExpired(name string, now time.Time) bool {
+	if maxProfileAge <= 0 {
+		return false
+	}
+	_, t, err := parseProfileName(name)
+	return err == nil && now.Sub(t) > maxProfileAge
+}
 
-	memProfileInterval := envutil.EnvOrDefaultDuration("COCKROACH_MEMPROF_INTERVAL", -1)
-	if memProfileInterval <= 0 {
-		return
+// parseProfileName splits the file name of a profile, such as
+// memprof.2006-01-02T15_04_05.999 or memprof.n7.2006-01-02T15_04_05.999,
+// into its prefix (including the trailing dot) and the time encoded in its
+// suffix using profileTimeFormat. The node ID, if any, is skipped.
+func parseProfileName(name string) (prefix string, t time.Time, err error) {
+	stripped := stripProfileNodeID(name)
+	i := strings.IndexByte(stripped, '.')
+	if i < 0 {
+		return "", time.Time{}, errors.Errorf("%q is not a profile file name", name)
 	}
-	if min := time.Second; memProfileInterval < min {
-		log.Infof(ctx, "fixing excessively short memory profiling interval: %s -> %s",
-			memProfileInterval, min)
-		memProfileInterval = min
+	prefix = stripped[:i+1]
+	t, err = time.Parse(profileTimeFormat, stripped[i+1:])
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "%q is not a profile file name", name)
 	}
+	return prefix, t, nil
+}
 
-	if jemallocHeapDump != nil {
-		log.Infof(ctx, "writing go and jemalloc memory profiles to %s every %s", dir, memProfileInterval)
-	} else {
-		log.Infof(ctx, "writing go only memory profiles to %s every %s", dir, memProfileInterval)
-		log.Infof(ctx, `to enable jmalloc profiling: "export MALLOC_CONF=prof:true" or "ln -s prof:true /etc/malloc.conf"`)
+// stripProfileNodeID removes the node ID from the file name of a profile,
+// e.g. memprof.n7.2006-01-02T15_04_05.999 becomes
+// memprof.2006-01-02T15_04_05.999. Other names are returned unchanged.
+func stripProfileNodeID(name string) string {
+	i := strings.IndexByte(name, '.')
+	if i < 0 || i+1 >= len(name) || name[i+1] != 'n' {
+		return name
+	}
+	j := strings.IndexByte(name[i+1:], '.')
+	if j < 0 {
+		return name
+	}
+	if _, err := strconv.ParseUint(name[i+2:i+1+j], 10, 32); err != nil {
+		return name
 	}
+	return name[:i+1] + name[i+j+2:]
+}
 
-	go func() {
-		ctx := context.Background()
-		t := time.NewTicker(memProfileInterval)
-		defer t.Stop()
+// profileNodeID is the ID of the node once it is known, or 0. It is included
+// in the names of the profiles so that profiles collected from several nodes
+// can be told apart. Accessed atomically.
+var profileNodeID int32
 
-		for {
-			<-t.C
+// profileSuffix returns the suffix of the file name of a profile taken at t:
+// the timestamp, preceded by the node ID if it is known, e.g.
+// n7.2006-01-02T15_04_05.999.
+func profileSuffix(t time.Time) string {
+	if nodeID := atomic.LoadInt32(&profileNodeID); nodeID != 0 {
+		return fmt.Sprintf("n%d.%s", nodeID, t.Format(profileTimeFormat))
+	}
+	return t.Format(profileTimeFormat)
+}
 
-			func() {
-				const format = "2006-01-02T15_04_05.999"
-				suffix := timeutil.Now().Format(format)
-
-				// Try jemalloc heap profile first, we only log errors.
-				if jemallocHeapDump != nil {
-					jepath := filepath.Join(dir, jeprof+suffix)
-					if err := jemallocHeapDump(jepath); err != nil {
-						log.Warningf(ctx, "error writing jemalloc heap %s: %s", jepath, err)
-					}
-					gcProfiles(dir, jeprof, maxSizePerProfile)
-				}
+// The kinds of profiles, which start their file names, after the
+// profileNamePrefix if any.
+const (
+	jeprof  = "jeprof."
+	memprof = "memprof."
+	cpuprof = "cpuprof."
 
-				path := filepath.Join(dir, memprof+suffix)
-				// Try writing a go heap profile.
-				f, err := os.Create(path)
-				if err != nil {
-					log.Warningf(ctx, "error creating go heap file %s", err)
-					return
-				}
-				defer f.Close()
-				if err = pprof.WriteHeapProfile(f); err != nil {
-					log.Warningf(ctx, "error writing go heap %s: %s", path, err)
-					return
-				}
-				gcProfiles(dir, memprof, maxSizePerProfile)
-			}()
-		}
-	}()
-}
+	// profileTimeFormat is the format of the timestamp suffix of profile
+	// filenames. Sorting the filenames orders the profiles by age, which is
+	// required by gcProfiles.
+	profileTimeFormat = "2006-01-02T15_04_05.999"
+)
 
-func initCPUProfile(ctx context.Context, dir string) {
-	const cpuprof = "cpuprof."
-	gcProfiles(dir, cpuprof, maxSizePerProfile)
+// profileNamePrefixEnv is the value of COCKROACH_PROFILE_PREFIX, which is
+// prepended to the names of all the profiles so that processes sharing a
+// profile directory don't collide, nor remove each other's profiles.
+var profileNamePrefixEnv = envutil.EnvOrDefaultString("COCKROACH_PROFILE_PREFIX", "")
 
-	cpuProfileInterval := envutil.EnvOrDefaultDuration("COCKROACH_CPUPROF_INTERVAL", -1)
-	if cpuProfileInterval <= 0 {
-		return
-	}
-	if min := time.Second; cpuProfileInterval < min {
-		log.Infof(ctx, "fixing excessively short cpu profiling interval: %s -> %s",
-			cpuProfileInterval, min)
-		cpuProfileInterval = min
+// profileNamePrefix is profileNamePrefixEnv if it is valid, or empty.
+var profileNamePrefix = validProfileNamePrefix(profileNamePrefixEnv)
+
+// validProfileNamePrefix returns prefix, or "" if it can't be part of the
+// name of a profile: it must not contain a dot, which separates the kind of a
+// profile from its timestamp, nor a path separator.
+func validProfileNamePrefix(prefix string) string {
+	if strings.ContainsAny(prefix, "./"+string(filepath.Separator)) {
+		return ""
 	}
+	return prefix
+}
 
-	go func() {
-		defer log.RecoverAndReportPanic(ctx, &serverCfg.Settings.SV)
+// profilePrefix returns the prefix of the names of the profiles of the given
+// kind, e.g. memprof, written by this process.
+func profilePrefix(kind string) string {
+	return profileNamePrefix + kind
+}
 
-		ctx := context.Background()
+// profileFsync, when set, makes the go heap and cpu profile writers sync
+// profiles to disk before moving them into place, so that a profile taken
+// right before a crash survives it. This is off by default since it adds
+// latency to every profile rotation.
+var profileFsync = envutil.EnvOrDefaultBool("COCKROACH_PROFILE_FSYNC", false)
 
-		t := time.NewTicker(cpuProfileInterval)
-		defer t.Stop()
+// profileChecksum, when set, makes the profile writers compute the SHA-256 of
+// each profile as it is written and store it next to the profile in a file
+// with profileChecksumSuffix, in the format used by sha256sum. This allows
+// verifying the integrity of profiles that are shared, e.g. in support
+// bundles.
+var profileChecksum = envutil.EnvOrDefaultBool("COCKROACH_PROFILE_CHECKSUM", false)
 
-		var currentProfile *os.File
-		defer func() {
-			if currentProfile != nil {
-				pprof.StopCPUProfile()
-				currentProfile.Close()
-			}
-		}()
+// profileChecksumSuffix is appended to the name of a profile to form the name
+// of its checksum file.
+const profileChecksumSuffix = ".sha256"
 
-		for {
-			func() {
-				const format = "2006-01-02T15_04_05.999"
-				suffix := timeutil.Now().Add(cpuProfileInterval).Format(format)
-				f, err := os.Create(filepath.Join(dir, cpuprof+suffix))
-				if err != nil {
-					log.Warningf(ctx, "error creating go cpu file %s", err)
-					return
-				}
+// writeProfileChecksumLocked writes the checksum file for the profile at
+// path, given the profile's SHA-256. The file is written under a hidden name
+// first and then moved into place. Requires that profileDirMu is held.
+func writeProfileChecksumLocked(path string, sum []byte) error {
+	name := path + profileChecksumSuffix
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(tmp, "%x  %s\n", sum, filepath.Base(path)); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
 
-				// Stop the current profile if it exists.
-				if currentProfile != nil {
-					pprof.StopCPUProfile()
-					currentProfile.Close()
-					currentProfile = nil
-					gcProfiles(dir, cpuprof, maxSizePerProfile)
-				}
+// writeProfileChecksum computes the SHA-256 of the already complete profile
+// at path and writes its checksum file. This is used for profiles that aren't
+// written through a profileFile, such as jemalloc's.
+func writeProfileChecksum(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	profileDirMu.Lock()
+	defer profileDirMu.Unlock()
+	return writeProfileChecksumLocked(path, h.Sum(nil))
+}
 
-				// Start the new profile.
-				if err := pprof.StartCPUProfile(f); err != nil {
-					log.Warningf(ctx, "unable to start cpu profile: %v", err)
-					f.Close()
-					return
-				}
-				currentProfile = f
-			}()
+// profileWriteLimiter, if set, limits the rate at which all profiles
+// combined are written, so that writing a large profile doesn't cause an I/O
+// spike that hurts foreground traffic. It is configured in bytes per second
+// by COCKROACH_PROFILE_WRITE_RATE; profiles are written unthrottled by
+// default.
+var profileWriteLimiter = makeProfileWriteLimiter(
+	envutil.EnvOrDefaultBytes("COCKROACH_PROFILE_WRITE_RATE", 0))
 
-			<-t.C
-		}
-	}()
-}
+// profileWriteChunkSize is the largest amount of data written to a profile at
+// once when profile writes are throttled.
+const profileWriteChunkSize = 64 << 10 // 64 KiB
 
-func initBlockProfile() {
-	// Enable the block profile for a sample of mutex and channel operations.
-	// Smaller values provide more accurate profiles but are more
-	// expensive. 0 and 1 are special: 0 disables the block profile and
-	// 1 captures 100% of block events. For other values, the profiler
-	// will sample one event per X nanoseconds spent blocking.
-	//
-	// The block profile can be viewed with `pprof http://HOST:PORT/debug/pprof/block`
-	d := envutil.EnvOrDefaultInt64("COCKROACH_BLOCK_PROFILE_RATE",
-		10000000 /* 1 sample per 10 milliseconds spent blocking */)
-	runtime.SetBlockProfileRate(int(d))
+// makeProfileWriteLimiter returns a limiter for writing bytesPerSec bytes per
+// second, or nil if bytesPerSec is not positive.
+func makeProfileWriteLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := profileWriteChunkSize
+	if bytesPerSec < int64(burst) {
+		burst = int(bytesPerSec)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
 }
 
-type percentResolverFunc func(percent int) (int64, error)
+// profileUploadURI, if set, is the external storage location (e.g.
+// s3://bucket/prefix) to which every completed profile is copied, in addition
+// to being kept locally. Uploading requires a build that provides a
+// ProfileUploader.
+var profileUploadURI = envutil.EnvOrDefaultString("COCKROACH_PROFILE_UPLOAD_URI", "")
 
-// bytesOrPercentageValue is a flag that accepts an integer value, an integer
-// plus a unit (e.g. 32GB or 32GiB) or a percentage (e.g. 32%). In all these
-// cases, it transforms the string flag input into an int64 value.
-//
-// Since it accepts a percentage, instances need to be configured with
-// instructions on how to resolve a percentage to a number (i.e. the answer to
-// the question "a percentage of what?"). This is done by taking in a
-// percentResolverFunc. There are predefined ones: memoryPercentResolver and
-// diskPercentResolverFactory.
-//
-// bytesOrPercentageValue can be used in two ways:
-// 1. Upon flag parsing, it can write an int64 value through a pointer specified
-// by the caller.
-// 2. It can store the flag value as a string and only convert it to an int64 on
-// a subsequent Resolve() call. Input validation still happens at flag parsing
-// time.
-//
-// Option 2 is useful when percentages cannot be resolved at flag parsing time.
-// For example, we have flags that can be expressed as percentages of the
-// capacity of storage device. Which storage device is in question might only be
-// known once other flags are parsed (e.g. --max-disk-temp-storage=10% depends
-// on --store).
-type bytesOrPercentageValue struct {
-	val  *int64
-	bval *humanizeutil.BytesValue
+// ProfileUploader copies a completed profile, read from content, to the
+// external storage location designated by uri under the given name.
+type ProfileUploader func(
+	ctx context.Context, st *cluster.Settings, uri, name string, content io.ReadSeeker,
+) error
 
-	origVal string
+// profileUploader is the ProfileUploader installed by SetProfileUploader.
+var profileUploader ProfileUploader
 
-	// percentResolver is used to turn a percent string into a value. See
-	// memoryPercentResolver() and diskPercentResolverFactory().
-	percentResolver percentResolverFunc
+// SetProfileUploader installs the function used to upload profiles to
+// COCKROACH_PROFILE_UPLOAD_URI.
+func SetProfileUploader(u ProfileUploader) {
+	profileUploader = u
 }
 
-// memoryPercentResolver turns a percent into the respective fraction of the
-// system's internal memory.
-func memoryPercentResolver(percent int) (int64, error) {
-	sizeBytes, err := server.GetTotalMemory(context.TODO())
+// profileUploadSem limits the number of concurrent profile uploads. Profiles
+// completed while all the slots are taken are not uploaded.
+var profileUploadSem = make(chan struct{}, 4)
+
+// maybeUploadProfile asynchronously uploads the completed profile at path to
+// profileUploadURI, if configured. Failures are only logged. The profile is
+// opened before returning so that a subsequent gcProfiles doesn't prevent the
+// upload.
+func maybeUploadProfile(ctx context.Context, path string) {
+	if profileUploadURI == "" || profileUploader == nil {
+		return
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		return 0, err
+		log.Warningf(ctx, "unable to upload profile %s: %s", path, err)
+		return
 	}
-	return (sizeBytes * int64(percent)) / 100, nil
+	select {
+	case profileUploadSem <- struct{}{}:
+	default:
+		f.Close()
+		log.Warningf(ctx, "not uploading profile %s: too many uploads in progress", path)
+		return
+	}
+	go func() {
+		defer func() { <-profileUploadSem }()
+		defer f.Close()
+		if err := profileUploader(
+			ctx, serverCfg.Settings, profileUploadURI, filepath.Base(path), f,
+		); err != nil {
+			log.Warningf(ctx, "unable to upload profile %s: %s", path, err)
+		}
+	}()
 }
 
-// diskPercentResolverFactory takes in a path and produces a percentResolverFunc
-// bound to the respective storage device.
-//
-// An error is returned if dir does not exist.
-func diskPercentResolverFactory(dir string) (percentResolverFunc, error) {
-	fileSystemUsage := gosigar.FileSystemUsage{}
-	if err := fileSystemUsage.Get(dir); err != nil {
+// profileFile is a profile being written to a temporary file, which is moved
+// to its final path once complete. This ensures that partially written
+// profiles are never mistaken for complete ones.
+type profileFile struct {
+	*os.File
+	path string
+	// hash, if set, accumulates the SHA-256 of everything written to the
+	// profile. See profileChecksum.
+	hash hash.Hash
+}
+
+// createProfileFile creates a profileFile that will end up at path. The
+// temporary file is hidden so that gcProfiles ignores it.
+func createProfileFile(path string) (*profileFile, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
 		return nil, err
 	}
-	if fileSystemUsage.Total > math.MaxInt64 {
-		return nil, fmt.Errorf("unsupported disk size %s, max supported size is %s",
-			humanize.IBytes(fileSystemUsage.Total), humanizeutil.IBytes(math.MaxInt64))
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
 	}
-	deviceCapacity := int64(fileSystemUsage.Total)
-
-	return func(percent int) (int64, error) {
-		return (deviceCapacity * int64(percent)) / 100, nil
-	}, nil
+	pf := &profileFile{File: f, path: path}
+	if profileChecksum {
+		pf.hash = sha256.New()
+	}
+	return pf, nil
 }
 
-// newBytesOrPercentageValue creates a bytesOrPercentageValue.
-//
-// v and percentResolver can be nil (either they're both specified or they're
-// both nil). If they're nil, then Resolve() has to be called later to get the
-// passed-in value.
-func newBytesOrPercentageValue(
-	v *int64, percentResolver func(percent int) (int64, error),
-) *bytesOrPercentageValue {
-	if v == nil {
-		v = new(int64)
-	}
-	return &bytesOrPercentageValue{
-		val:             v,
-		bval:            humanizeutil.NewBytesValue(v),
-		percentResolver: percentResolver,
+// Write implements io.Writer, throttling the writes if
+// COCKROACH_PROFILE_WRITE_RATE is set. Note that a throttled CPU profile may
+// lose samples if the runtime's buffer fills up while it is being written.
+func (f *profileFile) Write(p []byte) (int, error) {
+	n, err := f.write(p)
+	if f.hash != nil {
+		_, _ = f.hash.Write(p[:n])
 	}
+	return n, err
 }
 
-func (b *bytesOrPercentageValue) Set(s string) error {
-	b.origVal = s
-	if strings.HasSuffix(s, "%") {
-		percent, err := strconv.Atoi(s[:len(s)-1])
-		if err != nil {
-			return err
-		}
-		if percent < 0 || percent > 99 {
-			return fmt.Errorf("percentage %s out of range 0%% - 99%%", s)
+func (f *profileFile) write(p []byte) (int, error) {
+	limiter := profileWriteLimiter
+	if limiter == nil {
+		return f.File.Write(p)
+	}
+	var n int
+	for len(p) > 0 {
+		chunk := len(p)
+		if burst := limiter.Burst(); chunk > burst {
+			chunk = burst
 		}
-
-		if b.percentResolver == nil {
-			// percentResolver not set means that this flag is not yet supposed to set
-			// any value.
-			return nil
+		if err := limiter.WaitN(context.Background(), chunk); err != nil {
+			return n, err
 		}
-
-		absVal, err := b.percentResolver(percent)
+		m, err := f.File.Write(p[:chunk])
+		n += m
 		if err != nil {
-			return err
+			return n, err
 		}
-		s = fmt.Sprint(absVal)
+		p = p[chunk:]
 	}
-	return b.bval.Set(s)
+	return n, nil
 }
 
-// Resolve can be called to get the flag's value (if any). If the flag had been
-// previously set, *v will be written.
-func (b *bytesOrPercentageValue) Resolve(v *int64, percentResolver percentResolverFunc) error {
-	// The flag was not passed on the command line.
-	if b.origVal == "" {
-		return nil
+// finish syncs the profile if COCKROACH_PROFILE_FSYNC is set, closes it and
+// moves it to its final path, along with its checksum file if
+// COCKROACH_PROFILE_CHECKSUM is set.
+func (f *profileFile) finish() error {
+	if profileFsync {
+		if err := f.Sync(); err != nil {
+			f.abort()
+			return err
+		}
 	}
-	b.percentResolver = percentResolver
-	b.val = v
-	b.bval = humanizeutil.NewBytesValue(v)
-	return b.Set(b.origVal)
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	profileDirMu.Lock()
+	defer profileDirMu.Unlock()
+	if f.hash != nil {
+		if err := writeProfileChecksumLocked(f.path, f.hash.Sum(nil)); err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+	}
+	return os.Rename(f.Name(), f.path)
 }
 
-func (b *bytesOrPercentageValue) Type() string {
-	return b.bval.Type()
+// abort closes and removes the incomplete profile.
+func (f *profileFile) abort() {
+	f.Close()
+	_ = os.Remove(f.Name())
 }
 
-func (b *bytesOrPercentageValue) String() string {
-	return b.bval.String()
+// memProfileSource selects which memory profiles are written.
+type memProfileSource int
+
+const (
+	// memProfileSourceBoth writes a jemalloc heap profile, if available, and a
+	// go heap profile.
+	memProfileSourceBoth memProfileSource = iota
+	// memProfileSourceGo only writes a go heap profile.
+	memProfileSourceGo
+	// memProfileSourceJemalloc only writes a jemalloc heap profile.
+	memProfileSourceJemalloc
+)
+
+func (s memProfileSource) String() string {
+	switch s {
+	case memProfileSourceBoth:
+		return "both"
+	case memProfileSourceGo:
+		return "go"
+	case memProfileSourceJemalloc:
+		return "jemalloc"
+	}
+	return ""
 }
 
-func (b *bytesOrPercentageValue) IsSet() bool {
-	return b.bval.IsSet()
+// parseMemProfileSource parses the value of COCKROACH_MEMPROF_SOURCE.
+func parseMemProfileSource(s string) (memProfileSource, error) {
+	switch s {
+	case "both":
+		return memProfileSourceBoth, nil
+	case "go":
+		return memProfileSourceGo, nil
+	case "jemalloc":
+		return memProfileSourceJemalloc, nil
+	}
+	return 0, errors.Errorf("invalid memory profile source: %s (possible values: go, jemalloc, both)", s)
 }
 
-var cacheSizeValue = newBytesOrPercentageValue(&serverCfg.CacheSize, memoryPercentResolver)
-var sqlSizeValue = newBytesOrPercentageValue(&serverCfg.SQLMemoryPoolSize, memoryPercentResolver)
-var diskTempStorageSizeValue = newBytesOrPercentageValue(nil /* v */, nil /* percentResolver */)
+// writeMemProfiles writes the memory profiles selected by source to dir,
+// using the given filename suffix: a jemalloc heap profile (if available)
+// and/or a go heap profile. Errors writing the jemalloc profile are only
+// logged, unless it is the only profile requested; an error writing the go
+// profile is returned.
+func writeMemProfiles(ctx context.Context, dir, suffix string, source memProfileSource) error {
+	memProfileMu.Lock()
+	defer memProfileMu.Unlock()
 
-func initExternalIODir(ctx context.Context, firstStore base.StoreSpec) (string, error) {
-	if externalIODir == "" && !firstStore.InMemory {
-		externalIODir = filepath.Join(firstStore.Path, "extern")
-	}
-	if externalIODir == "" || externalIODir == "disabled" {
+	// Try jemalloc heap profile first, we only log errors.
+	if jemallocHeapDump != nil && source != memProfileSourceGo {
+		jepath := filepath.Join(dir, profilePrefix(jeprof)+suffix)
+		if err := jemallocHeapDump(jepath); err != nil {
+			if source == memProfileSourceJemalloc {
+				return errors.Wrapf(err, "error writing jemalloc heap %s", jepath)
+			}
+			log.Warningf(ctx, "error writing jemalloc heap %s: %s", jepath, err)
+		} else {
+			if profileChecksum {
+				if err := writeProfileChecksum(jepath); err != nil {
+					log.Warningf(ctx, "error writing checksum of jemalloc heap %s: %s", jepath, err)
+				}
+			}
+			maybeUploadProfile(ctx, jepath)
+		}
+		gcProfiles(dir, profilePrefix(jeprof), maxSizePerProfile)
+	}
+	if source == memProfileSourceJemalloc {
+		return nil
+	}
+
+	path := filepath.Join(dir, profilePrefix(memprof)+suffix)
+	// Try writing a go heap profile.
+	f, err := createProfileFile(path)
+	if err != nil {
+		return errors.Wrap(err, "error creating go heap file")
+	}
+	if err = pprof.WriteHeapProfile(f); err != nil {
+		f.abort()
+		return errors.Wrapf(err, "error writing go heap %s", path)
+	}
+	if err = f.finish(); err != nil {
+		return errors.Wrapf(err, "error writing go heap %s", path)
+	}
+	maybeUploadProfile(ctx, path)
+	gcProfiles(dir, profilePrefix(memprof), maxSizePerProfile)
+	return nil
+}
+
+// panicprof is the prefix of the profiles written when the process panics.
+// These profiles are not subject to gcProfiles: crash artifacts are the most
+// valuable ones, so they are not evicted by the size and age limits of the
+// periodic profiles. Only their number is capped, by gcPanicProfiles.
+const panicprof = "panic."
+
+// panicProfileKinds are the kinds of profiles written by writePanicProfiles,
+// each with the debug level passed to pprof.
+var panicProfileKinds = []struct {
+	name  string
+	debug int
+}{
+	// Use the format of an unrecovered panic for the goroutines.
+	{"goroutine", 2},
+	{"heap", 0},
+}
+
+// maxPanicProfiles is the number of most recent sets of panic profiles that
+// are kept. Zero or less keeps them all.
+var maxPanicProfiles = envutil.EnvOrDefaultInt("COCKROACH_MAX_PANIC_PROFILES", 10)
+
+// panicProfilesOnce ensures that only the first of several concurrent panics
+// writes panic profiles.
+var panicProfilesOnce sync.Once
+
+// writePanicProfiles writes a goroutine and a go heap profile to dir, named
+// panic.<suffix>.goroutine and panic.<suffix>.heap (see profileSuffix). It is registered as
+// the panic hook of the log package so that a crashed node leaves behind the
+// state it was in at the time of the crash.
+func writePanicProfiles(ctx context.Context, dir string) {
+	panicProfilesOnce.Do(func() {
+		prefix := filepath.Join(dir, profilePrefix(panicprof)+profileSuffix(timeutil.Now()))
+		for _, p := range panicProfileKinds {
+			path := prefix + "." + p.name
+			f, err := createProfileFile(path)
+			if err != nil {
+				log.Warningf(ctx, "error creating panic %s profile: %s", p.name, err)
+				continue
+			}
+			if err := pprof.Lookup(p.name).WriteTo(f, p.debug); err != nil {
+				f.abort()
+				log.Warningf(ctx, "error writing panic %s profile %s: %s", p.name, path, err)
+				continue
+			}
+			if err := f.finish(); err != nil {
+				log.Warningf(ctx, "error writing panic %s profile %s: %s", p.name, path, err)
+			}
+		}
+	})
+}
+
+// panicProfileSet returns the name shared by the profiles written by a single
+// call to writePanicProfiles, i.e. name without its kind and checksum
+// suffixes, and whether name is that of a panic profile.
+func panicProfileSet(name string) (string, bool) {
+	if !strings.HasPrefix(name, profilePrefix(panicprof)) {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, profileChecksumSuffix)
+	for _, p := range panicProfileKinds {
+		if set := strings.TrimSuffix(name, "."+p.name); set != name {
+			return set, true
+		}
+	}
+	return "", false
+}
+
+// gcPanicProfiles removes the panic profiles in dir beyond the keep most
+// recent sets of them, regardless of their size and age. keep <= 0 disables
+// removal.
+func gcPanicProfiles(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	profileDirMu.Lock()
+	defer profileDirMu.Unlock()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warning(context.Background(), err)
+		return
+	}
+	sets := make(map[string][]string)
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if set, ok := panicProfileSet(f.Name()); ok {
+			sets[set] = append(sets[set], f.Name())
+		}
+	}
+	if len(sets) <= keep {
+		return
+	}
+	names := make([]string, 0, len(sets))
+	for set := range sets {
+		names = append(names, set)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stripProfileNodeID(names[i]) < stripProfileNodeID(names[j])
+	})
+	for _, set := range names[:len(names)-keep] {
+		for _, name := range sets[set] {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				log.Info(context.Background(), err)
+			}
+		}
+	}
+}
+
+// startupcpuprof is the prefix of the cpu profile of the node startup written
+// with --cpu-profile-on-start.
+const startupcpuprof = "startupcpu."
+
+// startupCPUProfiler captures a cpu profile of the node startup. The profile
+// starts before the output directory is known, so it is buffered in memory
+// and written once it is both stopped and the directory is known.
+type startupCPUProfiler struct {
+	timer *time.Timer
+
+	mu struct {
+		syncutil.Mutex
+		buf     bytes.Buffer
+		stopped bool
+		ctx     context.Context
+		dir     string
+	}
+}
+
+// startStartupCPUProfile starts a cpu profile that is stopped after the given
+// duration, unless stop is called earlier.
+func startStartupCPUProfile(duration time.Duration) (*startupCPUProfiler, error) {
+	p := &startupCPUProfiler{}
+	if err := pprof.StartCPUProfile(&p.mu.buf); err != nil {
+		return nil, errors.Wrapf(err, "unable to start --%s profile", cliflags.CPUProfileOnStart.Name)
+	}
+	p.timer = time.AfterFunc(duration, p.stop)
+	return p, nil
+}
+
+// setDir sets the directory the profile is written to.
+func (p *startupCPUProfiler) setDir(ctx context.Context, dir string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.ctx, p.mu.dir = ctx, dir
+	p.maybeWriteLocked()
+}
+
+// stop stops the profile. It is a no-op if the profile is already stopped.
+func (p *startupCPUProfiler) stop() {
+	if p == nil {
+		return
+	}
+	p.timer.Stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.mu.stopped {
+		pprof.StopCPUProfile()
+		p.mu.stopped = true
+		p.maybeWriteLocked()
+	}
+}
+
+func (p *startupCPUProfiler) maybeWriteLocked() {
+	if !p.mu.stopped || p.mu.dir == "" || p.mu.buf.Len() == 0 {
+		return
+	}
+	ctx := p.mu.ctx
+	path := filepath.Join(p.mu.dir, profilePrefix(startupcpuprof)+timeutil.Now().Format(profileTimeFormat))
+	if err := func() error {
+		f, err := createProfileFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(p.mu.buf.Bytes()); err != nil {
+			f.abort()
+			return err
+		}
+		return f.finish()
+	}(); err != nil {
+		log.Warningf(ctx, "error writing startup cpu profile %s: %s", path, err)
+	} else {
+		log.Infof(ctx, "wrote startup cpu profile %s", path)
+		maybeUploadProfile(ctx, path)
+	}
+	p.mu.buf.Reset()
+	gcProfiles(p.mu.dir, profilePrefix(startupcpuprof), maxSizePerProfile)
+}
+
+// profileWriteFailureThreshold is the number of consecutive failures to write
+// a profile after which a profiler stops logging every failure and pauses.
+const profileWriteFailureThreshold = 3
+
+// profileWritePause is how long a profiler pauses after repeated failures
+// before trying again.
+const profileWritePause = 5 * time.Minute
+
+// profileFailureTracker keeps the repeated failures of a periodic profiler
+// (e.g. when the disk is full) from flooding the logs. Each failure is logged
+// until profileWriteFailureThreshold consecutive failures have been seen, at
+// which point a single warning is logged and the profiler pauses for
+// profileWritePause. Failures of the attempts made after a pause are not
+// logged. The first success after that is logged, after which the profiler
+// resumes its normal cadence.
+type profileFailureTracker struct {
+	name        string
+	failures    int
+	pausedUntil time.Time
+}
+
+// paused returns whether the profiler should skip its current iteration.
+func (t *profileFailureTracker) paused() bool {
+	return timeutil.Now().Before(t.pausedUntil)
+}
+
+// failed records a failure to write a profile.
+func (t *profileFailureTracker) failed(ctx context.Context, err error) {
+	t.failures++
+	switch {
+	case t.failures < profileWriteFailureThreshold:
+		log.Warning(ctx, err)
+	case t.failures == profileWriteFailureThreshold:
+		log.Warningf(ctx, "%d consecutive failures writing %s profiles, pausing for %s: %s",
+			t.failures, t.name, profileWritePause, err)
+	}
+	if t.failures >= profileWriteFailureThreshold {
+		t.pausedUntil = timeutil.Now().Add(profileWritePause)
+	}
+}
+
+// succeeded records a successful profile write.
+func (t *profileFailureTracker) succeeded(ctx context.Context) {
+	if t.failures >= profileWriteFailureThreshold {
+		log.Infof(ctx, "writing %s profiles succeeded again after %d failures", t.name, t.failures)
+	}
+	t.failures = 0
+	t.pausedUntil = time.Time{}
+}
+
+// profilerPause lets the periodic memory and cpu profilers, as well as the
+// memory profiles written on COCKROACH_HEAP_DUMP_THRESHOLD, be paused and
+// resumed while the node runs, through the signal relayed by
+// notifyProfilerToggle.
+var profilerPause struct {
+	syncutil.Mutex
+	paused bool
+	// changed is closed, and replaced, whenever paused changes.
+	changed chan struct{}
+}
+
+// profilersPaused returns whether the periodic profilers are paused, along
+// with a channel that is closed once that changes.
+func profilersPaused() (bool, <-chan struct{}) {
+	profilerPause.Lock()
+	defer profilerPause.Unlock()
+	if profilerPause.changed == nil {
+		profilerPause.changed = make(chan struct{})
+	}
+	return profilerPause.paused, profilerPause.changed
+}
+
+// toggleProfilers pauses the periodic profilers if they are running, or
+// resumes them, and returns whether they are now paused.
+func toggleProfilers() bool {
+	profilerPause.Lock()
+	defer profilerPause.Unlock()
+	profilerPause.paused = !profilerPause.paused
+	if profilerPause.changed != nil {
+		close(profilerPause.changed)
+	}
+	profilerPause.changed = make(chan struct{})
+	return profilerPause.paused
+}
+
+// initProfilerToggle starts a background goroutine which pauses or resumes
+// the periodic profilers each time the signal relayed by
+// notifyProfilerToggle is received, e.g. to keep a performance issue being
+// reproduced interactively out of the profiles.
+func initProfilerToggle(ctx context.Context) {
+	toggle := make(chan os.Signal, 1)
+	if name := notifyProfilerToggle(toggle); name != "" {
+		log.Infof(ctx, "send %s to the process to pause or resume the periodic profilers", name)
+	}
+	go func() {
+		for sig := range toggle {
+			if toggleProfilers() {
+				log.Infof(ctx, "received signal '%s', pausing the periodic profilers", sig)
+			} else {
+				log.Infof(ctx, "received signal '%s', resuming the periodic profilers", sig)
+			}
+		}
+	}()
+}
+
+// disableProfilingEnv is the environment counterpart of --disable-profiling.
+var disableProfilingEnv = envutil.EnvOrDefaultBool("COCKROACH_DISABLE_PROFILING", false)
+
+// profilingDisabled returns whether the background profilers are turned off
+// by --disable-profiling or COCKROACH_DISABLE_PROFILING.
+func profilingDisabled() bool {
+	return startCtx.disableProfiling || disableProfilingEnv
+}
+
+// profilingConfig records the profiling configuration in effect, as set up
+// from the environment, for display in the startup summary.
+var profilingConfig struct {
+	blockProfileRate     int
+	mutexProfileFraction int
+	// Zero intervals mean that periodic profiles are disabled.
+	memProfileInterval time.Duration
+	// memProfileSource selects the periodic memory profiles.
+	memProfileSource   memProfileSource
+	cpuProfileInterval time.Duration
+	cpuProfileDuration time.Duration
+	// cpuProfileHz is the cpu profile sampling rate, or zero if the Go
+	// default is used.
+	cpuProfileHz int
+}
+
+// profilingConfigString formats the profiling configuration on a single
+// line.
+func profilingConfigString() string {
+	if profilingDisabled() {
+		return "disabled"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "block rate=%d, mutex fraction=%d", profilingConfig.blockProfileRate,
+		profilingConfig.mutexProfileFraction)
+	if profilingConfig.cpuProfileInterval > 0 {
+		fmt.Fprintf(&buf, ", cpu=%s every %s",
+			profilingConfig.cpuProfileDuration, profilingConfig.cpuProfileInterval)
+		if profilingConfig.cpuProfileHz > 0 {
+			fmt.Fprintf(&buf, " at %dHz", profilingConfig.cpuProfileHz)
+		}
+	} else {
+		buf.WriteString(", cpu=off")
+	}
+	if profilingConfig.memProfileInterval > 0 {
+		fmt.Fprintf(&buf, ", mem=every %s", profilingConfig.memProfileInterval)
+		if profilingConfig.memProfileSource != memProfileSourceBoth {
+			fmt.Fprintf(&buf, " (%s only)", profilingConfig.memProfileSource)
+		}
+	} else {
+		buf.WriteString(", mem=off")
+	}
+	return buf.String()
+}
+
+func initMemProfile(ctx context.Context, dir string) {
+	gcProfiles(dir, profilePrefix(jeprof), maxSizePerProfile)
+	gcProfiles(dir, profilePrefix(memprof), maxSizePerProfile)
+
+	memProfileInterval := envutil.EnvOrDefaultDuration("COCKROACH_MEMPROF_INTERVAL", -1)
+	if memProfileInterval <= 0 {
+		return
+	}
+	if min := time.Second; memProfileInterval < min {
+		log.Infof(ctx, "fixing excessively short memory profiling interval: %s -> %s",
+			memProfileInterval, min)
+		memProfileInterval = min
+	}
+	profilingConfig.memProfileInterval = memProfileInterval
+
+	source, err := parseMemProfileSource(envutil.EnvOrDefaultString("COCKROACH_MEMPROF_SOURCE", "both"))
+	if err != nil {
+		log.Warningf(ctx, "ignoring COCKROACH_MEMPROF_SOURCE: %s", err)
+		source = memProfileSourceBoth
+	}
+	if source == memProfileSourceJemalloc && jemallocHeapDump == nil {
+		log.Warningf(ctx, "jemalloc memory profiles are not available; writing go memory profiles instead")
+		source = memProfileSourceGo
+	}
+	profilingConfig.memProfileSource = source
+
+	switch {
+	case source == memProfileSourceJemalloc:
+		log.Infof(ctx, "writing jemalloc only memory profiles to %s every %s", dir, memProfileInterval)
+	case jemallocHeapDump != nil && source == memProfileSourceBoth:
+		log.Infof(ctx, "writing go and jemalloc memory profiles to %s every %s", dir, memProfileInterval)
+	default:
+		log.Infof(ctx, "writing go only memory profiles to %s every %s", dir, memProfileInterval)
+		if jemallocHeapDump == nil {
+			log.Infof(ctx, `to enable jmalloc profiling: "export MALLOC_CONF=prof:true" or "ln -s prof:true /etc/malloc.conf"`)
+		}
+	}
+
+	go func() {
+		ctx := context.Background()
+		t := time.NewTicker(memProfileInterval)
+		defer t.Stop()
+
+		tracker := profileFailureTracker{name: "memory"}
+		for {
+			<-t.C
+
+			if paused, _ := profilersPaused(); paused || tracker.paused() {
+				continue
+			}
+			if err := writeMemProfiles(ctx, dir, profileSuffix(timeutil.Now()), source); err != nil {
+				tracker.failed(ctx, err)
+			} else {
+				tracker.succeeded(ctx)
+			}
+		}
+	}()
+}
+
+// heapDumpCheckInterval is how often the process RSS is compared against
+// COCKROACH_HEAP_DUMP_THRESHOLD.
+const heapDumpCheckInterval = time.Second
+
+// initHeapDumpOnThreshold starts a background goroutine which writes memory
+// profiles as soon as the resident set size of the process exceeds the
+// threshold configured through COCKROACH_HEAP_DUMP_THRESHOLD. The threshold
+// accepts the same values as --cache (e.g. 4GiB or 80%). Periodic profiles
+// tend to miss short-lived memory spikes, which this is meant to capture.
+// Subsequent dumps are rate-limited by COCKROACH_HEAP_DUMP_MIN_INTERVAL, and
+// none are written while the profilers are paused.
+func initHeapDumpOnThreshold(ctx context.Context, dir string) {
+	thresholdStr := envutil.EnvOrDefaultString("COCKROACH_HEAP_DUMP_THRESHOLD", "")
+	if thresholdStr == "" {
+		return
+	}
+	var threshold int64
+	if err := newBytesOrPercentageValue(&threshold, memoryPercentResolver).Set(thresholdStr); err != nil {
+		log.Warningf(ctx, "ignoring invalid COCKROACH_HEAP_DUMP_THRESHOLD %q: %s", thresholdStr, err)
+		return
+	}
+	if threshold <= 0 {
+		return
+	}
+	minInterval := envutil.EnvOrDefaultDuration("COCKROACH_HEAP_DUMP_MIN_INTERVAL", 10*time.Minute)
+
+	log.Infof(ctx, "writing memory profiles to %s when RSS exceeds %s (at most every %s)",
+		dir, humanizeutil.IBytes(threshold), minInterval)
+
+	go func() {
+		defer log.RecoverAndReportPanic(ctx, &serverCfg.Settings.SV)
+
+		ctx := context.Background()
+		t := time.NewTicker(heapDumpCheckInterval)
+		defer t.Stop()
+
+		pid := os.Getpid()
+		var lastDump time.Time
+		for {
+			<-t.C
+
+			if paused, _ := profilersPaused(); paused {
+				continue
+			}
+			mem := gosigar.ProcMem{}
+			if err := mem.Get(pid); err != nil {
+				log.Warningf(ctx, "unable to get mem usage: %v", err)
+				continue
+			}
+			if rss := int64(mem.Resident); rss >= threshold &&
+				(lastDump.IsZero() || timeutil.Since(lastDump) >= minInterval) {
+				lastDump = timeutil.Now()
+				log.Infof(ctx, "RSS %s exceeds %s, writing memory profiles",
+					humanizeutil.IBytes(rss), humanizeutil.IBytes(threshold))
+				if err := writeMemProfiles(ctx, dir, profileSuffix(lastDump), memProfileSourceBoth); err != nil {
+					log.Warning(ctx, err)
+				}
+			}
+		}
+	}()
+}
+
+// maxCPUProfileHz is the highest cpu profile sampling rate accepted by
+// runtime.SetCPUProfileRate; higher rates are silently capped by the runtime.
+const maxCPUProfileHz = 1000000
+
+// clampCPUProfileHz limits a cpu profile sampling rate to the range accepted
+// by the Go runtime, and returns whether it had to be changed.
+func clampCPUProfileHz(hz int) (int, bool) {
+	if hz < 1 {
+		return 1, true
+	}
+	if hz > maxCPUProfileHz {
+		return maxCPUProfileHz, true
+	}
+	return hz, false
+}
+
+func initCPUProfile(ctx context.Context, dir string) {
+	gcProfiles(dir, profilePrefix(cpuprof), maxSizePerProfile)
+
+	cpuProfileInterval := envutil.EnvOrDefaultDuration("COCKROACH_CPUPROF_INTERVAL", -1)
+	if cpuProfileInterval <= 0 {
+		return
+	}
+	if min := time.Second; cpuProfileInterval < min {
+		log.Infof(ctx, "fixing excessively short cpu profiling interval: %s -> %s",
+			cpuProfileInterval, min)
+		cpuProfileInterval = min
+	}
+
+	// By default, profiles are rotated at every interval so that the profiles
+	// cover the entire time. If a shorter duration is configured, each
+	// interval instead starts with a profile of that duration and is idle for
+	// the remainder.
+	cpuProfileDuration := envutil.EnvOrDefaultDuration("COCKROACH_CPUPROF_DURATION", -1)
+	continuous := cpuProfileDuration <= 0 || cpuProfileDuration >= cpuProfileInterval
+	if continuous {
+		cpuProfileDuration = cpuProfileInterval
+	} else {
+		if min := time.Second; cpuProfileDuration < min {
+			log.Infof(ctx, "fixing excessively short cpu profiling duration: %s -> %s",
+				cpuProfileDuration, min)
+			cpuProfileDuration = min
+		}
+		log.Infof(ctx, "writing %s cpu profiles to %s every %s",
+			cpuProfileDuration, dir, cpuProfileInterval)
+	}
+
+	// The sampling rate defaults to the 100Hz used by pprof.StartCPUProfile.
+	// Setting it beforehand makes the runtime ignore the rate requested by
+	// StartCPUProfile, which logs a harmless complaint about it.
+	cpuProfileHz := envutil.EnvOrDefaultInt("COCKROACH_CPUPROF_HZ", 0)
+	if cpuProfileHz != 0 {
+		if hz, clamped := clampCPUProfileHz(cpuProfileHz); clamped {
+			log.Warningf(ctx, "fixing out of range cpu profiling rate: %dHz -> %dHz", cpuProfileHz, hz)
+			cpuProfileHz = hz
+		}
+	}
+	profilingConfig.cpuProfileInterval = cpuProfileInterval
+	profilingConfig.cpuProfileDuration = cpuProfileDuration
+	profilingConfig.cpuProfileHz = cpuProfileHz
+
+	go func() {
+		defer log.RecoverAndReportPanic(ctx, &serverCfg.Settings.SV)
+
+		ctx := context.Background()
+
+		var t timeutil.Timer
+		defer t.Stop()
+
+		// A signal (SIGUSR2) cuts the current profile and starts the next one
+		// right away, e.g. to line a profile up with a change of workload. The
+		// periodic rotation resumes a full interval after the cut.
+		rotate := make(chan os.Signal, 1)
+		if name := notifyCPUProfileRotation(rotate); name != "" {
+			log.Infof(ctx, "send %s to the process to start a new cpu profile", name)
+		}
+		defer signal.Stop(rotate)
+
+		tracker := profileFailureTracker{name: "cpu"}
+		var currentProfile *profileFile
+		// stopCurrentProfile stops the current profile if it exists.
+		stopCurrentProfile := func() {
+			if currentProfile != nil {
+				pprof.StopCPUProfile()
+				if err := currentProfile.finish(); err != nil {
+					tracker.failed(ctx, errors.Wrapf(err, "error writing go cpu file %s", currentProfile.path))
+				} else {
+					tracker.succeeded(ctx)
+					maybeUploadProfile(ctx, currentProfile.path)
+				}
+				currentProfile = nil
+				gcProfiles(dir, profilePrefix(cpuprof), maxSizePerProfile)
+			}
+		}
+		defer stopCurrentProfile()
+
+		for {
+			// A pause or resume takes effect right away: the current profile
+			// is cut, and profiling resumes with a new profile.
+			paused, pauseChanged := profilersPaused()
+			func() {
+				if paused || tracker.paused() {
+					stopCurrentProfile()
+					return
+				}
+				suffix := profileSuffix(timeutil.Now().Add(cpuProfileDuration))
+				f, err := createProfileFile(filepath.Join(dir, profilePrefix(cpuprof)+suffix))
+				if err != nil {
+					tracker.failed(ctx, errors.Wrap(err, "error creating go cpu file"))
+					return
+				}
+
+				stopCurrentProfile()
+
+				// Start the new profile.
+				if cpuProfileHz > 0 {
+					runtime.SetCPUProfileRate(cpuProfileHz)
+				}
+				if err := pprof.StartCPUProfile(f); err != nil {
+					log.Warningf(ctx, "unable to start cpu profile: %v", err)
+					f.abort()
+					return
+				}
+				currentProfile = f
+			}()
+			t.Reset(cpuProfileInterval)
+
+			if !continuous {
+				select {
+				case <-time.After(cpuProfileDuration):
+					stopCurrentProfile()
+				case sig := <-rotate:
+					log.Infof(ctx, "received signal '%s', rotating cpu profile", sig)
+					continue
+				case <-pauseChanged:
+					continue
+				}
+			}
+
+			select {
+			case <-t.C:
+				t.Read = true
+			case sig := <-rotate:
+				log.Infof(ctx, "received signal '%s', rotating cpu profile", sig)
+			case <-pauseChanged:
+			}
+		}
+	}()
+}
+
+func initBlockProfile() {
+	// Enable the block profile for a sample of mutex and channel operations.
+	// Smaller values provide more accurate profiles but are more
+	// expensive. 0 and 1 are special: 0 disables the block profile and
+	// 1 captures 100% of block events. For other values, the profiler
+	// will sample one event per X nanoseconds spent blocking.
+	//
+	// The block profile can be viewed with `pprof http://HOST:PORT/debug/pprof/block`
+	d := envutil.EnvOrDefaultInt64("COCKROACH_BLOCK_PROFILE_RATE",
+		10000000 /* 1 sample per 10 milliseconds spent blocking */)
+	runtime.SetBlockProfileRate(int(d))
+	profilingConfig.blockProfileRate = int(d)
+}
+
+// turnOffProfilers turns off the runtime's sampling profilers, which may
+// have been enabled at init time, and clears the profiling configuration.
+func turnOffProfilers() {
+	runtime.SetBlockProfileRate(0)
+	runtime.SetMutexProfileFraction(0)
+	profilingConfig.blockProfileRate = 0
+	profilingConfig.mutexProfileFraction = 0
+	profilingConfig.memProfileInterval = 0
+	profilingConfig.cpuProfileInterval = 0
+}
+
+type percentResolverFunc func(percent int) (int64, error)
+
+// bytesOrPercentageValue is a flag that accepts an integer value, an integer
+// plus a unit (e.g. 32GB or 32GiB) or a percentage (e.g. 32%). In all these
+// cases, it transforms the string flag input into an int64 value.
+//
+// Since it accepts a percentage, instances need to be configured with
+// instructions on how to resolve a percentage to a number (i.e. the answer to
+// the question "a percentage of what?"). This is done by taking in a
+// percentResolverFunc. There are predefined ones: memoryPercentResolver and
+// diskPercentResolverFactory.
+//
+// bytesOrPercentageValue can be used in two ways:
+// 1. Upon flag parsing, it can write an int64 value through a pointer specified
+// by the caller.
+// 2. It can store the flag value as a string and only convert it to an int64 on
+// a subsequent Resolve() call. Input validation still happens at flag parsing
+// time.
+//
+// Option 2 is useful when percentages cannot be resolved at flag parsing time.
+// For example, we have flags that can be expressed as percentages of the
+// capacity of storage device. Which storage device is in question might only be
+// known once other flags are parsed (e.g. --max-disk-temp-storage=10% depends
+// on --store).
+type bytesOrPercentageValue struct {
+	val  *int64
+	bval *humanizeutil.BytesValue
+
+	origVal string
+
+	// percentResolver is used to turn a percent string into a value. See
+	// memoryPercentResolver() and diskPercentResolverFactory().
+	percentResolver percentResolverFunc
+
+	// allowPercentOfFree indicates whether the value may also be expressed
+	// as a percentage of the free space (e.g. 20%free), in which case the
+	// owner of the value is responsible for resolving it with a suitable
+	// percentResolver (see isPercentOfFree()).
+	allowPercentOfFree bool
+}
+
+// percentOfFreeSuffix is the suffix used to express a value as a percentage of
+// the free (rather than total) capacity of a storage device.
+const percentOfFreeSuffix = "%free"
+
+const (
+	// defaultCGroupCPUQuotaPath and defaultCGroupCPUPeriodPath are where the
+	// CPU limit of a container lives with cgroups v1.
+	defaultCGroupCPUQuotaPath  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	defaultCGroupCPUPeriodPath = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+	// defaultCGroupV2CPUPath is where the CPU limit lives with the unified
+	// hierarchy used by cgroups v2.
+	defaultCGroupV2CPUPath = "/sys/fs/cgroup/cpu.max"
+)
+
+// getCGroupCPULimit returns the number of CPUs the process is limited to by
+// cgroups, if any.
+func getCGroupCPULimit() (float64, bool) {
+	if buf, err := ioutil.ReadFile(defaultCGroupV2CPUPath); err == nil {
+		return parseCGroupCPULimit(string(buf))
+	}
+	quota, err := ioutil.ReadFile(defaultCGroupCPUQuotaPath)
+	if err != nil {
+		return 0, false
+	}
+	period, err := ioutil.ReadFile(defaultCGroupCPUPeriodPath)
+	if err != nil {
+		return 0, false
+	}
+	return parseCGroupCPULimit(strings.TrimSpace(string(quota)) + " " + string(period))
+}
+
+// parseCGroupCPULimit parses a CPU limit in the "<quota> <period>" format of
+// cpu.max, where a quota of "max" (or -1, for cgroups v1) means no limit.
+func parseCGroupCPULimit(s string) (float64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || quota <= 0 {
+		return 0, false
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+	return quota / period, true
+}
+
+// goProcsMismatch returns whether the GOMAXPROCS value procs is more than 50%
+// off from the CPU limit cpus, rounded up to a whole number of CPUs.
+func goProcsMismatch(procs int, cpus float64) bool {
+	limit := math.Ceil(cpus)
+	return float64(procs) > 1.5*limit || 1.5*float64(procs) < limit
+}
+
+// describeGoProcs returns a description of the effective GOMAXPROCS value
+// for the startup summary, and warns if it doesn't fit the CPU limit of the
+// container the process runs in.
+func describeGoProcs(ctx context.Context) string {
+	procs := runtime.GOMAXPROCS(0)
+	cpuList := gosigar.CpuList{}
+	numCPU := runtime.NumCPU()
+	if err := cpuList.Get(); err == nil && len(cpuList.List) > 0 {
+		numCPU = len(cpuList.List)
+	}
+	desc := fmt.Sprintf("%d (%d CPUs detected)", procs, numCPU)
+	if limit, ok := getCGroupCPULimit(); ok {
+		desc = fmt.Sprintf("%d (%d CPUs detected, cgroup limit %.2f CPUs)", procs, numCPU, limit)
+		if goProcsMismatch(procs, limit) {
+			log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+				"GOMAXPROCS is %d, but the process is limited to %.2f CPUs; "+
+					"consider using --%s to match the limit", procs, limit, cliflags.GoProcs.Name))
+		}
+	}
+	log.Infof(ctx, "GOMAXPROCS: %s", desc)
+	return desc
+}
+
+// memoryPercentResolver turns a percent into the respective fraction of the
+// system's internal memory, or of the cgroup memory limit if that is lower
+// (see server.GetTotalMemory).
+func memoryPercentResolver(percent int) (int64, error) {
+	sizeBytes, err := server.GetTotalMemory(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+	return (sizeBytes * int64(percent)) / 100, nil
+}
+
+// getFileSystemUsage returns the usage of the storage device on which dir is
+// located.
+//
+// An error is returned if dir does not exist or if the device is too large.
+func getFileSystemUsage(dir string) (gosigar.FileSystemUsage, error) {
+	fileSystemUsage := gosigar.FileSystemUsage{}
+	if err := fileSystemUsage.Get(dir); err != nil {
+		return gosigar.FileSystemUsage{}, err
+	}
+	if fileSystemUsage.Total > math.MaxInt64 {
+		return gosigar.FileSystemUsage{}, fmt.Errorf(
+			"unsupported disk size %s, max supported size is %s",
+			humanize.IBytes(fileSystemUsage.Total), humanizeutil.IBytes(math.MaxInt64))
+	}
+	return fileSystemUsage, nil
+}
+
+// memoryBackedFileSystems are the file system types whose contents are held
+// in RAM.
+var memoryBackedFileSystems = map[string]bool{
+	"tmpfs": true,
+	"ramfs": true,
+}
+
+// getFileSystemType returns the type (e.g. "ext4") of the file system on
+// which path is located, as found in the list of mounted file systems.
+func getFileSystemType(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolved
+	}
+	fs, err := getMountedFileSystem(absPath)
+	if err != nil {
+		return "", err
+	}
+	return fs.SysTypeName, nil
+}
+
+// getMountedFileSystem returns the mounted file system that the absolute path
+// is located on.
+func getMountedFileSystem(absPath string) (gosigar.FileSystem, error) {
+	fileSystems := gosigar.FileSystemList{}
+	if err := fileSystems.Get(); err != nil {
+		return gosigar.FileSystem{}, err
+	}
+	fs, ok := mountedFileSystem(absPath, fileSystems.List)
+	if !ok {
+		return gosigar.FileSystem{}, errors.Errorf("no mounted file system found for %s", absPath)
+	}
+	return fs, nil
+}
+
+// mountedFileSystem returns the file system, among the given mounts, that
+// absPath is located on: the one with the longest mount point containing it.
+// Of file systems mounted on the same point, the last one shadows the others.
+func mountedFileSystem(absPath string, mounts []gosigar.FileSystem) (gosigar.FileSystem, bool) {
+	var result gosigar.FileSystem
+	var found bool
+	for _, fs := range mounts {
+		if !pathWithin(fs.DirName, absPath) {
+			continue
+		}
+		if !found || len(fs.DirName) >= len(result.DirName) {
+			result, found = fs, true
+		}
+	}
+	return result, found
+}
+
+// storeDevice describes the on-disk stores with a configured size that are
+// located on the same storage device.
+type storeDevice struct {
+	// mountPoint identifies the device.
+	mountPoint string
+	// capacity is the total capacity of the device.
+	capacity int64
+	// stores lists the paths of the stores on the device.
+	stores []string
+	// size is the combined configured size of the stores.
+	size int64
+}
+
+// getStoreDevices groups the on-disk stores among specs that have a
+// configured size by the device they are located on. Percentage sizes are
+// resolved against the capacity of the device. The store directories must
+// exist.
+func getStoreDevices(specs []base.StoreSpec) ([]*storeDevice, error) {
+	var devices []*storeDevice
+	byMountPoint := make(map[string]*storeDevice)
+	for _, spec := range specs {
+		if spec.InMemory || (spec.SizeInBytes == 0 && spec.SizePercent == 0) {
+			continue
+		}
+		absPath, err := filepath.Abs(spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+			absPath = resolved
+		}
+		usage, err := getFileSystemUsage(absPath)
+		if err != nil {
+			return nil, err
+		}
+		fs, err := getMountedFileSystem(absPath)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := byMountPoint[fs.DirName]
+		if !ok {
+			d = &storeDevice{mountPoint: fs.DirName, capacity: int64(usage.Total)}
+			byMountPoint[fs.DirName] = d
+			devices = append(devices, d)
+		}
+		d.stores = append(d.stores, spec.Path)
+		if spec.SizeInBytes > 0 {
+			d.size += spec.SizeInBytes
+		} else {
+			d.size += int64(float64(d.capacity) * spec.SizePercent / 100)
+		}
+	}
+	return devices, nil
+}
+
+// oversizedStoreDevices describes each of the given devices whose capacity
+// is exceeded by the combined configured size of its stores.
+func oversizedStoreDevices(devices []*storeDevice) []string {
+	var problems []string
+	for _, d := range devices {
+		if d.size <= d.capacity {
+			continue
+		}
+		what, whose := "store "+d.stores[0]+" is configured with a size of", "its"
+		if len(d.stores) > 1 {
+			what = "stores " + strings.Join(d.stores, ", ") + " are configured with a combined size of"
+			whose = "their"
+		}
+		problems = append(problems, fmt.Sprintf("%s %s, but %s device (mounted on %s) only has %s",
+			what, humanizeutil.IBytes(d.size), whose, d.mountPoint, humanizeutil.IBytes(d.capacity)))
+	}
+	return problems
+}
+
+// checkStoreSizes verifies that the sizes configured for the on-disk stores
+// fit on their devices. Violations are reported with a warning or, with
+// --strict-store-size, as an error.
+func checkStoreSizes(ctx context.Context, specs []base.StoreSpec) error {
+	devices, err := getStoreDevices(specs)
+	if err != nil {
+		log.Infof(ctx, "unable to check the store sizes against their devices: %s", err)
+		return nil
+	}
+	for _, problem := range oversizedStoreDevices(devices) {
+		if startCtx.strictStoreSize {
+			return errors.Errorf("%s; refusing to start because of --%s",
+				problem, cliflags.StrictStoreSize.Name)
+		}
+		log.Shout(ctx, log.Severity_WARNING, problem+"; the node will fail once the device fills up")
+	}
+	return nil
+}
+
+// inMemoryStoresSize returns the combined size of the in-memory stores among
+// specs, resolving percentages against totalMemory.
+func inMemoryStoresSize(specs []base.StoreSpec, totalMemory int64) int64 {
+	var size int64
+	for _, spec := range specs {
+		if !spec.InMemory {
+			continue
+		}
+		if spec.SizeInBytes > 0 {
+			size += spec.SizeInBytes
+		} else {
+			size += int64(float64(totalMemory) * spec.SizePercent / 100)
+		}
+	}
+	return size
+}
+
+// inMemorySizeWarning returns a problem if the in-memory stores, the cache
+// and the SQL memory pool together use more than safeCacheMemoryFraction of
+// the total memory, or "" otherwise.
+func inMemorySizeWarning(storesSize, cacheSize, sqlPoolSize, totalMemory int64) string {
+	total := storesSize + cacheSize + sqlPoolSize
+	safeSize := int64(float64(totalMemory) * safeCacheMemoryFraction)
+	if total <= safeSize {
+		return ""
+	}
+	return fmt.Sprintf("the in-memory stores (%s), --%s (%s) and --%s (%s) add up to %s, "+
+		"more than the %s of the %s of total memory that can safely be used for them",
+		humanizeutil.IBytes(storesSize), cliflags.Cache.Name, humanizeutil.IBytes(cacheSize),
+		cliflags.SQLMem.Name, humanizeutil.IBytes(sqlPoolSize), humanizeutil.IBytes(total),
+		humanizeutil.IBytes(safeSize), humanizeutil.IBytes(totalMemory))
+}
+
+// checkInMemorySize verifies that the in-memory stores, if any, fit in
+// memory along with the cache and the SQL memory pool. Violations are
+// reported with a warning or, with --strict-store-size, as an error.
+func checkInMemorySize(ctx context.Context, specs []base.StoreSpec) error {
+	hasInMemory := false
+	for _, spec := range specs {
+		hasInMemory = hasInMemory || spec.InMemory
+	}
+	if !hasInMemory {
+		return nil
+	}
+	totalMemory, err := server.GetTotalMemory(ctx)
+	if err != nil {
+		log.Infof(ctx, "unable to check the in-memory store sizes against the total memory: %s", err)
+		return nil
+	}
+	problem := inMemorySizeWarning(inMemoryStoresSize(specs, totalMemory),
+		serverCfg.CacheSize, serverCfg.SQLMemoryPoolSize, totalMemory)
+	if problem == "" {
+		return nil
+	}
+	if startCtx.strictStoreSize {
+		return errors.Errorf("%s; refusing to start because of --%s",
+			problem, cliflags.StrictStoreSize.Name)
+	}
+	log.Shout(ctx, log.Severity_WARNING, problem+"; the node risks running out of memory")
+	return nil
+}
+
+// diskPercentResolverFactory takes in a path and produces a percentResolverFunc
+// bound to the respective storage device.
+//
+// An error is returned if dir does not exist.
+func diskPercentResolverFactory(dir string) (percentResolverFunc, error) {
+	fileSystemUsage, err := getFileSystemUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+	deviceCapacity := int64(fileSystemUsage.Total)
+
+	return func(percent int) (int64, error) {
+		return (deviceCapacity * int64(percent)) / 100, nil
+	}, nil
+}
+
+// diskFreePercentResolverFactory is like diskPercentResolverFactory, but the
+// produced percentResolverFunc resolves percentages against the space that is
+// currently available to unprivileged users on the device instead of its total
+// capacity.
+func diskFreePercentResolverFactory(dir string) (percentResolverFunc, error) {
+	fileSystemUsage, err := getFileSystemUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+	deviceAvail := int64(fileSystemUsage.Avail)
+
+	return func(percent int) (int64, error) {
+		return (deviceAvail * int64(percent)) / 100, nil
+	}, nil
+}
+
+// newBytesOrPercentageValue creates a bytesOrPercentageValue.
+//
+// v and percentResolver can be nil (either they're both specified or they're
+// both nil). If they're nil, then Resolve() has to be called later to get the
+// passed-in value.
+func newBytesOrPercentageValue(
+	v *int64, percentResolver func(percent int) (int64, error),
+) *bytesOrPercentageValue {
+	if v == nil {
+		v = new(int64)
+	}
+	return &bytesOrPercentageValue{
+		val:             v,
+		bval:            humanizeutil.NewBytesValue(v),
+		percentResolver: percentResolver,
+	}
+}
+
+func (b *bytesOrPercentageValue) Set(s string) error {
+	b.origVal = s
+	if b.allowPercentOfFree && strings.HasSuffix(s, percentOfFreeSuffix) {
+		s = strings.TrimSuffix(s, "free")
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return err
+		}
+		if percent < 0 || percent > 99 {
+			return fmt.Errorf("percentage %s out of range 0%% - 99%%", s)
+		}
+
+		if b.percentResolver == nil {
+			// percentResolver not set means that this flag is not yet supposed to set
+			// any value.
+			return nil
+		}
+
+		absVal, err := b.percentResolver(percent)
+		if err != nil {
+			return err
+		}
+		s = fmt.Sprint(absVal)
+	}
+	return b.bval.Set(s)
+}
+
+// Resolve can be called to get the flag's value (if any). If the flag had been
+// previously set, *v will be written.
+func (b *bytesOrPercentageValue) Resolve(v *int64, percentResolver percentResolverFunc) error {
+	// The flag was not passed on the command line.
+	if b.origVal == "" {
+		return nil
+	}
+	b.percentResolver = percentResolver
+	b.val = v
+	b.bval = humanizeutil.NewBytesValue(v)
+	return b.Set(b.origVal)
+}
+
+// isPercentOfFree returns whether the value was expressed as a percentage of
+// the free space, e.g. 20%free.
+func (b *bytesOrPercentageValue) isPercentOfFree() bool {
+	return b.allowPercentOfFree && strings.HasSuffix(b.origVal, percentOfFreeSuffix)
+}
+
+func (b *bytesOrPercentageValue) Type() string {
+	return b.bval.Type()
+}
+
+func (b *bytesOrPercentageValue) String() string {
+	return b.bval.String()
+}
+
+func (b *bytesOrPercentageValue) IsSet() bool {
+	return b.bval.IsSet()
+}
+
+var cacheSizeValue = newBytesOrPercentageValue(&serverCfg.CacheSize, memoryPercentResolver)
+var sqlSizeValue = newBytesOrPercentageValue(&serverCfg.SQLMemoryPoolSize, memoryPercentResolver)
+var goMemoryValue = newBytesOrPercentageValue(&startCtx.maxGoMemory, memoryPercentResolver)
+var diskTempStorageSizeValue = func() *bytesOrPercentageValue {
+	v := newBytesOrPercentageValue(nil /* v */, nil /* percentResolver */)
+	v.allowPercentOfFree = true
+	return v
+}()
+
+const (
+	// joinFilePrefix marks a --join entry naming a file which lists the
+	// addresses to join, one per line.
+	joinFilePrefix = "@"
+	// joinSRVPrefix marks a --join entry naming a DNS SRV record whose
+	// targets are the addresses to join.
+	joinSRVPrefix = "srv+"
+)
+
+// resolveJoinList expands the --join entries that refer to a file or to a DNS
+// SRV record into the addresses they designate, and fills in the default port
+// for addresses that have none (see normalizeJoinAddr). This happens every
+// time the node starts so that changes in the set of join targets are picked
+// up on restart.
+func resolveJoinList(joinList base.JoinListType) (base.JoinListType, error) {
+	var resolved base.JoinListType
+	for _, commaSeparatedAddresses := range joinList {
+		for _, address := range strings.Split(commaSeparatedAddresses, ",") {
+			switch {
+			case strings.HasPrefix(address, joinFilePrefix):
+				path := strings.TrimPrefix(address, joinFilePrefix)
+				b, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, errors.Wrap(err, "unable to read --join file")
+				}
+				for _, line := range strings.Split(string(b), "\n") {
+					line = strings.TrimSpace(line)
+					if line == "" || strings.HasPrefix(line, "#") {
+						continue
+					}
+					resolved = append(resolved, line)
+				}
+			case strings.HasPrefix(address, joinSRVPrefix):
+				name := strings.TrimPrefix(address, joinSRVPrefix)
+				_, srvs, err := net.LookupSRV("", "", name)
+				if err != nil {
+					return nil, errors.Wrapf(err, "unable to resolve --join SRV record %s", name)
+				}
+				for _, srv := range srvs {
+					host := strings.TrimSuffix(srv.Target, ".")
+					resolved = append(resolved, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+				}
+			case address != "":
+				resolved = append(resolved, address)
+			}
+		}
+	}
+	for i := range resolved {
+		addr, err := normalizeJoinAddr(resolved[i])
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = addr
+	}
+	return resolved, nil
+}
+
+// advertiseHostResolveTimeout bounds the time spent resolving the advertised
+// host name with --resolve-advertise-host.
+const advertiseHostResolveTimeout = 10 * time.Second
+
+// resolveAdvertiseAddr returns addr with its host name replaced by the first
+// IP address that lookup resolves it to, along with that host name. An addr
+// whose host is already an IP address, or empty, is returned unchanged with
+// an empty host name.
+func resolveAdvertiseAddr(
+	ctx context.Context,
+	addr string,
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error),
+) (resolved string, host string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid advertised address %q", addr)
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return addr, "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, advertiseHostResolveTimeout)
+	defer cancel()
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unable to resolve --%s %s", cliflags.AdvertiseHost.Name, host)
+	}
+	if len(ips) == 0 {
+		return "", "", errors.Errorf("--%s %s resolves to no address", cliflags.AdvertiseHost.Name, host)
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), host, nil
+}
+
+// normalizeJoinAddr returns the --join address addr as host:port, using the
+// default RPC port if addr doesn't specify one. IPv6 addresses without a port
+// may be given with or without brackets.
+func normalizeJoinAddr(addr string) (string, error) {
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")); ip != nil {
+		return net.JoinHostPort(ip.String(), base.DefaultPort), nil
+	}
+	if !strings.Contains(addr, ":") {
+		return net.JoinHostPort(addr, base.DefaultPort), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid --%s address %q", cliflags.Join.Name, addr)
+	}
+	if port == "" {
+		port = base.DefaultPort
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", errors.Errorf("invalid --%s address %q: invalid port %q",
+			cliflags.Join.Name, addr, port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// resolveInsecure reconciles --secure and --insecure into whether the node
+// runs in insecure mode. It is an error for both flags to be given with
+// contradicting values.
+func resolveInsecure(secureSet, secure, insecureSet, insecure bool) (bool, error) {
+	if !secureSet {
+		return insecure, nil
+	}
+	if insecureSet && secure == insecure {
+		return false, errors.Errorf("--%s=%t conflicts with --%s=%t",
+			cliflags.ServerSecure.Name, secure, cliflags.ServerInsecure.Name, insecure)
+	}
+	return !secure, nil
+}
+
+// checkListenAddrs verifies that the SQL/RPC and HTTP listen addresses, which
+// may be bound to different interfaces, don't collide. An unspecified or
+// wildcard host overlaps with every host on the same port.
+func checkListenAddrs(addr, httpAddr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid listen address %q", addr)
+	}
+	httpHost, httpPort, err := net.SplitHostPort(httpAddr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid HTTP listen address %q", httpAddr)
+	}
+	if port != httpPort || port == "0" {
+		return nil
+	}
+	isWildcard := func(h string) bool {
+		ip := net.ParseIP(h)
+		return h == "" || (ip != nil && ip.IsUnspecified())
+	}
+	if host == httpHost || isWildcard(host) || isWildcard(httpHost) {
+		return errors.Errorf(
+			"listen address %s and HTTP listen address %s collide; "+
+				"use a different --%s or --%s", addr, httpAddr,
+			cliflags.ServerHTTPPort.Name, cliflags.ServerHTTPHost.Name)
+	}
+	return nil
+}
+
+// isAddrInUse returns whether err, as returned by net.Listen, reports that
+// the address is already in use.
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EADDRINUSE
+}
+
+// describeAddrHolder returns a description of the process holding the port of
+// addr, e.g. "port 26257 is held by PID 4242 (cockroach)", or "" if it can't
+// be found.
+func describeAddrHolder(addr string) string {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port == 0 {
+		return ""
+	}
+	pid, command, ok := findPortHolder(port)
+	if !ok {
+		return ""
+	}
+	desc := fmt.Sprintf("port %d is held by PID %d", port, pid)
+	if command == "" {
+		return desc
+	}
+	desc += fmt.Sprintf(" (%s)", command)
+	if command == "cockroach" {
+		desc += ", possibly a previous node which hasn't finished shutting down"
+	}
+	return desc
+}
+
+// procNetTCPListen is the state of listening sockets in /proc/net/tcp.
+const procNetTCPListen = "0A"
+
+// parseProcNetTCP returns the inodes of the sockets listening on port, as
+// listed in data, the contents of /proc/net/tcp or /proc/net/tcp6.
+func parseProcNetTCP(data []byte, port int) map[string]bool {
+	inodes := make(map[string]bool)
+	lines := strings.Split(string(data), "\n")
+	// The first line holds the column names.
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[3] != procNetTCPListen {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		if i < 0 {
+			continue
+		}
+		if p, err := strconv.ParseUint(fields[1][i+1:], 16, 16); err != nil || int(p) != port {
+			continue
+		}
+		if fields[9] != "0" {
+			inodes[fields[9]] = true
+		}
+	}
+	return inodes
+}
+
+// findPortHolderProc finds the process listening on port using the proc
+// filesystem mounted at procDir: the inodes of the listening sockets are
+// looked up in its net/tcp files, then in the file descriptors of each
+// process. Only the processes whose file descriptors are readable, i.e. those
+// of the same user unless running as root, can be found.
+func findPortHolderProc(procDir string, port int) (pid int, command string, ok bool) {
+	inodes := make(map[string]bool)
+	for _, name := range []string{"tcp", "tcp6"} {
+		data, err := ioutil.ReadFile(filepath.Join(procDir, "net", name))
+		if err != nil {
+			continue
+		}
+		for inode := range parseProcNetTCP(data, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, "", false
+	}
+	procs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, "", false
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procDir, proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] {
+				comm, _ := ioutil.ReadFile(filepath.Join(procDir, proc.Name(), "comm"))
+				return pid, strings.TrimSpace(string(comm)), true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// readLocalityFile reads locality tiers from the given file, which contains
+// either a --locality value or one tier per line, and merges them with the
+// tiers in flagLocality. The tiers of flagLocality take precedence over the
+// tiers of the file with the same key, and the ones without a counterpart in
+// the file are appended.
+func readLocalityFile(path string, flagLocality roachpb.Locality) (roachpb.Locality, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return roachpb.Locality{}, errors.Wrap(err, "unable to read locality file")
+	}
+	var tierStrs []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tierStrs = append(tierStrs, line)
+	}
+	var locality roachpb.Locality
+	if len(tierStrs) > 0 {
+		if err := locality.Set(strings.Join(tierStrs, ",")); err != nil {
+			return roachpb.Locality{}, errors.Wrapf(err, "invalid locality in %s", path)
+		}
+	}
+	for _, tier := range flagLocality.Tiers {
+		found := false
+		for i := range locality.Tiers {
+			if locality.Tiers[i].Key == tier.Key {
+				locality.Tiers[i].Value = tier.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			locality.Tiers = append(locality.Tiers, tier)
+		}
+	}
+	return locality, nil
+}
+
+// checkStoreDirs verifies that the directory of each on-disk store can be
+// created if needed, and that the current user can list it, create files in it
+// and create subdirectories in it. This turns permission problems (e.g. a
+// directory owned by another user) into a precise error at startup instead of
+// an obscure one from the storage engine.
+func checkStoreDirs(specs []base.StoreSpec) error {
+	for _, spec := range specs {
+		if spec.InMemory {
+			continue
+		}
+		if err := checkStoreDir(spec.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates dir, along with its missing parents, with the mode given by
+// --dir-mode.
+func mkdirAll(dir string) error {
+	return os.MkdirAll(dir, startCtx.dirMode.mode())
+}
+
+func checkStoreDir(dir string) error {
+	if err := mkdirAll(dir); err != nil {
+		return errors.Wrapf(err, "store directory %s cannot be created", dir)
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return errors.Wrapf(err, "store directory %s is not readable", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".probe")
+	if err != nil {
+		return errors.Wrapf(err, "store directory %s is not writable", dir)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return errors.Wrapf(err, "store directory %s does not allow removing files", dir)
+	}
+	sub, err := ioutil.TempDir(dir, ".probe")
+	if err != nil {
+		return errors.Wrapf(err, "store directory %s does not allow creating subdirectories", dir)
+	}
+	return os.Remove(sub)
+}
+
+// minSafeRaftElectionTimeout and minSafeLivenessRenewal are the values below
+// which the raft election timeout and the node liveness renewal interval
+// are likely to cause spurious elections and flapping liveness.
+const (
+	minSafeRaftElectionTimeout = time.Second
+	minSafeLivenessRenewal     = time.Second
+)
+
+// describeTimingConfig formats the raft and liveness related intervals of cfg
+// on a single line, and returns warnings for those that look unsafe.
+func describeTimingConfig(cfg server.Config) (string, []string) {
+	electionTimeout := cfg.RaftElectionTimeout()
+	leaseActive := cfg.RangeLeaseActiveDuration()
+	livenessActive, livenessRenewal := cfg.NodeLivenessDurations()
+	maxOffset := time.Duration(cfg.MaxOffset)
+
+	desc := fmt.Sprintf("raft tick=%s, election timeout=%s, lease=%s, liveness=%s (renewal %s), max offset=%s",
+		cfg.RaftTickInterval, electionTimeout, leaseActive, livenessActive, livenessRenewal, maxOffset)
+
+	var warnings []string
+	if electionTimeout < minSafeRaftElectionTimeout {
+		warnings = append(warnings, fmt.Sprintf(
+			"raft election timeout %s is below %s; expect spurious elections",
+			electionTimeout, minSafeRaftElectionTimeout))
+	}
+	if livenessRenewal < minSafeLivenessRenewal {
+		warnings = append(warnings, fmt.Sprintf(
+			"node liveness renewal interval %s is below %s; expect flapping node liveness",
+			livenessRenewal, minSafeLivenessRenewal))
+	}
+	if maxOffset > 0 && livenessActive <= maxOffset {
+		warnings = append(warnings, fmt.Sprintf(
+			"node liveness duration %s does not exceed the maximum clock offset %s",
+			livenessActive, maxOffset))
+	}
+	return desc, warnings
+}
+
+func initExternalIODir(ctx context.Context, firstStore base.StoreSpec) (string, error) {
+	if externalIODir == "" && !firstStore.InMemory {
+		externalIODir = filepath.Join(firstStore.Path, "extern")
+	}
+	if externalIODir == "" || externalIODir == "disabled" {
 		return "", nil
 	}
 	if !filepath.IsAbs(externalIODir) {
 		return "", errors.Errorf("%s path must be absolute", cliflags.ExternalIODir.Name)
 	}
-	return externalIODir, nil
+	// Symlinks inside the directory are deliberately followed, but the
+	// directory itself must not be redirected outside of --external-io-root.
+	realPath, err := resolveRealPath(externalIODir)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to resolve %s", cliflags.ExternalIODir.Name)
+	}
+	if externalIORoot != "" {
+		if !filepath.IsAbs(externalIORoot) {
+			return "", errors.Errorf("%s path must be absolute", cliflags.ExternalIORoot.Name)
+		}
+		realRoot, err := resolveRealPath(externalIORoot)
+		if err != nil {
+			return "", errors.Wrapf(err, "unable to resolve %s", cliflags.ExternalIORoot.Name)
+		}
+		if !pathWithin(realRoot, realPath) {
+			return "", errors.Errorf("%s %s resolves to %s, which is outside of %s %s",
+				cliflags.ExternalIODir.Name, externalIODir, realPath,
+				cliflags.ExternalIORoot.Name, externalIORoot)
+		}
+	}
+	// The directory is otherwise created when it is first written to, with
+	// the default mode.
+	if startCtx.dirMode != 0 {
+		if err := mkdirAll(realPath); err != nil {
+			return "", errors.Wrapf(err, "unable to create %s", cliflags.ExternalIODir.Name)
+		}
+	}
+	return realPath, nil
+}
+
+// resolveRealPath is like filepath.EvalSymlinks, but allows for trailing
+// components of path to not exist yet. Dangling symlinks are an error, since
+// they could be redirected anywhere once their target is created.
+func resolveRealPath(path string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(path); lerr == nil {
+			return "", errors.Errorf("%s is a dangling symlink", path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// pathWithin returns whether the absolute path is dir or lies below it.
+func pathWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// storeContainingPath returns the path of the on-disk store among specs that
+// contains path, if any.
+func storeContainingPath(path string, specs []base.StoreSpec) (string, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	for _, spec := range specs {
+		if spec.InMemory {
+			continue
+		}
+		storePath, err := filepath.Abs(spec.Path)
+		if err != nil {
+			continue
+		}
+		if pathWithin(storePath, absPath) {
+			return spec.Path, true
+		}
+	}
+	return "", false
 }
 
+// initTempStorageConfig resolves the temp storage flags against the first
+// store and creates the temporary subdirectory of the temp storage. It also
+// returns the abandoned temporary directories that --temp-dir-cleanup left in
+// place, which can't be logged before the logging is set up.
 func initTempStorageConfig(
 	ctx context.Context, firstStore base.StoreSpec,
-) (base.TempStorageConfig, error) {
+) (_ base.TempStorageConfig, keptTempDirs []string, _ error) {
+	prefix := startCtx.tempDirPrefix
+	if prefix == "" || strings.ContainsRune(prefix, filepath.Separator) {
+		return base.TempStorageConfig{}, nil, errors.Errorf(
+			"invalid --%s %q: must be a non-empty file name", cliflags.TempDirPrefix.Name, prefix)
+	}
+
 	var recordPath string
 	if !firstStore.InMemory {
 		recordPath = filepath.Join(firstStore.Path, server.TempDirsRecordFilename)
@@ -427,34 +2249,60 @@ func initTempStorageConfig(
 	// the temporary directory record file before creating any new
 	// temporary directories in case the disk is completely full.
 	if recordPath != "" {
-		if err = util.CleanupTempDirs(recordPath); err != nil {
-			return base.TempStorageConfig{}, errors.Wrap(err, "could not cleanup temporary directories from record file")
+		switch startCtx.tempDirCleanup {
+		case tempDirCleanupOn:
+			if err = util.CleanupTempDirs(recordPath); err != nil {
+				return base.TempStorageConfig{}, nil, errors.Wrap(err, "could not cleanup temporary directories from record file")
+			}
+		case tempDirCleanupDryRun, tempDirCleanupOff:
+			if keptTempDirs, err = util.RecordedTempDirs(recordPath); err != nil {
+				return base.TempStorageConfig{}, nil, errors.Wrap(err, "could not read temporary directories from record file")
+			}
 		}
 	}
 
 	// The temp store size can depend on the location of the first regular store
-	// (if it's expressed as a percentage), so we resolve that flag here.
+	// (if it's expressed as a percentage), so we resolve that flag here. An
+	// explicit --temp-dir puts the temp storage on disk even when the first
+	// store is in memory, in which case percentages are resolved against the
+	// device of the temp dir instead.
 	var tempStorePercentageResolver percentResolverFunc
-	if !firstStore.InMemory {
-		dir := firstStore.Path
-		// Create the store dir, if it doesn't exist. The dir is required to exist
-		// by diskPercentResolverFactory.
-		if err = os.MkdirAll(dir, 0755); err != nil {
-			return base.TempStorageConfig{}, errors.Wrapf(err, "failed to create dir for first store: %s", dir)
+	if !firstStore.InMemory || tempDir != "" {
+		dir := tempDir
+		if !firstStore.InMemory {
+			dir = firstStore.Path
+			// Create the store dir, if it doesn't exist. The dir is required to exist
+			// by diskPercentResolverFactory. Unlike the store dir, the temp dir must
+			// already exist.
+			if err = mkdirAll(dir); err != nil {
+				return base.TempStorageConfig{}, nil, errors.Wrapf(err, "failed to create dir for first store: %s", dir)
+			}
+		}
+		if diskTempStorageSizeValue.isPercentOfFree() {
+			tempStorePercentageResolver, err = diskFreePercentResolverFactory(dir)
+		} else {
+			tempStorePercentageResolver, err = diskPercentResolverFactory(dir)
 		}
-		tempStorePercentageResolver, err = diskPercentResolverFactory(dir)
 		if err != nil {
-			return base.TempStorageConfig{}, errors.Wrapf(err, "failed to create resolver for: %s", dir)
+			return base.TempStorageConfig{}, nil, errors.Wrapf(err, "failed to create resolver for: %s", dir)
 		}
 	} else {
+		if diskTempStorageSizeValue.isPercentOfFree() {
+			return base.TempStorageConfig{}, nil, errors.Errorf(
+				"--%s cannot be expressed as a percentage of free space when the first store is in memory "+
+					"and --%s is not specified",
+				cliflags.SQLTempStorage.Name, cliflags.TempDir.Name)
+		}
 		tempStorePercentageResolver = memoryPercentResolver
 	}
 	var tempStorageMaxSizeBytes int64
 	if err = diskTempStorageSizeValue.Resolve(
 		&tempStorageMaxSizeBytes, tempStorePercentageResolver,
 	); err != nil {
-		return base.TempStorageConfig{}, err
+		return base.TempStorageConfig{}, nil, err
 	}
+	// An explicit size of zero disables temp storage and is kept as is; only
+	// an unset flag falls back to the default.
 	if !diskTempStorageSizeValue.IsSet() {
 		// The default temp storage size is different when the temp
 		// storage is in memory (which occurs when no temp directory
@@ -462,42 +2310,580 @@ func initTempStorageConfig(
 		if tempDir == "" && firstStore.InMemory {
 			tempStorageMaxSizeBytes = base.DefaultInMemTempStorageMaxSizeBytes
 		} else {
-			tempStorageMaxSizeBytes = base.DefaultTempStorageMaxSizeBytes
+			tempStorageMaxSizeBytes = base.DefaultTempStorageMaxSizeBytes
+		}
+	}
+
+	// Initialize a base.TempStorageConfig based on first store's spec and
+	// cli flags.
+	tempStorageConfig := base.TempStorageConfigFromEnv(
+		ctx,
+		firstStore,
+		tempDir,
+		tempStorageMaxSizeBytes,
+	)
+
+	// Set temp directory to first store's path if the temp storage is not
+	// in memory.
+	if tempDir == "" && !tempStorageConfig.InMemory {
+		tempDir = firstStore.Path
+	}
+	// Create the temporary subdirectory for the temp engine.
+	if tempStorageConfig.Path, err = util.CreateTempDir(tempDir, prefix); err != nil {
+		return base.TempStorageConfig{}, nil, errors.Wrap(err, "could not create temporary directory for temp storage")
+	}
+
+	// We record the new temporary directory in the record file (if it
+	// exists) for cleanup in case the node crashes.
+	if recordPath != "" {
+		if err = util.RecordTempDir(recordPath, tempStorageConfig.Path); err != nil {
+			return base.TempStorageConfig{}, nil, errors.Wrapf(
+				err,
+				"could not record temporary directory path to record file: %s",
+				recordPath,
+			)
+		}
+	}
+
+	return tempStorageConfig, keptTempDirs, nil
+}
+
+// tempStorageProbeSize is the size of the file written by verifyTempStorage,
+// unless the temp storage cap is smaller.
+const tempStorageProbeSize = 64 << 20 // 64 MiB
+
+// verifyTempStorage writes a file of the given size to dir in large
+// sequential writes, like those of temp storage, syncs it and removes it.
+// This catches quotas and permission problems before a query spills.
+func verifyTempStorage(dir string, size int64) (err error) {
+	f, err := ioutil.TempFile(dir, ".verify-temp-storage.")
+	if err != nil {
+		return errors.Wrapf(err, "unable to create a file in temp storage directory %s", dir)
+	}
+	defer func() {
+		_ = f.Close()
+		if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+			err = errors.Wrapf(rmErr, "unable to remove %s", f.Name())
+		}
+	}()
+
+	buf := make([]byte, 1<<20)
+	for written := int64(0); written < size; {
+		n := int64(len(buf))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := f.Write(buf[:n]); err != nil {
+			return errors.Wrapf(err, "unable to write %s to temp storage directory %s",
+				humanizeutil.IBytes(size), dir)
+		}
+		written += n
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "unable to write %s to temp storage directory %s",
+			humanizeutil.IBytes(size), dir)
+	}
+	return nil
+}
+
+// logKeptTempDirs logs the abandoned temporary directories returned by
+// initTempStorageConfig.
+func logKeptTempDirs(ctx context.Context, paths []string) {
+	for _, path := range paths {
+		switch startCtx.tempDirCleanup {
+		case tempDirCleanupDryRun:
+			log.Infof(ctx, "%s=dry-run: would remove abandoned temporary directory %s",
+				cliflags.TempDirCleanup.Name, path)
+		case tempDirCleanupOff:
+			log.Infof(ctx, "%s=off: not removing abandoned temporary directory %s",
+				cliflags.TempDirCleanup.Name, path)
+		}
+	}
+}
+
+// describeTempStorage describes the resolved temp storage cap along with the
+// space of the device holding the temp directory, and returns whether the cap
+// exceeds the space available on that device.
+func describeTempStorage(cfg base.TempStorageConfig) (string, bool) {
+	if cfg.MaxSizeBytes == 0 {
+		return "disabled", false
+	}
+	if cfg.InMemory {
+		return fmt.Sprintf("%s in memory", humanizeutil.IBytes(cfg.MaxSizeBytes)), false
+	}
+	usage, err := getFileSystemUsage(cfg.Path)
+	if err != nil {
+		return fmt.Sprintf("%s (device usage unknown: %s)", humanizeutil.IBytes(cfg.MaxSizeBytes), err), false
+	}
+	return formatTempStorageCap(cfg.MaxSizeBytes, usage.Avail, usage.Total)
+}
+
+// formatTempStorageCap formats a temp storage cap relative to the available
+// and total space of its device, and returns whether it exceeds the available
+// space.
+func formatTempStorageCap(maxSize int64, avail, total uint64) (string, bool) {
+	desc := fmt.Sprintf("%s (device: %s available of %s)",
+		humanizeutil.IBytes(maxSize), humanize.IBytes(avail), humanize.IBytes(total))
+	return desc, uint64(maxSize) > avail
+}
+
+// nodeIDFilename and clusterIDFilename are the names of the files written
+// into each on-disk store directory once the node has started, so that
+// external tooling can identify the node without parsing the logs.
+const (
+	nodeIDFilename    = "NODE_ID"
+	clusterIDFilename = "CLUSTER_ID"
+)
+
+// writeFileAtomically writes data to a temporary file in the same directory
+// as path and then renames it into place, so that readers never observe a
+// partially written file.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// runOnReadyExec runs the --on-ready-exec executable with the given
+// additional environment variables, and logs its output.
+func runOnReadyExec(ctx context.Context, path string, env []string) error {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Infof(ctx, "output of %s:\n%s", path, output)
+	}
+	return errors.Wrapf(err, "--%s %s failed", cliflags.OnReadyExec.Name, path)
+}
+
+// connectionInfo holds the parameters needed to connect to a started node,
+// as written to --connection-info-file.
+type connectionInfo struct {
+	SQLURL    string         `json:"sql_url"`
+	AdminURL  string         `json:"admin_url"`
+	ClusterID string         `json:"cluster_id"`
+	NodeID    roachpb.NodeID `json:"node_id"`
+	Secure    bool           `json:"secure"`
+	Socket    string         `json:"socket,omitempty"`
+}
+
+// writeConnectionInfo atomically writes info to path as JSON.
+func writeConnectionInfo(path string, info connectionInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, append(b, '\n'), 0644)
+}
+
+// writeReportedConfig atomically writes the reported server configuration to
+// path as JSON.
+func writeReportedConfig(path string, rc server.ReportedConfig) error {
+	b, err := json.MarshalIndent(rc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(writeFileAtomically(path, append(b, '\n'), 0644),
+		"unable to write the reported configuration")
+}
+
+// formatStartupMetrics renders the startup metrics written to
+// --startup-metrics-file in the Prometheus text exposition format, as
+// consumed by node_exporter's textfile collector.
+func formatStartupMetrics(
+	startDuration time.Duration, initialBoot bool, nodeID roachpb.NodeID,
+) []byte {
+	var buf bytes.Buffer
+	metric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	var boot int
+	if initialBoot {
+		boot = 1
+	}
+	metric("cockroach_start_duration_seconds", "Time taken by the node to start.",
+		startDuration.Seconds())
+	metric("cockroach_initial_boot", "Whether the node was started for the first time.", boot)
+	metric("cockroach_node_id", "The ID of the node.", nodeID)
+	return buf.Bytes()
+}
+
+// startupSummaryField is a line of the startup summary. The label includes
+// the trailing colon, as displayed in the human-readable table.
+type startupSummaryField struct {
+	label, value string
+}
+
+// formatStartupSummary renders the startup summary as the human-readable
+// table printed on stdout and written to --startup-summary-file.
+func formatStartupSummary(
+	startTime time.Time, startDuration time.Duration, fields []startupSummaryField,
+) (string, error) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 2, 1, 2, ' ', 0)
+	fmt.Fprintf(tw, "CockroachDB node starting at %s (took %0.1fs)\n", startTime, startDuration.Seconds())
+	for _, f := range fields {
+		fmt.Fprintf(tw, "%s\t%s\n", f.label, f.value)
+	}
+	if err := tw.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// formatStructuredStartupSummary renders the startup summary as a
+// single-line JSON object, for logs consumed by a structured log pipeline.
+// The keys are derived from the labels of the human-readable table, e.g.
+// "listen addr:" becomes "listen_addr", and appear in the same order.
+func formatStructuredStartupSummary(
+	startTime time.Time, startDuration time.Duration, fields []startupSummaryField,
+) (string, error) {
+	fields = append([]startupSummaryField{
+		{"started at:", startTime.Format(time.RFC3339Nano)},
+		{"took:", fmt.Sprintf("%0.1fs", startDuration.Seconds())},
+	}, fields...)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key := strings.TrimSuffix(strings.TrimSpace(f.label), ":")
+		key = strings.Replace(key, " ", "_", -1)
+		k, err := json.Marshal(key)
+		if err != nil {
+			return "", err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.String(), nil
+}
+
+// recordStoreIdentity writes the node and cluster IDs into every on-disk
+// store directory. If a directory already records IDs that differ from the
+// ones reported by the server (e.g. because the operator copied a store
+// directory from another node), a warning is shouted before the files are
+// overwritten.
+func recordStoreIdentity(
+	ctx context.Context, specs []base.StoreSpec, nodeID roachpb.NodeID, clusterID uuid.UUID,
+) {
+	ids := []struct {
+		filename, value string
+	}{
+		{nodeIDFilename, fmt.Sprint(nodeID)},
+		{clusterIDFilename, clusterID.String()},
+	}
+	for _, spec := range specs {
+		if spec.InMemory {
+			continue
+		}
+		for _, id := range ids {
+			path := filepath.Join(spec.Path, id.filename)
+			if prev, err := ioutil.ReadFile(path); err == nil {
+				if prevValue := strings.TrimSpace(string(prev)); prevValue != id.value {
+					log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+						"%s records %s, but the server reports %s; "+
+							"was this store directory copied from another node?",
+						path, prevValue, id.value))
+				}
+			} else if !os.IsNotExist(err) {
+				log.Warningf(ctx, "unable to read %s: %s", path, err)
+			}
+			if err := writeFileAtomically(path, []byte(id.value+"\n"), 0644); err != nil {
+				log.Warningf(ctx, "unable to record %s: %s", path, err)
+			}
+		}
+	}
+}
+
+// storeClusterID is the cluster ID recorded in an on-disk store by
+// recordStoreIdentity.
+type storeClusterID struct {
+	path      string
+	clusterID string
+}
+
+// readStoreClusterIDs returns the cluster IDs recorded in the on-disk stores,
+// in the order of specs. Stores that don't record a cluster ID, e.g. because
+// they are new, are omitted.
+func readStoreClusterIDs(specs []base.StoreSpec) ([]storeClusterID, error) {
+	var res []storeClusterID
+	for _, spec := range specs {
+		if spec.InMemory {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(spec.Path, clusterIDFilename))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read the cluster ID of store %s", spec.Path)
+		}
+		res = append(res, storeClusterID{path: spec.Path, clusterID: strings.TrimSpace(string(b))})
+	}
+	return res, nil
+}
+
+// checkStoreClusterIDs verifies that the stores belong to the cluster with the
+// expected ID, described by source (e.g. the flag it came from), or, if no
+// ID is expected, that they all belong to the same cluster.
+func checkStoreClusterIDs(ids []storeClusterID, expected, source string) error {
+	for _, id := range ids {
+		if expected == "" {
+			expected, source = id.clusterID, "store "+id.path
+			continue
+		}
+		if id.clusterID != expected {
+			return errors.Errorf(
+				"store %s belongs to cluster %s, but %s belongs to cluster %s; refusing to start "+
+					"a node with a store from another cluster",
+				id.path, id.clusterID, source, expected)
+		}
+	}
+	return nil
+}
+
+// joinClusterIDTimeout bounds the time spent asking each --join target for
+// its cluster ID.
+const joinClusterIDTimeout = 5 * time.Second
+
+// fetchJoinClusterID asks the --join targets, in order, for the ID of their
+// cluster, and returns the first answer along with the address that gave it.
+// An error is returned if none of them answers.
+func fetchJoinClusterID(ctx context.Context, joinList base.JoinListType) (string, string, error) {
+	var lastErr error
+	for _, entry := range joinList {
+		for _, addr := range strings.Split(entry, ",") {
+			if addr == "" {
+				continue
+			}
+			clusterID, err := func() (string, error) {
+				conn, _, stopper, err := dialClientGRPCConn(addr, false, /* useServerMaxOffset */
+					grpc.WithBlock(), grpc.WithTimeout(joinClusterIDTimeout))
+				if err != nil {
+					return "", err
+				}
+				defer stopper.Stop(ctx)
+				ctx, cancel := context.WithTimeout(ctx, joinClusterIDTimeout)
+				defer cancel()
+				resp, err := serverpb.NewAdminClient(conn).Cluster(ctx, &serverpb.ClusterRequest{})
+				if err != nil {
+					return "", err
+				}
+				return resp.ClusterID, nil
+			}()
+			if err == nil {
+				return clusterID, addr, nil
+			}
+			lastErr = errors.Wrapf(err, "unable to get the cluster ID from %s", addr)
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no --join targets to get the cluster ID from")
+	}
+	return "", "", lastErr
+}
+
+// joinClockOffsetTimeout bounds the time spent measuring the clock offset
+// of each --join target.
+const joinClockOffsetTimeout = 2 * time.Second
+
+// joinClockOffsetPings is the number of pings sent to each --join target.
+// The one with the shortest round trip gives the most accurate measurement.
+const joinClockOffsetPings = 3
+
+// maxJoinClockOffsetFraction is the fraction of --max-offset beyond which
+// the clock offset measured against a --join target is reported. The
+// cluster tolerates up to 80% of the maximum offset before nodes start
+// committing suicide, so this leaves time to fix the clocks.
+const maxJoinClockOffsetFraction = 0.5
+
+// joinClockOffset is the clock offset measured against a --join target.
+type joinClockOffset struct {
+	addr string
+	// offset is the estimated offset of the target's clock relative to the
+	// local one. The actual offset is within uncertainty of it.
+	offset, uncertainty time.Duration
+	// err is set if the offset couldn't be measured.
+	err error
+}
+
+func (o joinClockOffset) String() string {
+	if o.err != nil {
+		return fmt.Sprintf("%s <unknown>", o.addr)
+	}
+	sign := "+"
+	if o.offset < 0 {
+		sign = ""
+	}
+	return fmt.Sprintf("%s %s%s (±%s)", o.addr, sign, o.offset, o.uncertainty)
+}
+
+// measureJoinClockOffsets measures, concurrently, the clock offset of each
+// --join target using the RPC heartbeat. The results are in the order of
+// the targets.
+func measureJoinClockOffsets(
+	ctx context.Context, joinList base.JoinListType,
+) []joinClockOffset {
+	var offsets []joinClockOffset
+	for _, entry := range joinList {
+		for _, addr := range strings.Split(entry, ",") {
+			if addr != "" {
+				offsets = append(offsets, joinClockOffset{addr: addr})
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range offsets {
+		wg.Add(1)
+		go func(o *joinClockOffset) {
+			defer wg.Done()
+			o.offset, o.uncertainty, o.err = measureClockOffset(ctx, o.addr)
+		}(&offsets[i])
+	}
+	wg.Wait()
+	return offsets
+}
+
+// measureClockOffset estimates the offset of the clock of the node at addr
+// relative to the local clock, assuming that the node read its clock
+// halfway through the round trip of a ping. The uncertainty is half the
+// round trip.
+func measureClockOffset(
+	ctx context.Context, addr string,
+) (offset, uncertainty time.Duration, _ error) {
+	conn, _, stopper, err := dialClientGRPCConn(addr, false, /* useServerMaxOffset */
+		grpc.WithBlock(), grpc.WithTimeout(joinClockOffsetTimeout))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stopper.Stop(ctx)
+	ctx, cancel := context.WithTimeout(ctx, joinClockOffsetTimeout)
+	defer cancel()
+
+	client := rpc.NewHeartbeatClient(conn)
+	minRoundTrip := time.Duration(-1)
+	for i := 0; i < joinClockOffsetPings; i++ {
+		// The max offset is left unset: a mismatch with the one configured on
+		// the target would make it crash.
+		sent := timeutil.Now()
+		resp, err := client.Ping(ctx, &rpc.PingRequest{})
+		if err != nil {
+			return 0, 0, err
+		}
+		roundTrip := timeutil.Since(sent)
+		if minRoundTrip < 0 || roundTrip < minRoundTrip {
+			minRoundTrip = roundTrip
+			offset = time.Unix(0, resp.ServerTime).Sub(sent.Add(roundTrip / 2))
+		}
+	}
+	return offset, minRoundTrip / 2, nil
+}
+
+// excessiveJoinClockOffsets describes the measured clock offsets that
+// certainly exceed maxJoinClockOffsetFraction of maxOffset, even accounting
+// for their uncertainty.
+func excessiveJoinClockOffsets(offsets []joinClockOffset, maxOffset time.Duration) []string {
+	threshold := time.Duration(float64(maxOffset) * maxJoinClockOffsetFraction)
+	var problems []string
+	for _, o := range offsets {
+		if o.err != nil {
+			continue
+		}
+		abs := o.offset
+		if abs < 0 {
+			abs = -abs
+		}
+		if abs-o.uncertainty > threshold {
+			problems = append(problems, fmt.Sprintf(
+				"the clock of join target %s is offset by %s (±%s) from the local clock, "+
+					"more than %.0f%% of the maximum offset of %s",
+				o.addr, o.offset, o.uncertainty, maxJoinClockOffsetFraction*100, maxOffset))
+		}
+	}
+	return problems
+}
+
+// checkJoinClockOffsets measures the clock offsets of the --join targets and
+// warns about, or with --join-clock-check=strict refuses, those that exceed
+// maxJoinClockOffsetFraction of --max-offset. Targets that can't be reached,
+// e.g. because the rest of the cluster is starting too, are only logged.
+func checkJoinClockOffsets(ctx context.Context) ([]joinClockOffset, error) {
+	if startCtx.joinClockCheck == joinClockCheckOff || len(serverCfg.JoinList) == 0 {
+		return nil, nil
+	}
+	offsets := measureJoinClockOffsets(ctx, serverCfg.JoinList)
+	for _, o := range offsets {
+		if o.err != nil {
+			log.Infof(ctx, "unable to measure the clock offset of join target %s: %s", o.addr, o.err)
+		} else {
+			log.Infof(ctx, "clock offset of join target %s", o)
 		}
 	}
-
-	// Initialize a base.TempStorageConfig based on first store's spec and
-	// cli flags.
-	tempStorageConfig := base.TempStorageConfigFromEnv(
-		ctx,
-		firstStore,
-		tempDir,
-		tempStorageMaxSizeBytes,
-	)
-
-	// Set temp directory to first store's path if the temp storage is not
-	// in memory.
-	if tempDir == "" && !tempStorageConfig.InMemory {
-		tempDir = firstStore.Path
-	}
-	// Create the temporary subdirectory for the temp engine.
-	if tempStorageConfig.Path, err = util.CreateTempDir(tempDir, server.TempDirPrefix); err != nil {
-		return base.TempStorageConfig{}, errors.Wrap(err, "could not create temporary directory for temp storage")
+	for _, problem := range excessiveJoinClockOffsets(offsets, time.Duration(serverCfg.MaxOffset)) {
+		if startCtx.joinClockCheck == joinClockCheckStrict {
+			return nil, errors.Errorf("%s; refusing to start because of --%s=%s",
+				problem, cliflags.JoinClockCheck.Name, startCtx.joinClockCheck.String())
+		}
+		log.Shout(ctx, log.Severity_WARNING, problem+"; synchronize the clocks (e.g. with NTP) "+
+			"to avoid the node being shut down for clock skew")
 	}
+	return offsets, nil
+}
 
-	// We record the new temporary directory in the record file (if it
-	// exists) for cleanup in case the node crashes.
-	if recordPath != "" {
-		if err = util.RecordTempDir(recordPath, tempStorageConfig.Path); err != nil {
-			return base.TempStorageConfig{}, errors.Wrapf(
-				err,
-				"could not record temporary directory path to record file: %s",
-				recordPath,
-			)
+// verifyStoreClusterIDs refuses to start the node when its stores belong to
+// a different cluster than the one given by --cluster-id or, with
+// --verify-store-cluster-id, the one the --join targets belong to.
+func verifyStoreClusterIDs(ctx context.Context) error {
+	ids, err := readStoreClusterIDs(serverCfg.Stores.Specs)
+	if err != nil {
+		return err
+	}
+	expected, source := startCtx.expectedClusterID, "--"+cliflags.ClusterID.Name
+	if expected != "" {
+		id, err := uuid.FromString(expected)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --%s", cliflags.ClusterID.Name)
+		}
+		expected = id.String()
+	} else if startCtx.verifyStoreClusterID && len(ids) > 0 && len(serverCfg.JoinList) > 0 {
+		clusterID, addr, err := fetchJoinClusterID(ctx, serverCfg.JoinList)
+		if err != nil {
+			// The rest of the cluster may be down too, e.g. during a full
+			// restart; the stores still have to agree with each other.
+			log.Warningf(ctx, "unable to verify the cluster ID of the stores: %s", err)
+		} else {
+			expected, source = clusterID, "join target "+addr
 		}
 	}
-
-	return tempStorageConfig, nil
+	return checkStoreClusterIDs(ids, expected, source)
 }
 
 // runStart starts the cockroach node using --store as the list of
@@ -513,6 +2899,23 @@ func runStart(cmd *cobra.Command, args []string) error {
 	if ok, err := maybeRerunBackground(); ok {
 		return err
 	}
+	applySkipUpdateCheckEnv(cmd.Flags(), &startCtx.disableUpdateCheck)
+
+	if startCtx.maxGoProcs > 0 {
+		runtime.GOMAXPROCS(startCtx.maxGoProcs)
+	}
+
+	var startupProfiler *startupCPUProfiler
+	if startCtx.cpuProfileOnStart > 0 {
+		if profilingDisabled() {
+			return errors.Errorf("--%s cannot be combined with --%s",
+				cliflags.CPUProfileOnStart.Name, cliflags.DisableProfiling.Name)
+		}
+		var err error
+		if startupProfiler, err = startStartupCPUProfile(startCtx.cpuProfileOnStart); err != nil {
+			return err
+		}
+	}
 
 	// Deal with flags that may depend on other flags.
 
@@ -521,7 +2924,31 @@ func runStart(cmd *cobra.Command, args []string) error {
 	ctx := opentracing.ContextWithSpan(context.Background(), sp)
 
 	var err error
-	if serverCfg.TempStorageConfig, err = initTempStorageConfig(ctx, serverCfg.Stores.Specs[0]); err != nil {
+	if err := checkListenAddrs(serverCfg.Addr, serverCfg.HTTPAddr); err != nil {
+		return err
+	}
+	if serverCfg.HTTPAdvertiseAddr != "" {
+		if _, err := base.ParseHTTPAdvertiseAddr(
+			serverCfg.HTTPAdvertiseAddr, serverCfg.HTTPRequestScheme(),
+		); err != nil {
+			return errors.Wrapf(err, "invalid --%s", cliflags.HTTPAdvertiseAddr.Name)
+		}
+	}
+	if serverCfg.JoinList, err = resolveJoinList(serverCfg.JoinList); err != nil {
+		return err
+	}
+	if startCtx.localityFile != "" {
+		if serverCfg.Locality, err = readLocalityFile(startCtx.localityFile, serverCfg.Locality); err != nil {
+			return err
+		}
+	}
+	if err := checkStoreDirs(serverCfg.Stores.Specs); err != nil {
+		return err
+	}
+	var keptTempDirs []string
+	if serverCfg.TempStorageConfig, keptTempDirs, err = initTempStorageConfig(
+		ctx, serverCfg.Stores.Specs[0],
+	); err != nil {
 		return err
 	}
 	if serverCfg.Settings.ExternalIODir, err = initExternalIODir(ctx, serverCfg.Stores.Specs[0]); err != nil {
@@ -529,22 +2956,153 @@ func runStart(cmd *cobra.Command, args []string) error {
 	}
 
 	// Use the server-specific values for some flags and settings.
+	if startCtx.serverInsecure, err = resolveInsecure(
+		cmd.Flags().Changed(cliflags.ServerSecure.Name), startCtx.serverSecure,
+		cmd.Flags().Changed(cliflags.ServerInsecure.Name), startCtx.serverInsecure,
+	); err != nil {
+		return err
+	}
 	serverCfg.Insecure = startCtx.serverInsecure
+	if len(serverCfg.InsecureAllow) > 0 && !serverCfg.Insecure {
+		return errors.Errorf("--%s requires --%s",
+			cliflags.InsecureAllow.Name, cliflags.ServerInsecure.Name)
+	}
 	serverCfg.SSLCertsDir = startCtx.serverSSLCertsDir
 	serverCfg.User = security.NodeUser
 
+	shutdownSignals := []os.Signal(startCtx.shutdownSignals)
+	if len(shutdownSignals) == 0 {
+		shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}
+	}
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(signalCh, shutdownSignals...)
 
 	// Set up the logging and profiling output.
 	// It is important that no logging occurs before this point or the log files
 	// will be created in $TMPDIR instead of their expected location.
-	stopper, err := setupAndInitializeLoggingAndProfiling(ctx)
+	stopper, logSetup, err := setupAndInitializeLoggingAndProfiling(
+		ctx, serverCfg.Stores.Specs, startCtx.serverInsecure, serverConnHost)
+	if err != nil {
+		return err
+	}
+	logStoreEvent(ctx, logSetup.storeLogDirs, "node starting (%s)", build.GetInfo().Short())
+	startupProfiler.setDir(ctx, logSetup.outputDir)
+	logKeptTempDirs(ctx, keptTempDirs)
+	if ext := serverCfg.Settings.ExternalIODir; ext != "" {
+		log.Infof(ctx, "external I/O path resolves to %s", ext)
+	}
+	maybeWarnRunningAsRoot(ctx)
+	goProcsDesc := describeGoProcs(ctx)
+	if memDesc, err := server.DescribeTotalMemory(ctx); err == nil {
+		log.Infof(ctx, "total memory: %s", memDesc)
+	}
+	if startCtx.loadedConfigFile != "" {
+		log.Infof(ctx, "loaded flags from config file %s", startCtx.loadedConfigFile)
+	}
+
+	if startCtx.maxGoMemory > 0 {
+		if setGoMemoryLimit(startCtx.maxGoMemory) {
+			log.Infof(ctx, "set Go memory limit to %s", humanizeutil.IBytes(startCtx.maxGoMemory))
+		} else {
+			log.Warningf(ctx, "--%s is not supported by this build (%s), ignoring",
+				cliflags.GoMem.Name, runtime.Version())
+			startCtx.maxGoMemory = 0
+		}
+	}
+
+	if err := checkStoreSizes(ctx, serverCfg.Stores.Specs); err != nil {
+		return err
+	}
+	if err := checkInMemorySize(ctx, serverCfg.Stores.Specs); err != nil {
+		return err
+	}
+
+	// The temp directory defaults to the first store, but it shouldn't live
+	// inside any of the other stores, whose disk accounting it would skew.
+	if p := serverCfg.TempStorageConfig.Path; p != "" {
+		if storePath, ok := storeContainingPath(p, serverCfg.Stores.Specs[1:]); ok {
+			log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+				"temporary directory %s is located inside store %s, which is not the first store; "+
+					"consider changing --%s", p, storePath, cliflags.TempDir.Name))
+		}
+		// The "disk" temp storage is no such thing on a memory-backed file
+		// system, and sizing it as a percentage of the disk then effectively
+		// sizes it as a percentage of RAM.
+		if cfg := serverCfg.TempStorageConfig; !cfg.InMemory && cfg.MaxSizeBytes != 0 {
+			if fsType, err := getFileSystemType(p); err != nil {
+				log.Infof(ctx, "unable to determine the file system type of %s: %s", p, err)
+			} else if memoryBackedFileSystems[fsType] {
+				log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+					"temporary directory %s is on a %s file system, which is backed by memory; "+
+						"temp storage (--%s) will consume RAM rather than disk space and may cause "+
+						"the node to run out of memory; consider changing --%s",
+					p, fsType, cliflags.SQLTempStorage.Name, cliflags.TempDir.Name))
+			}
+		}
+	}
+	if cfg := serverCfg.TempStorageConfig; startCtx.verifyTempStorage && !cfg.InMemory && cfg.MaxSizeBytes != 0 {
+		size := int64(tempStorageProbeSize)
+		if cfg.MaxSizeBytes < size {
+			size = cfg.MaxSizeBytes
+		}
+		if err := verifyTempStorage(cfg.Path, size); err != nil {
+			return errors.Wrapf(err, "temp storage check failed (--%s)", cliflags.VerifyTempStorage.Name)
+		}
+		log.Infof(ctx, "verified that %s can be written to temp storage directory %s",
+			humanizeutil.IBytes(size), cfg.Path)
+	}
+	tempStorageDesc, tempStorageOverAvail := describeTempStorage(serverCfg.TempStorageConfig)
+	if serverCfg.TempStorageConfig.MaxSizeBytes == 0 {
+		log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+			"temp storage is disabled by --%s=%s; queries that exceed their memory budget "+
+				"will fail instead of spilling to disk (leave the flag unset to use the default)",
+			cliflags.SQLTempStorage.Name, diskTempStorageSizeValue.origVal))
+	} else if tempStorageOverAvail {
+		log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf(
+			"the temp storage cap of %s exceeds the space available on its device; "+
+				"consider lowering --%s", tempStorageDesc, cliflags.SQLTempStorage.Name))
+	}
+	if !diskTempStorageSizeValue.IsSet() {
+		// Make the implicit default explicit, as it is often unexpected.
+		tempStorageDesc = "default " + tempStorageDesc
+	}
+	log.Infof(ctx, "temp storage cap: %s", tempStorageDesc)
+
+	// The advertised host name is resolved before the server is created, so
+	// that the node refuses to start if it doesn't resolve.
+	var advertiseHost string
+	if startCtx.resolveAdvertiseHost {
+		var resolved string
+		resolved, advertiseHost, err = resolveAdvertiseAddr(
+			ctx, serverCfg.AdvertiseAddr, net.DefaultResolver.LookupIPAddr)
+		if err != nil {
+			return err
+		}
+		if advertiseHost != "" {
+			log.Infof(ctx, "resolved --%s %s to %s",
+				cliflags.AdvertiseHost.Name, advertiseHost, resolved)
+		}
+		serverCfg.AdvertiseAddr = resolved
+	}
+
+	if err := verifyStoreClusterIDs(ctx); err != nil {
+		return err
+	}
+	joinClockOffsets, err := checkJoinClockOffsets(ctx)
 	if err != nil {
 		return err
 	}
 
 	serverCfg.Report(ctx)
+	if startCtx.reportConfigFile != "" {
+		if err := writeReportedConfig(startCtx.reportConfigFile, serverCfg.Reported()); err != nil {
+			log.Error(ctx, err)
+		}
+	}
+	timingDesc, timingWarnings := describeTimingConfig(serverCfg)
+	for _, w := range timingWarnings {
+		log.Shout(ctx, log.Severity_WARNING, w)
+	}
 
 	// Run the rest of the startup process in the background to avoid preventing
 	// proper handling of signals if we get stuck on something during
@@ -594,10 +3152,22 @@ func runStart(cmd *cobra.Command, args []string) error {
 			if err := s.Start(ctx); err != nil {
 				if le, ok := err.(server.ListenError); ok {
 					const errorPrefix = "consider changing the port via --"
-					if le.Addr == serverCfg.Addr {
-						err = errors.Wrap(err, errorPrefix+cliflags.ServerPort.Name)
-					} else if le.Addr == serverCfg.HTTPAddr {
-						err = errors.Wrap(err, errorPrefix+cliflags.ServerHTTPPort.Name)
+					if le.Advertise {
+						err = errors.Wrapf(err, "consider changing the advertised address via --%s",
+							cliflags.AdvertiseHost.Name)
+					} else {
+						var holder string
+						if isAddrInUse(errors.Cause(le)) {
+							if desc := describeAddrHolder(le.Addr); desc != "" {
+								holder = ": " + desc
+							}
+						}
+						if le.Addr == serverCfg.Addr {
+							err = errors.Wrap(err, "unable to bind SQL/RPC listener"+holder+"; "+errorPrefix+cliflags.ServerPort.Name)
+						} else if le.Addr == serverCfg.HTTPAddr {
+							err = errors.Wrapf(err, "unable to bind HTTP listener%s; consider changing the address via --%s or --%s",
+								holder, cliflags.ServerHTTPHost.Name, cliflags.ServerHTTPPort.Name)
+						}
 					}
 				}
 
@@ -610,7 +3180,7 @@ func runStart(cmd *cobra.Command, args []string) error {
 
 			// We don't do this in (*server.Server).Start() because we don't want it
 			// in tests.
-			if !envutil.EnvOrDefaultBool("COCKROACH_SKIP_UPDATE_CHECK", false) {
+			if !startCtx.disableUpdateCheck {
 				s.PeriodicallyCheckForUpdates()
 			}
 
@@ -618,55 +3188,168 @@ func runStart(cmd *cobra.Command, args []string) error {
 			if err != nil {
 				return err
 			}
-			var buf bytes.Buffer
 			info := build.GetInfo()
-			tw := tabwriter.NewWriter(&buf, 2, 1, 2, ' ', 0)
-			fmt.Fprintf(tw, "CockroachDB node starting at %s (took %0.1fs)\n", timeutil.Now(), timeutil.Since(tBegin).Seconds())
-			fmt.Fprintf(tw, "build:\t%s %s @ %s (%s)\n", info.Distribution, info.Tag, info.Time, info.GoVersion)
-			fmt.Fprintf(tw, "admin:\t%s\n", serverCfg.AdminURL())
-			fmt.Fprintf(tw, "sql:\t%s\n", pgURL)
+			startTime := timeutil.Now()
+			startDuration := startTime.Sub(tBegin)
+			var summary []startupSummaryField
+			addSummary := func(label, format string, args ...interface{}) {
+				summary = append(summary, startupSummaryField{label, fmt.Sprintf(format, args...)})
+			}
+			addSummary("build:", "%s %s @ %s (%s)", info.Distribution, info.Tag, info.Time, info.GoVersion)
+			addSummary("admin:", "%s", serverCfg.AdminURL())
+			addSummary("sql:", "%s", pgURL)
+			if serverCfg.Insecure {
+				addSummary("security:", "insecure")
+			} else {
+				addSummary("security:", "secure")
+			}
+			if startCtx.disableUpdateCheck {
+				addSummary("update checks:", "disabled")
+			}
+			addSummary("listen addr:", "%s", serverCfg.Addr)
+			addSummary("http addr:", "%s", serverCfg.HTTPAddr)
+			if advertiseHost != "" {
+				addSummary("advertise addr:", "%s (resolved from %s)", serverCfg.AdvertiseAddr, advertiseHost)
+			} else {
+				addSummary("advertise addr:", "%s", serverCfg.AdvertiseAddr)
+			}
+			if len(serverCfg.JoinList) > 0 {
+				addSummary("join:", "%s", strings.Join(serverCfg.JoinList, ","))
+			}
+			if len(joinClockOffsets) > 0 {
+				descs := make([]string, len(joinClockOffsets))
+				for i, o := range joinClockOffsets {
+					descs[i] = o.String()
+				}
+				addSummary("join clock offsets:", "%s", strings.Join(descs, ", "))
+			}
 			if len(serverCfg.SocketFile) != 0 {
-				fmt.Fprintf(tw, "socket:\t%s\n", serverCfg.SocketFile)
+				addSummary("socket:", "%s", serverCfg.SocketFile)
 			}
-			fmt.Fprintf(tw, "logs:\t%s\n", flag.Lookup("log-dir").Value)
+			addSummary("logs:", "%s", flag.Lookup("log-dir").Value)
+			addSummary("profiling:", "%s", profilingConfigString())
+			addSummary("timing:", "%s", timingDesc)
+			if startCtx.maxGoMemory > 0 {
+				addSummary("go memory limit:", "%s", humanizeutil.IBytes(startCtx.maxGoMemory))
+			}
+			addSummary("go procs:", "%s", goProcsDesc)
 			if serverCfg.Attrs != "" {
-				fmt.Fprintf(tw, "attrs:\t%s\n", serverCfg.Attrs)
+				addSummary("attrs:", "%s", serverCfg.Attrs)
 			}
 			if len(serverCfg.Locality.Tiers) > 0 {
-				fmt.Fprintf(tw, "locality:\t%s\n", serverCfg.Locality)
+				addSummary("locality:", "%s", serverCfg.Locality)
 			}
 			if s.TempDir() != "" {
-				fmt.Fprintf(tw, "temp dir:\t%s\n", s.TempDir())
+				addSummary("temp dir:", "%s", s.TempDir())
 			}
+			addSummary("temp storage cap:", "%s", tempStorageDesc)
 			if ext := s.ClusterSettings().ExternalIODir; ext != "" {
-				fmt.Fprintf(tw, "external I/O path: \t%s\n", ext)
+				addSummary("external I/O path: ", "%s", ext)
 			} else {
-				fmt.Fprintf(tw, "external I/O path: \t<disabled>\n")
+				addSummary("external I/O path: ", "<disabled>")
 			}
 			for i, spec := range serverCfg.Stores.Specs {
-				fmt.Fprintf(tw, "store[%d]:\t%s\n", i, spec)
+				addSummary(fmt.Sprintf("store[%d]:", i), "%s", spec)
+				if spec.InMemory {
+					continue
+				}
+				if usage, err := getFileSystemUsage(spec.Path); err != nil {
+					addSummary(fmt.Sprintf("store[%d] free:", i), "<unknown: %s>", err)
+				} else {
+					addSummary(fmt.Sprintf("store[%d] free:", i), "%s of %s",
+						humanize.IBytes(usage.Avail), humanize.IBytes(usage.Total))
+				}
 			}
 			initialBoot := s.InitialBoot()
 			nodeID := s.NodeID()
+			atomic.StoreInt32(&profileNodeID, int32(nodeID))
 			if initialBoot {
 				if nodeID == server.FirstNodeID {
-					fmt.Fprintf(tw, "status:\tinitialized new cluster\n")
+					addSummary("status:", "initialized new cluster")
 				} else {
-					fmt.Fprintf(tw, "status:\tinitialized new node, joined pre-existing cluster\n")
+					addSummary("status:", "initialized new node, joined pre-existing cluster")
 				}
 			} else {
-				fmt.Fprintf(tw, "status:\trestarted pre-existing node\n")
+				addSummary("status:", "restarted pre-existing node")
 			}
-			fmt.Fprintf(tw, "clusterID:\t%s\n", s.ClusterID())
-			fmt.Fprintf(tw, "nodeID:\t%d\n", nodeID)
-			if err := tw.Flush(); err != nil {
+			addSummary("clusterID:", "%s", s.ClusterID())
+			addSummary("nodeID:", "%d", nodeID)
+			msg, err := formatStartupSummary(startTime, startDuration, summary)
+			if err != nil {
 				return err
 			}
-			msg := buf.String()
-			log.Infof(ctx, "node startup completed:\n%s", msg)
+
+			recordStoreIdentity(ctx, serverCfg.Stores.Specs, nodeID, s.ClusterID())
+			for _, dir := range logSetup.storeLogDirs {
+				store := filepath.Dir(dir)
+				if usage, err := getFileSystemUsage(store); err != nil {
+					logStoreEvent(ctx, []string{dir}, "node %d started; store %s: free space unknown: %s",
+						nodeID, store, err)
+				} else {
+					logStoreEvent(ctx, []string{dir}, "node %d started; store %s: %s free of %s",
+						nodeID, store, humanize.IBytes(usage.Avail), humanize.IBytes(usage.Total))
+				}
+			}
+
+			if startCtx.logFormat == logFormatJSON {
+				structured, err := formatStructuredStartupSummary(startTime, startDuration, summary)
+				if err != nil {
+					return err
+				}
+				log.Infof(ctx, "node startup completed: %s", structured)
+			} else {
+				log.Infof(ctx, "node startup completed:\n%s", msg)
+			}
+			if startCtx.startupSummaryFile != "" {
+				if err := writeFileAtomically(startCtx.startupSummaryFile, []byte(msg), 0644); err != nil {
+					log.Error(ctx, err)
+				}
+			}
+			if startCtx.startupMetricsFile != "" {
+				metrics := formatStartupMetrics(startDuration, initialBoot, nodeID)
+				if err := writeFileAtomically(startCtx.startupMetricsFile, metrics, 0644); err != nil {
+					log.Error(ctx, err)
+				}
+			}
+			if startCtx.connectionInfoFile != "" {
+				connInfo := connectionInfo{
+					SQLURL:    pgURL.String(),
+					AdminURL:  serverCfg.AdminURL(),
+					ClusterID: s.ClusterID().String(),
+					NodeID:    nodeID,
+					Secure:    !serverCfg.Insecure,
+					Socket:    serverCfg.SocketFile,
+				}
+				if err := writeConnectionInfo(startCtx.connectionInfoFile, connInfo); err != nil {
+					log.Error(ctx, err)
+				}
+			}
 			if !log.LoggingToStderr(log.Severity_INFO) {
 				fmt.Print(msg)
 			}
+			startupProfiler.stop()
+			if interval, ok := sdnotify.WatchdogInterval(); ok {
+				healthy, err := selfHealthCheck(stopper)
+				if err != nil {
+					return err
+				}
+				log.Infof(ctx, "sending systemd watchdog keepalives every %s", interval/2)
+				startSDWatchdog(ctx, stopper, interval, healthy)
+			}
+			if startCtx.onReadyExec != "" {
+				env := []string{
+					"COCKROACH_SQL_URL=" + pgURL.String(),
+					"COCKROACH_ADMIN_URL=" + serverCfg.AdminURL(),
+					fmt.Sprintf("COCKROACH_NODE_ID=%d", nodeID),
+					"COCKROACH_CLUSTER_ID=" + s.ClusterID().String(),
+				}
+				if err := runOnReadyExec(ctx, startCtx.onReadyExec, env); err != nil {
+					if startCtx.onReadyStrict {
+						return err
+					}
+					log.Warning(ctx, err)
+				}
+			}
 			return nil
 		}(); err != nil {
 			errChan <- err
@@ -702,20 +3385,10 @@ func runStart(cmd *cobra.Command, args []string) error {
 		// timely, and we don't want logs to be lost.
 		log.SetSync(true)
 		log.Infof(shutdownCtx, "received signal '%s'", sig)
-		if sig == os.Interrupt {
-			// Graceful shutdown after an interrupt should cause the process
-			// to terminate with a non-zero exit code; however SIGTERM is
-			// "legitimate" and should be acknowledged with a success exit
-			// code. So we keep the error state here for later.
-			returnErr = &cliError{
-				exitCode: 1,
-				// INFO because a single interrupt is rather innocuous.
-				severity: log.Severity_INFO,
-				cause:    errors.New("interrupted"),
-			}
-			msgDouble := "Note: a second interrupt will skip graceful shutdown and terminate forcefully"
-			fmt.Fprintln(os.Stdout, msgDouble)
-		}
+		// We keep the error state, which determines the exit code, for later.
+		returnErr = gracefulShutdownError(sig, len(startCtx.shutdownSignals) > 0)
+		msgDouble := "Note: a second signal will skip graceful shutdown and terminate forcefully"
+		fmt.Fprintln(os.Stdout, msgDouble)
 		go func() {
 			serverStatusMu.Lock()
 			serverStatusMu.draining = true
@@ -741,21 +3414,31 @@ func runStart(cmd *cobra.Command, args []string) error {
 	const msgDrain = "initiating graceful shutdown of server"
 	log.Info(shutdownCtx, msgDrain)
 	fmt.Fprintln(os.Stdout, msgDrain)
+	if err := sdnotify.Notify("STOPPING=1"); err != nil {
+		log.Warning(shutdownCtx, err)
+	}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				log.Infof(context.Background(), "%d running tasks", stopper.NumTasks())
-			case <-stopper.ShouldStop():
-				return
-			case <-stopWithoutDrain:
-				return
+	if startCtx.shutdownLog != shutdownLogSilent {
+		go func() {
+			ticker := time.NewTicker(5 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if startCtx.shutdownLog == shutdownLogTasks {
+						log.Infof(context.Background(), "%d running tasks:\n%s",
+							stopper.NumTasks(), stopper.RunningTasks())
+					} else {
+						log.Infof(context.Background(), "%d running tasks", stopper.NumTasks())
+					}
+				case <-stopper.ShouldStop():
+					return
+				case <-stopWithoutDrain:
+					return
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	const hardShutdownHint = " - node may take longer to restart & clients may need to wait for leases to expire"
 	select {
@@ -786,11 +3469,21 @@ func runStart(cmd *cobra.Command, args []string) error {
 		fmt.Fprintln(os.Stdout, msgDone)
 	}
 
+	if returnErr != nil {
+		logStoreEvent(shutdownCtx, logSetup.storeLogDirs, "node shut down: %s", returnErr)
+	} else {
+		logStoreEvent(shutdownCtx, logSetup.storeLogDirs, "node shut down")
+	}
 	return returnErr
 }
 
 func maybeWarnCacheSize() {
 	if cacheSizeValue.IsSet() {
+		if size, err := server.GetTotalMemory(context.Background()); err == nil {
+			if w := cacheSizeWarning(serverCfg.CacheSize, serverCfg.SQLMemoryPoolSize, size); w != "" {
+				log.Shout(context.Background(), log.Severity_WARNING, w)
+			}
+		}
 		return
 	}
 
@@ -806,80 +3499,281 @@ func maybeWarnCacheSize() {
 	log.Warning(context.Background(), buf.String())
 }
 
-// setupAndInitializeLoggingAndProfiling does what it says on the label.
-// Prior to this however it determines suitable defaults for the
-// logging output directory and the verbosity level of stderr logging.
-// We only do this for the "start" command which is why this work
-// occurs here and not in an OnInitialize function.
-func setupAndInitializeLoggingAndProfiling(ctx context.Context) (*stop.Stopper, error) {
+// safeCacheMemoryFraction is the fraction of the total memory that the cache
+// and the SQL memory pool, along with the in-memory stores if any, can safely
+// use together. The rest is headroom for the Go heap and the other
+// allocations of the process.
+const safeCacheMemoryFraction = 0.75
+
+// cacheSizeWarning returns a warning if the cache is larger than what is left
+// of safeCacheMemoryFraction of the total memory once the SQL memory pool is
+// accounted for, or "" otherwise.
+func cacheSizeWarning(cacheSize, sqlPoolSize, totalMemory int64) string {
+	safeSize := int64(float64(totalMemory)*safeCacheMemoryFraction) - sqlPoolSize
+	if safeSize < 0 {
+		safeSize = 0
+	}
+	if cacheSize <= safeSize {
+		return ""
+	}
+	return fmt.Sprintf("--%s=%s is larger than the %s that can safely be used for the cache "+
+		"given the %s of total memory and --%s=%s; the node risks running out of memory, "+
+		"consider lowering --%s",
+		cliflags.Cache.Name, humanizeutil.IBytes(cacheSize), humanizeutil.IBytes(safeSize),
+		humanizeutil.IBytes(totalMemory), cliflags.SQLMem.Name, humanizeutil.IBytes(sqlPoolSize),
+		cliflags.Cache.Name)
+}
+
+// loggingSetup describes the logging and profiling output configuration
+// chosen by setupAndInitializeLoggingAndProfiling.
+type loggingSetup struct {
+	// logDir is the directory log files are written to. Empty if file logging
+	// is disabled.
+	logDir string
+	// outputDir is the directory profiles and backtraces are written to.
+	outputDir string
+	// silenceStderr is set if logging to stderr is turned off because the
+	// messages go to log files instead.
+	silenceStderr bool
+	// ambiguousLogDirs is set if the log directory was defaulted to the first
+	// of several on-disk stores.
+	ambiguousLogDirs bool
+	// storeLogDirs are the "logs" subdirectories of the on-disk stores, in
+	// order, that store-specific events are written to with --per-store-logs.
+	storeLogDirs []string
+	// profilingEnabled is set if periodic cpu or memory profiles are written.
+	profilingEnabled bool
+}
+
+// chooseLoggingSetup determines the log and output directories given the
+// store specs, the value of --log-dir and whether it (or the log directory in
+// general) was set explicitly, whether --logtostderr was set explicitly, and
+// whether --per-store-logs was specified. It has no side effects.
+func chooseLoggingSetup(
+	specs []base.StoreSpec, logDir string, logDirSet bool, logToStderrSet bool, perStoreLogs bool,
+) loggingSetup {
+	var res loggingSetup
+	if perStoreLogs {
+		for _, spec := range specs {
+			if !spec.InMemory {
+				res.storeLogDirs = append(res.storeLogDirs, filepath.Join(spec.Path, "logs"))
+			}
+		}
+	}
 	// Default the log directory to the "logs" subdirectory of the first
 	// non-memory store. If more than one non-memory stores is detected,
-	// print a warning.
-	ambiguousLogDirs := false
-	pf := cockroachCmd.PersistentFlags()
-	f := pf.Lookup(logflags.LogDirName)
-	if !log.DirSet() && !f.Changed {
+	// print a warning, unless --per-store-logs makes the choice of the first
+	// store deliberate.
+	if !logDirSet {
 		// We only override the log directory if the user has not explicitly
 		// disabled file logging using --log-dir="".
-		newDir := ""
-		for _, spec := range serverCfg.Stores.Specs {
+		logDir = ""
+		for _, spec := range specs {
 			if spec.InMemory {
 				continue
 			}
-			if newDir != "" {
-				ambiguousLogDirs = true
+			if logDir != "" {
+				res.ambiguousLogDirs = !perStoreLogs
 				break
 			}
-			newDir = filepath.Join(spec.Path, "logs")
-		}
-		if err := f.Value.Set(newDir); err != nil {
-			return nil, err
+			logDir = filepath.Join(spec.Path, "logs")
 		}
 	}
+	res.logDir = logDir
 
 	// We need an output directory below. We use the current directory unless
 	// the log directory is configured, in which case we use that.
-	outputDirectory := "."
-	if logDir := f.Value.String(); logDir != "" {
-		outputDirectory = logDir
-
-		ls := pf.Lookup(logflags.LogToStderrName)
-		if !ls.Changed {
-			// Unless the settings were overridden by the user, silence
-			// logging to stderr because the messages will go to a log file.
-			if err := ls.Value.Set(log.Severity_NONE.String()); err != nil {
-				return nil, err
+	res.outputDir = "."
+	if logDir != "" {
+		res.outputDir = logDir
+		// Unless the settings were overridden by the user, silence
+		// logging to stderr because the messages will go to a log file.
+		res.silenceStderr = !logToStderrSet
+	}
+	return res
+}
+
+// storeLogFilename is the name of the file, in each store's "logs"
+// subdirectory, that store-specific events are appended to with
+// --per-store-logs.
+const storeLogFilename = "cockroach-store.log"
+
+// logStoreEvent appends a timestamped line to the store log in each of dirs.
+// Failures are logged to the main log and otherwise ignored.
+func logStoreEvent(ctx context.Context, dirs []string, format string, args ...interface{}) {
+	line := fmt.Sprintf("%s %s\n", timeutil.Now().Format(time.RFC3339Nano), fmt.Sprintf(format, args...))
+	for _, dir := range dirs {
+		if err := appendStoreLog(dir, line); err != nil {
+			log.Warningf(ctx, "unable to write store log: %s", err)
+		}
+	}
+}
+
+func appendStoreLog(dir, line string) error {
+	f, err := os.OpenFile(filepath.Join(dir, storeLogFilename), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// profileDiskBudget returns the combined size budget of the profiles that are
+// written periodically, each kind of which gcProfiles keeps within
+// maxSizePerProfile.
+func profileDiskBudget() int64 {
+	var kinds int64
+	if profilingConfig.memProfileInterval > 0 {
+		kinds++
+		if jemallocHeapDump != nil && profilingConfig.memProfileSource == memProfileSourceBoth {
+			kinds++
+		}
+	}
+	if profilingConfig.cpuProfileInterval > 0 {
+		kinds++
+	}
+	return kinds * maxSizePerProfile
+}
+
+// formatOutputDirBudget describes the budgets for log files and profiles
+// sharing a directory, and returns whether they exceed the space available
+// to them, i.e. the free space of the device plus the space already used in
+// the directory.
+func formatOutputDirBudget(logBudget, profileBudget int64, avail uint64, used int64) (string, bool) {
+	total := logBudget + profileBudget
+	space := int64(avail) + used
+	if avail > math.MaxInt64 || space < 0 {
+		space = math.MaxInt64
+	}
+	desc := fmt.Sprintf("log files (--%s=%s) and profiles (%s) may use up to %s, with %s available",
+		logflags.LogFilesCombinedMaxSizeName, humanizeutil.IBytes(logBudget),
+		humanizeutil.IBytes(profileBudget), humanizeutil.IBytes(total), humanizeutil.IBytes(space))
+	return desc, total > space
+}
+
+// checkOutputDirBudget warns if the log files and profiles written to dir
+// may fill up its device. Both are garbage collected independently, so each
+// stays within its own budget without deleting the other's files.
+func checkOutputDirBudget(ctx context.Context, dir string) {
+	usage, err := getFileSystemUsage(dir)
+	if err != nil {
+		log.Warningf(ctx, "unable to check the space available for log files and profiles: %s", err)
+		return
+	}
+	var used int64
+	if files, err := ioutil.ReadDir(dir); err == nil {
+		for _, fi := range files {
+			if fi.Mode().IsRegular() {
+				used += fi.Size()
 			}
 		}
+	}
+	desc, exceeded := formatOutputDirBudget(
+		atomic.LoadInt64(&log.LogFilesCombinedMaxSize), profileDiskBudget(), usage.Avail, used)
+	if exceeded {
+		log.Shout(ctx, log.Severity_WARNING, fmt.Sprintf("%s: %s; consider lowering "+
+			"--%s or COCKROACH_MAX_SIZE_PER_PROFILE", dir, desc, logflags.LogFilesCombinedMaxSizeName))
+	} else {
+		log.Infof(ctx, "%s: %s", dir, desc)
+	}
+}
+
+// Only the first call to setupAndInitializeLoggingAndProfiling starts the
+// log file GC daemon and the profilers.
+var startLogGCDaemonOnce, startProfilersOnce sync.Once
+
+// suppressInsecureWarning downgrades the insecure mode banner to a single
+// informational log line, for throwaway clusters (e.g. in CI) where it only
+// adds noise. It has no effect unless both the SQL/RPC and HTTP listeners are
+// bound to loopback addresses.
+var suppressInsecureWarning = envutil.EnvOrDefaultBool("COCKROACH_SUPPRESS_INSECURE_WARNING", false)
+
+// isLoopbackHost returns whether host is "localhost" or a loopback IP address.
+// An empty host, which means listening on all interfaces, is not.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// setupAndInitializeLoggingAndProfiling does what it says on the label.
+// Prior to this however it determines suitable defaults for the
+// logging output directory and the verbosity level of stderr logging
+// (see chooseLoggingSetup). The configuration that was applied is
+// returned.
+// We only do this for the "start" command which is why this work
+// occurs here and not in an OnInitialize function.
+func setupAndInitializeLoggingAndProfiling(
+	ctx context.Context, specs []base.StoreSpec, insecure bool, listenHost string,
+) (*stop.Stopper, loggingSetup, error) {
+	pf := cockroachCmd.PersistentFlags()
+	f := pf.Lookup(logflags.LogDirName)
+	ls := pf.Lookup(logflags.LogToStderrName)
+	logDirSet := log.DirSet() || f.Changed
+	setup := chooseLoggingSetup(specs, f.Value.String(), logDirSet, ls.Changed, startCtx.perStoreLogs)
+	if setup.ambiguousLogDirs && startCtx.strictLogDir {
+		return nil, loggingSetup{}, errors.Errorf(
+			"multiple stores configured and --%s not specified; --%s requires an explicit --%s",
+			logflags.LogDirName, cliflags.StrictLogDir.Name, logflags.LogDirName)
+	}
+
+	if !logDirSet {
+		if err := f.Value.Set(setup.logDir); err != nil {
+			return nil, loggingSetup{}, err
+		}
+	}
+	if setup.silenceStderr {
+		if err := ls.Value.Set(log.Severity_NONE.String()); err != nil {
+			return nil, loggingSetup{}, err
+		}
+	}
 
+	if setup.logDir != "" {
 		// Make sure the path exists.
-		if err := os.MkdirAll(logDir, 0755); err != nil {
-			return nil, err
+		if err := mkdirAll(setup.logDir); err != nil {
+			return nil, loggingSetup{}, err
 		}
-		log.Eventf(ctx, "created log directory %s", logDir)
+		log.Eventf(ctx, "created log directory %s", setup.logDir)
 
 		// Start the log file GC daemon to remove files that make the log
 		// directory too large.
-		log.StartGCDaemon()
+		startLogGCDaemonOnce.Do(log.StartGCDaemon)
+	}
+	for _, dir := range setup.storeLogDirs {
+		if err := mkdirAll(dir); err != nil {
+			return nil, loggingSetup{}, err
+		}
 	}
 
-	if ambiguousLogDirs {
+	if setup.ambiguousLogDirs {
 		// Note that we can't report this message earlier, because the log directory
-		// may not have been ready before the call to MkdirAll() above.
+		// may not have been ready before the call to mkdirAll() above.
 		log.Shout(ctx, log.Severity_WARNING, "multiple stores configured"+
 			" and --log-dir not specified, you may want to specify --log-dir to disambiguate.")
 	}
 
-	if startCtx.serverInsecure {
+	if insecure && suppressInsecureWarning && isLoopbackHost(listenHost) && isLoopbackHost(serverHTTPHost) {
+		log.Infof(ctx, "running in insecure mode, listening on %s only "+
+			"(warning suppressed by COCKROACH_SUPPRESS_INSECURE_WARNING)", listenHost)
+	} else if insecure {
 		// Use a non-annotated context here since the annotation just looks funny,
 		// particularly to new users (made worse by it always printing as [n?]).
-		addr := serverConnHost
+		addr := listenHost
 		if addr == "" {
 			addr = "<all your IP addresses>"
 		}
+		clients := "any client"
+		if len(serverCfg.InsecureAllow) > 0 {
+			clients = "any client from " + serverCfg.InsecureAllow.String() + " or the loopback interface"
+		}
 		log.Shout(context.Background(), log.Severity_WARNING,
 			"RUNNING IN INSECURE MODE!\n\n"+
-				"- Your cluster is open for any client that can access "+addr+".\n"+
+				"- Your cluster is open for "+clients+" that can access "+addr+".\n"+
 				"- Any user, even root, can log in without providing a password.\n"+
 				"- Any user, connecting as root, can read or write any data in your cluster.\n"+
 				"- There is no network encryption nor authentication, and thus no confidentiality.\n\n"+
@@ -893,17 +3787,41 @@ func setupAndInitializeLoggingAndProfiling(ctx context.Context) (*stop.Stopper,
 	info := build.GetInfo()
 	log.Infof(ctx, info.Short())
 
-	initMemProfile(ctx, outputDirectory)
-	initCPUProfile(ctx, outputDirectory)
-	initBlockProfile()
+	startProfilersOnce.Do(func() {
+		if profileNamePrefixEnv != profileNamePrefix {
+			log.Warningf(ctx, "ignoring COCKROACH_PROFILE_PREFIX %q: it must not contain a dot or "+
+				"a path separator", profileNamePrefixEnv)
+		}
+		gcPanicProfiles(setup.outputDir, maxPanicProfiles)
+		log.SetPanicHook(func() { writePanicProfiles(ctx, setup.outputDir) })
+		if profilingDisabled() {
+			turnOffProfilers()
+			log.Infof(ctx, "profiling disabled by --%s or COCKROACH_DISABLE_PROFILING",
+				cliflags.DisableProfiling.Name)
+			return
+		}
+		initMemProfile(ctx, setup.outputDir)
+		initHeapDumpOnThreshold(ctx, setup.outputDir)
+		initCPUProfile(ctx, setup.outputDir)
+		initProfilerToggle(ctx)
+		initBlockProfile()
+		if profileUploadURI != "" && profileUploader == nil {
+			log.Warningf(ctx, "COCKROACH_PROFILE_UPLOAD_URI is not supported by this build, ignoring")
+		}
+	})
+	setup.profilingEnabled = profilingConfig.memProfileInterval > 0 ||
+		profilingConfig.cpuProfileInterval > 0
+	if setup.logDir != "" && setup.logDir == setup.outputDir {
+		checkOutputDirBudget(ctx, setup.outputDir)
+	}
 
 	// Disable Stopper task tracking as performing that call site tracking is
 	// moderately expensive (certainly outweighing the infrequent benefit it
 	// provides).
-	stopper := initBacktrace(outputDirectory)
+	stopper := initBacktrace(setup.outputDir)
 	log.Event(ctx, "initialized profiles")
 
-	return stopper, nil
+	return stopper, setup, nil
 }
 
 func addrWithDefaultHost(addr string) (string, error) {
@@ -911,17 +3829,100 @@ func addrWithDefaultHost(addr string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if host == "" {
-		host = "localhost"
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// getClientGRPCConn connects to the node specified on the command line. See
+// dialClientGRPCConn for the meaning of useServerMaxOffset.
+func getClientGRPCConn(
+	useServerMaxOffset bool,
+) (*grpc.ClientConn, *hlc.Clock, *stop.Stopper, error) {
+	addr, err := addrWithDefaultHost(serverCfg.AdvertiseAddr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return getClientGRPCConnForAddr(addr, useServerMaxOffset)
+}
+
+// getClientGRPCConnForAddr is like getClientGRPCConn, but connects to the
+// given address instead of the one specified on the command line.
+//
+// If --connect-retries or --connect-timeout is specified, the connection is
+// established eagerly so that failures can be retried (with exponential
+// backoff) within the --connect-timeout deadline. The last error is returned
+// if no attempt succeeds.
+func getClientGRPCConnForAddr(
+	addr string, useServerMaxOffset bool,
+) (*grpc.ClientConn, *hlc.Clock, *stop.Stopper, error) {
+	if cliCtx.connectRetries <= 0 && cliCtx.connectTimeout <= 0 {
+		return dialClientGRPCConn(addr, useServerMaxOffset)
+	}
+
+	ctx := context.Background()
+	if cliCtx.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cliCtx.connectTimeout)
+		defer cancel()
+	}
+	opts := retry.Options{
+		InitialBackoff: 250 * time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     5 * time.Second,
+	}
+	var err error
+	for attempt, r := 0, retry.StartWithCtx(ctx, opts); r.Next(); attempt++ {
+		// Each attempt blocks until the connection is up, for at most
+		// base.NetworkTimeout or whatever remains of the overall deadline.
+		timeout := base.NetworkTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := deadline.Sub(timeutil.Now()); remaining < timeout {
+				timeout = remaining
+			}
+		}
+		if timeout <= 0 {
+			break
+		}
+		var conn *grpc.ClientConn
+		var clock *hlc.Clock
+		var stopper *stop.Stopper
+		conn, clock, stopper, err = dialClientGRPCConn(
+			addr, useServerMaxOffset, grpc.WithBlock(), grpc.WithTimeout(timeout))
+		if err == nil {
+			return conn, clock, stopper, nil
+		}
+		if attempt >= cliCtx.connectRetries {
+			break
+		}
+		log.Infof(ctx, "unable to connect to %s (attempt %d of %d): %s",
+			addr, attempt+1, cliCtx.connectRetries+1, err)
+	}
+	if err == nil {
+		err = ctx.Err()
 	}
-	return net.JoinHostPort(host, port), nil
+	return nil, nil, nil, errors.Wrapf(err, "unable to connect to %s", addr)
 }
 
-func getClientGRPCConn() (*grpc.ClientConn, *hlc.Clock, *stop.Stopper, error) {
-	// 0 to disable max offset checks; this RPC context is not a member of the
-	// cluster, so there's no need to enforce that its max offset is the same
-	// as that of nodes in the cluster.
-	clock := hlc.NewClock(hlc.UnixNano, 0)
+// dialClientGRPCConn sets up a new RPC context and dials addr using the given
+// additional dial options.
+//
+// If useServerMaxOffset is set, the returned clock uses the max offset
+// configured for the server (--max-offset, or its default) instead of
+// disabling max offset checks, for clients whose use of the clock needs to
+// match the semantics of the cluster.
+func dialClientGRPCConn(
+	addr string, useServerMaxOffset bool, opts ...grpc.DialOption,
+) (*grpc.ClientConn, *hlc.Clock, *stop.Stopper, error) {
+	// By default, 0 to disable max offset checks; this RPC context is not a
+	// member of the cluster, so there's no need to enforce that its max offset
+	// is the same as that of nodes in the cluster.
+	var maxOffset time.Duration
+	if useServerMaxOffset {
+		maxOffset = time.Duration(serverCfg.MaxOffset)
+	}
+	clock := hlc.NewClock(hlc.UnixNano, maxOffset)
 	stopper := stop.NewStopper()
 	rpcContext := rpc.NewContext(
 		log.AmbientContext{Tracer: serverCfg.Settings.Tracer},
@@ -929,19 +3930,17 @@ func getClientGRPCConn() (*grpc.ClientConn, *hlc.Clock, *stop.Stopper, error) {
 		clock,
 		stopper,
 	)
-	addr, err := addrWithDefaultHost(serverCfg.AdvertiseAddr)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-	conn, err := rpcContext.GRPCDial(addr)
+	conn, err := rpcContext.GRPCDial(addr, opts...)
 	if err != nil {
+		// The RPC context caches dial errors, so a retry needs a fresh one.
+		stopper.Stop(context.Background())
 		return nil, nil, nil, err
 	}
 	return conn, clock, stopper, nil
 }
 
 func getAdminClient() (serverpb.AdminClient, *stop.Stopper, error) {
-	conn, _, stopper, err := getClientGRPCConn()
+	conn, _, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -990,62 +3989,289 @@ func checkNodeRunning(ctx context.Context, c serverpb.AdminClient) error {
 	return nil
 }
 
-// doShutdown attempts to trigger a server shutdown. When given an empty
-// onModes slice, it's a hard shutdown.
+// waitForNodeHealth polls the Health endpoint of the node behind c with
+// backoff until the node reports being healthy (i.e. live). An error is
+// returned if that doesn't happen within the given timeout.
+func waitForNodeHealth(ctx context.Context, c serverpb.AdminClient, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	opts := retry.Options{
+		InitialBackoff: 100 * time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     5 * time.Second,
+	}
+	var err error
+	for r := retry.StartWithCtx(ctx, opts); r.Next(); {
+		if _, err = c.Health(ctx, &serverpb.HealthRequest{}); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return errors.Wrapf(err, "node not healthy after %s", timeout)
+}
+
+// gracefulShutdownError returns the error, if any, that start returns after a
+// graceful shutdown triggered by sig. It determines the exit code.
+//
+// By default, a graceful shutdown after an interrupt terminates the process
+// with exit code 1; however SIGTERM is "legitimate" and is acknowledged with
+// a success exit code. When the signals are listed with --shutdown-on, each
+// of them yields 128+signal number, the exit code of a process killed by the
+// signal, so that orchestrators can tell which signal stopped the node.
+func gracefulShutdownError(sig os.Signal, configured bool) error {
+	if configured {
+		// On Unix, os.Signal is syscall.Signal and it's convertible to int.
+		if s, ok := sig.(syscall.Signal); ok {
+			return &cliError{
+				exitCode: 128 + int(s),
+				severity: log.Severity_INFO,
+				cause:    errors.Errorf("shut down gracefully on signal '%s'", sig),
+			}
+		}
+	}
+	if sig == os.Interrupt {
+		return &cliError{
+			exitCode: 1,
+			// INFO because a single interrupt is rather innocuous.
+			severity: log.Severity_INFO,
+			cause:    errors.New("interrupted"),
+		}
+	}
+	return nil
+}
+
+// selfHealthCheck returns a function that checks the health of the node
+// started by this process through its own RPC endpoint, so that a wedged RPC
+// stack is noticed too. Only the responsiveness of the node itself is
+// checked; see localHealthError. The connection is closed when stopper stops.
+func selfHealthCheck(stopper *stop.Stopper) (func(context.Context) error, error) {
+	addr, err := addrWithDefaultHost(serverCfg.AdvertiseAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, clientStopper, err := dialClientGRPCConn(addr, false /* useServerMaxOffset */)
+	if err != nil {
+		return nil, err
+	}
+	stopper.AddCloser(stop.CloserFn(func() { clientStopper.Stop(context.Background()) }))
+	c := serverpb.NewAdminClient(conn)
+	return func(ctx context.Context) error {
+		_, err := c.Health(ctx, &serverpb.HealthRequest{})
+		return localHealthError(err)
+	}, nil
+}
+
+// errNodeNotLiveDesc is the description of the error returned by the Health
+// endpoint of a node whose liveness record has expired.
+const errNodeNotLiveDesc = "node is not live"
+
+// localHealthError returns err, the outcome of a Health request, unless it
+// only reports that the node is not live. Liveness depends on the rest of the
+// cluster, e.g. on a quorum for the liveness range, and a node that can say
+// that it isn't live is responsive. Restarting it wouldn't help.
+func localHealthError(err error) error {
+	if grpc.Code(err) == codes.Unavailable && grpc.ErrorDesc(err) == errNodeNotLiveDesc {
+		return nil
+	}
+	return err
+}
+
+// drainOutcome aggregates what the server reported while draining.
+type drainOutcome struct {
+	// clientsCanceled is the number of SQL sessions that were forcibly
+	// closed.
+	clientsCanceled int
+	// jobsWaited lists the jobs that were waited on to checkpoint, and
+	// jobsTimedOut those among them that didn't in time.
+	jobsWaited, jobsTimedOut []int64
+}
+
+// doShutdown attempts to trigger a server shutdown using the drain modes and
+// options of req. When req has no drain modes, it's a hard shutdown. What
+// the server reported about the drain before going away, if anything, is
+// returned.
 //
 // errTryHardShutdown is returned if the caller should do a hard-shutdown.
-func doShutdown(ctx context.Context, c serverpb.AdminClient, onModes []int32) error {
+func doShutdown(
+	ctx context.Context, c serverpb.AdminClient, req serverpb.DrainRequest,
+) (outcome drainOutcome, _ error) {
 	// We want to distinguish between the case in which we can't even connect to
 	// the server (in which case we don't want our caller to try to come back with
 	// a hard retry) and the case in which an attempt to shut down fails (times
 	// out, or perhaps drops the connection while waiting). To that end, we first
-	// run a noop DrainRequest. If that fails, we give up.
-	if err := checkNodeRunning(ctx, c); err != nil {
-		return err
+	// run a noop DrainRequest. If that fails, we give up. If it doesn't complete
+	// within --drain-noop-check-timeout, the node is only half-responsive and
+	// errProbeTimedOut lets the caller decide what to do.
+	probeCtx := ctx
+	if quitCtx.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		probeCtx, cancel = context.WithTimeout(ctx, quitCtx.probeTimeout)
+		defer cancel()
 	}
-	// Send a drain request and continue reading until the connection drops (which
-	// then counts as a success, for the connection dropping is likely the result
-	// of the Stopper having reached the final stages of shutdown).
-	stream, err := c.Drain(ctx, &serverpb.DrainRequest{
-		On:       onModes,
-		Shutdown: true,
-	})
+	err := checkNodeRunning(probeCtx, c)
+	if probeCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return outcome, errProbeTimedOut{quitCtx.probeTimeout}
+	}
+	if err != nil {
+		return outcome, err
+	}
+	return drainAndShutdown(ctx, c, req)
+}
+
+// drainAndShutdown sends a drain request which also shuts down the node, and
+// continues reading until the connection drops (which then counts as a
+// success, for the connection dropping is likely the result of the Stopper
+// having reached the final stages of shutdown).
+func drainAndShutdown(
+	ctx context.Context, c serverpb.AdminClient, req serverpb.DrainRequest,
+) (outcome drainOutcome, _ error) {
+	req.Shutdown = true
+	stream, err := c.Drain(ctx, &req)
 	if err != nil {
 		//  This most likely means that we shut down successfully. Note that
 		//  sometimes the connection can be shut down even before a DrainResponse gets
 		//  sent back to us, so we don't require a response on the stream (see
 		//  #14184).
 		if grpcutil.IsClosedConnection(err) {
-			return nil
+			return outcome, nil
 		}
-		return errors.Wrap(err, "Error sending drain request")
+		return outcome, errors.Wrap(err, "Error sending drain request")
 	}
+	var responses int
+	var on []int32
 	for {
-		if _, err := stream.Recv(); err != nil {
+		resp, err := stream.Recv()
+		if err != nil {
 			if grpcutil.IsClosedConnection(err) {
-				return nil
+				return outcome, nil
 			}
 			// Unexpected error; the caller should try again (and harder).
-			return errTryHardShutdown{err}
+			return outcome, errTryHardShutdown{
+				error: err, responses: responses, requested: req.On, on: on,
+			}
+		}
+		responses++
+		on = resp.On
+		outcome.clientsCanceled += int(resp.ClientsCanceled)
+		outcome.jobsWaited = append(outcome.jobsWaited, resp.JobsWaited...)
+		outcome.jobsTimedOut = append(outcome.jobsTimedOut, resp.JobsTimedOut...)
+	}
+}
+
+// errTryHardShutdown is returned by drainAndShutdown when the drain stream
+// fails unexpectedly. It records how far the drain got, so that the operator
+// can tell whether the drain was making progress or the shutdown itself
+// failed.
+type errTryHardShutdown struct {
+	error
+	// responses is the number of drain responses received before the error.
+	responses int
+	// requested are the drain modes of the request, and on those the node
+	// reported as active in its last response.
+	requested, on []int32
+}
+
+func (e errTryHardShutdown) Error() string {
+	return fmt.Sprintf("%s (%s)", e.error, e.progress())
+}
+
+// progress describes how far the drain got before the error.
+func (e errTryHardShutdown) progress() string {
+	if e.responses == 0 {
+		return "the node reported no drain progress before the error"
+	}
+	responses := fmt.Sprintf("%d drain response", e.responses)
+	if e.responses != 1 {
+		responses += "s"
+	}
+	// The jobs drain mode is a one-off wait which the node never reports as
+	// active, so only the other modes can show progress.
+	var requested []int32
+	for _, m := range e.requested {
+		if serverpb.DrainMode(m) != serverpb.DrainMode_JOBS {
+			requested = append(requested, m)
+		}
+	}
+	if len(requested) == 0 {
+		return "after " + responses
+	}
+	var entered []string
+	for _, m := range requested {
+		for _, o := range e.on {
+			if m == o {
+				entered = append(entered, strings.ToLower(serverpb.DrainMode(m).String()))
+				break
+			}
 		}
 	}
+	desc := fmt.Sprintf("drain was %d%% complete after %s: %d of %d drain modes entered",
+		100*len(entered)/len(requested), responses, len(entered), len(requested))
+	if len(entered) > 0 {
+		desc += " (" + strings.Join(entered, ", ") + ")"
+	}
+	return desc
 }
 
-type errTryHardShutdown struct{ error }
+// errProbeTimedOut is returned by doShutdown when the node accepted the
+// connection but didn't respond to the no-op drain request in time.
+type errProbeTimedOut struct {
+	timeout time.Duration
+}
+
+func (e errProbeTimedOut) Error() string {
+	return fmt.Sprintf("the node accepted the connection but did not respond to the "+
+		"connectivity probe within %s (--%s)", e.timeout, cliflags.DrainNoopCheckTimeout.Name)
+}
 
 // runQuit accesses the quit shutdown path.
 func runQuit(cmd *cobra.Command, args []string) (err error) {
 	if len(args) != 0 {
 		return usageAndError(cmd)
 	}
+	applyQuitTimeoutEnv(cmd.Flags(), &quitCtx.drainWait)
+	jsonOutput := quitCtx.outputFormat == quitOutputJSON
+	if jsonOutput && quitCtx.serverDecommission {
+		return errors.Errorf("--%s=json cannot be combined with --%s",
+			cliflags.QuitOutputFormat.Name, cliflags.Decommission.Name)
+	}
+	// With JSON output, stdout is reserved for the final result.
+	out := io.Writer(os.Stdout)
+	if jsonOutput {
+		out = os.Stderr
+	}
+	var res quitResult
+	tBegin := timeutil.Now()
 	defer func() {
+		if jsonOutput {
+			res.ElapsedSeconds = timeutil.Since(tBegin).Seconds()
+			if err != nil {
+				res.Error = err.Error()
+			}
+			if jsonErr := json.NewEncoder(os.Stdout).Encode(res); err == nil {
+				err = jsonErr
+			}
+			return
+		}
 		if err == nil {
 			fmt.Println("ok")
 		}
 	}()
-	onModes := make([]int32, len(server.GracefulDrainModes))
-	for i, m := range server.GracefulDrainModes {
-		onModes[i] = int32(m)
+	drainModes := []serverpb.DrainMode(quitCtx.drainModes)
+	if len(drainModes) == 0 {
+		drainModes = server.GracefulDrainModes
+	}
+	drainReq := serverpb.DrainRequest{
+		On:              make([]int32, len(drainModes)),
+		ClientDrainWait: int64(quitCtx.maxClientDrain),
+		Strategy:        int32(quitCtx.drainStrategy),
+	}
+	for i, m := range drainModes {
+		drainReq.On[i] = int32(m)
+		if m == serverpb.DrainMode_JOBS {
+			drainReq.JobsDrainWait = int64(quitCtx.drainWait)
+		}
 	}
 
 	c, stopper, err := getAdminClient()
@@ -1055,30 +4281,486 @@ func runQuit(cmd *cobra.Command, args []string) (err error) {
 	ctx := stopperContext(stopper)
 	defer stopper.Stop(ctx)
 
+	if !quitCtx.yes && isatty.IsTerminal(os.Stdin.Fd()) {
+		ok, err := confirmQuit(ctx, os.Stdin, out)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("shutdown aborted")
+		}
+	}
+
+	var verifyInfo quitVerifyInfo
+	if quitCtx.verify {
+		// The node can't be asked about its own status once it's gone, so
+		// collect everything needed to ask its peers up front.
+		if verifyInfo, err = prepareQuitVerify(ctx); err != nil {
+			return err
+		}
+	}
+
 	if quitCtx.serverDecommission {
-		var myself []string // will remain empty, which means target yourself
-		if err := runDecommissionNodeImpl(ctx, c, nodeDecommissionWaitAll, myself); err != nil {
+		if err := decommissionBeforeQuit(ctx, c); err != nil {
+			return err
+		}
+	}
+	if quitCtx.transferLeasesFirst {
+		if err := transferLeasesBeforeQuit(ctx, c, out); err != nil {
+			return err
+		}
+	}
+	var outcome drainOutcome
+	res.HardShutdown, outcome, err = shutdownNode(ctx, c, drainReq, out)
+	if err != nil {
+		return err
+	}
+	res.ClientsCanceled = outcome.clientsCanceled
+	res.JobsWaited, res.JobsTimedOut = outcome.jobsWaited, outcome.jobsTimedOut
+	res.Drained = !res.HardShutdown
+	if quitCtx.wait {
+		addr, err := addrWithDefaultHost(serverCfg.AdvertiseAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "waiting for the node to stop accepting connections on %s\n", addr)
+		if err := waitForListenerRelease(addr, quitCtx.drainWait); err != nil {
 			return err
 		}
+		res.Exited = true
+	}
+	if quitCtx.verify {
+		return verifyNodeLeft(verifyInfo, out, !jsonOutput)
+	}
+	return nil
+}
+
+// decommissionBeforeQuit decommissions the node that is about to be shut
+// down, waiting at most --decommission-wait for its data to move away. On
+// timeout, the ranges remaining on the node are reported, and the shutdown
+// either proceeds or, with --decommission-strict, is aborted.
+func decommissionBeforeQuit(ctx context.Context, c serverpb.AdminClient) error {
+	var myself []string // will remain empty, which means target yourself
+	if quitCtx.decommissionWait <= 0 {
+		return runDecommissionNodeImpl(ctx, c, nodeDecommissionWaitAll, myself)
+	}
+
+	decommissionCtx, cancel := context.WithTimeout(ctx, quitCtx.decommissionWait)
+	defer cancel()
+	err := runDecommissionNodeImpl(decommissionCtx, c, nodeDecommissionWaitAll, myself)
+	if err == nil || decommissionCtx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "\ndecommissioning did not complete within %s\n", quitCtx.decommissionWait)
+	if remaining, err := describeLocalRanges(ctx); err != nil {
+		fmt.Fprintf(stderr, "unable to list the ranges remaining on the node: %s\n", err)
+	} else {
+		fmt.Fprintf(stderr, "ranges remaining on the node: %s\n", remaining)
+	}
+	if quitCtx.decommissionStrict {
+		return errors.Errorf("decommissioning did not complete within %s; not shutting down",
+			quitCtx.decommissionWait)
+	}
+	fmt.Fprintln(stderr, "proceeding with shutdown")
+	return nil
+}
+
+// describeLocalRanges lists the ranges that have a replica on the node the
+// command is connected to, flagging the under-replicated ones.
+func describeLocalRanges(ctx context.Context) (string, error) {
+	c, stopper, err := getStatusClient()
+	if err != nil {
+		return "", err
+	}
+	defer stopper.Stop(ctx)
+
+	resp, err := c.Ranges(ctx, &serverpb.RangesRequest{NodeId: "local"})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Ranges) == 0 {
+		return "none", nil
+	}
+	descs := make([]string, len(resp.Ranges))
+	for i, r := range resp.Ranges {
+		descs[i] = fmt.Sprintf("r%d", r.State.Desc.RangeID)
+		if r.Problems.Underreplicated {
+			descs[i] += " (under-replicated)"
+		}
+	}
+	return strings.Join(descs, ", "), nil
+}
+
+// quitLeaseTransferWait is the maximum amount of time that quit
+// --transfer-leases-first waits for the node to shed its leases before
+// proceeding with the shutdown.
+const quitLeaseTransferWait = time.Minute
+
+// quitUndrainTimeout bounds the request that takes the node back out of the
+// leases drain mode when quit --transfer-leases-first gives up.
+const quitUndrainTimeout = 10 * time.Second
+
+// transferLeasesBeforeQuit asks the node to move its range leases away, by
+// activating only the leases drain mode, and reports the number of leases it
+// still holds until they're gone or quitLeaseTransferWait has elapsed. The
+// node keeps serving clients in the meantime.
+func transferLeasesBeforeQuit(ctx context.Context, c serverpb.AdminClient, out io.Writer) error {
+	sc, stopper, err := getStatusClient()
+	if err != nil {
+		return err
 	}
+	defer stopper.Stop(ctx)
+	return transferLeases(ctx, c, sc, out)
+}
+
+// transferLeases implements transferLeasesBeforeQuit. If it fails, quit
+// won't shut the node down, so the node is taken back out of the leases
+// drain mode rather than left shedding its leases until it is restarted.
+func transferLeases(
+	ctx context.Context, c serverpb.AdminClient, sc serverpb.StatusClient, out io.Writer,
+) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			undrainLeases(c, out)
+		}
+	}()
+
+	drainCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- doShutdown(ctx, c, onModes)
+		_, err := getDrainModesWithRequest(drainCtx, c, &serverpb.DrainRequest{
+			On:       []int32{int32(serverpb.DrainMode_LEASES)},
+			Strategy: int32(quitCtx.drainStrategy),
+		})
+		errChan <- err
 	}()
-	select {
-	case err := <-errChan:
+
+	fmt.Fprintln(out, "transferring leases away from the node")
+	deadline := time.After(quitLeaseTransferWait)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	prevCount := -1
+	for {
+		resp, err := sc.Ranges(ctx, &serverpb.RangesRequest{NodeId: "local"})
 		if err != nil {
-			if _, ok := err.(errTryHardShutdown); ok {
-				fmt.Printf("graceful shutdown failed: %s; proceeding with hard shutdown\n", err)
-				break
+			return errors.Wrap(err, "unable to count the leases held by the node")
+		}
+		count := countLocalLeases(resp.Ranges)
+		if count != prevCount {
+			fmt.Fprintf(out, "leases held by the node: %d\n", count)
+			prevCount = count
+		}
+		if count == 0 {
+			return nil
+		}
+		select {
+		case err := <-errChan:
+			if err != nil {
+				return errors.Wrap(err, "unable to transfer leases")
 			}
-			return err
+			// The node has made its attempt at transferring every lease; keep
+			// polling until the count settles or the deadline expires.
+			errChan = nil
+		case <-deadline:
+			fmt.Fprintf(out, "node still holds %d leases after %s; proceeding with shutdown\n",
+				count, quitLeaseTransferWait)
+			return nil
+		case <-ticker.C:
 		}
-		return nil
-	case <-time.After(time.Minute):
-		fmt.Println("timed out; proceeding with hard shutdown")
+	}
+}
+
+// undrainLeases takes the node behind c out of the leases drain mode. It
+// doesn't use the quit context, which may be the reason for giving up.
+// Failures are only reported to out, so as not to mask the original error.
+func undrainLeases(c serverpb.AdminClient, out io.Writer) {
+	ctx, cancel := context.WithTimeout(context.Background(), quitUndrainTimeout)
+	defer cancel()
+	if _, err := getDrainModesWithRequest(ctx, c, &serverpb.DrainRequest{
+		Off: []int32{int32(serverpb.DrainMode_LEASES)},
+	}); err != nil {
+		fmt.Fprintf(out, "unable to take the node out of the leases drain mode: %v\n", err)
+		return
+	}
+	fmt.Fprintln(out, "the node was taken back out of the leases drain mode")
+}
+
+// countLocalLeases returns the number of ranges whose lease is held by the
+// node that reported them.
+func countLocalLeases(ranges []serverpb.RangeInfo) int {
+	var count int
+	for _, r := range ranges {
+		if l := r.State.Lease; l != nil && l.Replica.NodeID == r.SourceNodeID {
+			count++
+		}
+	}
+	return count
+}
+
+// quitResult is the outcome of the quit command as reported by
+// --format=json.
+type quitResult struct {
+	// Drained is true if the node shut down after a successful graceful
+	// drain.
+	Drained bool `json:"drained"`
+	// HardShutdown is true if the graceful drain failed or timed out and the
+	// node was shut down without it.
+	HardShutdown bool `json:"hard_shutdown"`
+	// Exited is true if quit --wait observed that the node released its
+	// listening port.
+	Exited bool `json:"exited,omitempty"`
+	// ClientsCanceled is the number of SQL sessions that were forcibly
+	// closed because they didn't finish in time during the drain.
+	ClientsCanceled int `json:"clients_canceled,omitempty"`
+	// JobsWaited lists the IDs of the jobs the node waited on to checkpoint
+	// with the jobs drain mode, and JobsTimedOut those among them that
+	// didn't checkpoint in time.
+	JobsWaited     []int64 `json:"jobs_waited,omitempty"`
+	JobsTimedOut   []int64 `json:"jobs_timed_out,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// shutdownNode attempts a graceful shutdown of the node using the drain modes
+// and options of req, falling back to a hard shutdown if that fails or takes
+// too long. Progress messages are written to out. The returned boolean
+// indicates whether the hard shutdown was needed.
+func shutdownNode(
+	ctx context.Context, c serverpb.AdminClient, req serverpb.DrainRequest, out io.Writer,
+) (hardShutdown bool, outcome drainOutcome, _ error) {
+	type shutdownResult struct {
+		outcome drainOutcome
+		err     error
+	}
+	resChan := make(chan shutdownResult, 1)
+	go func() {
+		outcome, err := doShutdown(ctx, c, req)
+		resChan <- shutdownResult{outcome, err}
+	}()
+	timeout := drainTimeout(req)
+	select {
+	case res := <-resChan:
+		reportDrainOutcome(out, res.outcome)
+		if err := res.err; err != nil {
+			switch err.(type) {
+			case errTryHardShutdown:
+				fmt.Fprintf(out, "graceful shutdown failed: %s; proceeding with hard shutdown\n", err)
+				outcome = res.outcome
+			case errProbeTimedOut:
+				// Probing again would only time out again; ask the node to
+				// shut down right away.
+				fmt.Fprintf(out, "probe timed out: %s; proceeding with hard shutdown\n", err)
+				_, err := drainAndShutdown(ctx, c, serverpb.DrainRequest{})
+				return true, outcome, errors.Wrap(err, "hard shutdown failed")
+			default:
+				return false, res.outcome, err
+			}
+			break
+		}
+		return false, res.outcome, nil
+	case <-time.After(timeout):
+		fmt.Fprintf(out, "drain timed out after %s; proceeding with hard shutdown\n", timeout)
 	}
 	// Not passing drain modes tells the server to not bother and go
 	// straight to shutdown.
-	return errors.Wrap(doShutdown(ctx, c, nil), "hard shutdown failed")
+	_, err := doShutdown(ctx, c, serverpb.DrainRequest{})
+	return true, outcome, errors.Wrap(err, "hard shutdown failed")
+}
+
+// drainTimeout returns the time quit waits for the drain requested by req
+// before proceeding with a hard shutdown: --drain-wait (or
+// COCKROACH_QUIT_TIMEOUT), plus the time the server was asked to wait for
+// clients. The wait for jobs is bounded by --drain-wait itself, so it isn't
+// added.
+func drainTimeout(req serverpb.DrainRequest) time.Duration {
+	return quitCtx.drainWait + time.Duration(req.ClientDrainWait)
+}
+
+// reportDrainOutcome tells the user about SQL sessions that the server
+// closed because they didn't finish draining in time, and about the jobs it
+// waited on.
+func reportDrainOutcome(out io.Writer, outcome drainOutcome) {
+	if n := outcome.clientsCanceled; n > 0 {
+		fmt.Fprintf(out, "force-closed %d client connection%s\n", n, util.Pluralize(int64(n)))
+	}
+	if n := len(outcome.jobsWaited); n > 0 {
+		fmt.Fprintf(out, "waited for %d job%s to checkpoint: %s\n",
+			n, util.Pluralize(int64(n)), formatJobIDs(outcome.jobsWaited))
+	}
+	if n := len(outcome.jobsTimedOut); n > 0 {
+		fmt.Fprintf(out, "%d job%s did not checkpoint in time: %s\n",
+			n, util.Pluralize(int64(n)), formatJobIDs(outcome.jobsTimedOut))
+	}
+}
+
+// formatJobIDs formats job IDs as a comma-separated list.
+func formatJobIDs(ids []int64) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// waitForListenerRelease polls addr until connections to it are refused,
+// which indicates that the process that was listening on it has exited (or at
+// least closed its listener), for at most timeout.
+func waitForListenerRelease(addr string, timeout time.Duration) error {
+	return retry.ForDuration(timeout, func() error {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return nil
+		}
+		_ = conn.Close()
+		return errors.Errorf("node still accepting connections on %s after %s", addr, timeout)
+	})
+}
+
+// quitVerifyTimeout is the maximum amount of time that quit --verify waits
+// for the rest of the cluster to notice that the node is gone. It needs to
+// comfortably exceed the node liveness expiration.
+const quitVerifyTimeout = time.Minute
+
+// quitVerifyPeerHealthTimeout is the maximum amount of time that quit
+// --verify waits for a peer to be healthy before asking it about the status
+// of the node that was shut down. An unhealthy peer's view of the cluster
+// can't be trusted.
+const quitVerifyPeerHealthTimeout = 5 * time.Second
+
+// quitVerifyInfo records what quit --verify needs to confirm that the cluster
+// has noticed the node shut down.
+type quitVerifyInfo struct {
+	nodeID    roachpb.NodeID
+	peerAddrs []string
+}
+
+// confirmQuit asks the operator to confirm the shutdown of the node the client
+// is connected to, identified by its node ID and advertised address.
+func confirmQuit(ctx context.Context, in io.Reader, out io.Writer) (bool, error) {
+	c, stopper, err := getStatusClient()
+	if err != nil {
+		return false, err
+	}
+	defer stopper.Stop(ctx)
+
+	details, err := c.Details(ctx, &serverpb.DetailsRequest{NodeId: "local"})
+	if err != nil {
+		return false, errors.Wrap(err, "unable to determine the node to shut down")
+	}
+	return promptYesNo(in, out, fmt.Sprintf("About to drain and shut down node %d at %s. Continue?",
+		details.NodeID, details.Address.AddressField))
+}
+
+// promptYesNo writes question to out and reads the answer from in. Anything
+// other than "y" or "yes" counts as a no.
+func promptYesNo(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// prepareQuitVerify determines the ID of the node being shut down and the
+// addresses of the other nodes in its cluster.
+func prepareQuitVerify(ctx context.Context) (quitVerifyInfo, error) {
+	c, stopper, err := getStatusClient()
+	if err != nil {
+		return quitVerifyInfo{}, err
+	}
+	defer stopper.Stop(ctx)
+
+	details, err := c.Details(ctx, &serverpb.DetailsRequest{NodeId: "local"})
+	if err != nil {
+		return quitVerifyInfo{}, errors.Wrap(err, "unable to determine the node ID")
+	}
+	nodes, err := c.Nodes(ctx, &serverpb.NodesRequest{})
+	if err != nil {
+		return quitVerifyInfo{}, errors.Wrap(err, "unable to list the nodes in the cluster")
+	}
+	info := quitVerifyInfo{nodeID: details.NodeID}
+	for _, nodeStatus := range nodes.Nodes {
+		if nodeStatus.Desc.NodeID != info.nodeID {
+			info.peerAddrs = append(info.peerAddrs, nodeStatus.Desc.Address.AddressField)
+		}
+	}
+	if len(info.peerAddrs) == 0 {
+		return quitVerifyInfo{}, errors.Errorf(
+			"cannot verify shutdown of node %d: no other nodes in the cluster", info.nodeID)
+	}
+	return info, nil
+}
+
+// verifyNodeLeft polls the peers of a node that was shut down until one of
+// them reports that the node is no longer live. Progress messages are written
+// to out and, if printStatus is set, the node's final status is printed.
+func verifyNodeLeft(info quitVerifyInfo, out io.Writer, printStatus bool) error {
+	fmt.Fprintf(out, "waiting for the cluster to report node %d as down\n", info.nodeID)
+	var resp *serverpb.DecommissionStatusResponse
+	if err := retry.ForDuration(quitVerifyTimeout, func() error {
+		var err error
+		resp, err = getDecommissionStatusFromPeers(info.peerAddrs, info.nodeID)
+		if err != nil {
+			return err
+		}
+		return checkNodeLeft(resp, info.nodeID)
+	}); err != nil {
+		return errors.Wrap(err, "unable to verify node shutdown")
+	}
+	if !printStatus {
+		return nil
+	}
+	return printDecommissionStatus(*resp)
+}
+
+// checkNodeLeft returns an error unless resp reports nodeID as not live. A
+// response that doesn't mention the node doesn't show that it left.
+func checkNodeLeft(resp *serverpb.DecommissionStatusResponse, nodeID roachpb.NodeID) error {
+	for _, status := range resp.Status {
+		if status.NodeID != nodeID {
+			continue
+		}
+		if status.IsLive {
+			return errors.Errorf("node %d is still reported as live", nodeID)
+		}
+		return nil
+	}
+	return errors.Errorf("node %d is not reported by its peer", nodeID)
+}
+
+// getDecommissionStatusFromPeers returns the decommissioning status of the
+// given node as reported by the first reachable address in peerAddrs.
+func getDecommissionStatusFromPeers(
+	peerAddrs []string, nodeID roachpb.NodeID,
+) (*serverpb.DecommissionStatusResponse, error) {
+	var lastErr error
+	for _, addr := range peerAddrs {
+		resp, err := func() (*serverpb.DecommissionStatusResponse, error) {
+			conn, _, stopper, err := getClientGRPCConnForAddr(addr, false /* useServerMaxOffset */)
+			if err != nil {
+				return nil, err
+			}
+			ctx := stopperContext(stopper)
+			defer stopper.Stop(ctx)
+			c := serverpb.NewAdminClient(conn)
+			if err := waitForNodeHealth(ctx, c, quitVerifyPeerHealthTimeout); err != nil {
+				return nil, err
+			}
+			return c.DecommissionStatus(ctx,
+				&serverpb.DecommissionStatusRequest{NodeIDs: []roachpb.NodeID{nodeID}})
+		}()
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
 }
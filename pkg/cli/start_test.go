@@ -15,17 +15,42 @@
 package cli
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"runtime/pprof"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server"
+	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/elastic/gosigar"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 func TestInitInsecure(t *testing.T) {
@@ -162,4 +187,2201 @@ func TestGCProfiles(t *testing.T) {
 		}
 		sum -= len(data[:i])
 	}
+
+	// The most recent profiles are kept even when they exceed the size limit.
+	defer func(n int) { minProfilesToKeep = n }(minProfilesToKeep)
+	minProfilesToKeep = 3
+	expected = expected[len(expected)-1:]
+	for i := 1; i <= 4; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%s%04d", prefix, len(data)+i))
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, p)
+	}
+	gcProfiles(dir, prefix, 0)
+	paths, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	if e := expected[len(expected)-3:]; !reflect.DeepEqual(e, paths) {
+		t.Fatalf("expected\n%s\nfound\n%s\n", strings.Join(e, "\n"), strings.Join(paths, "\n"))
+	}
+}
+
+func TestParseProfileName(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ts := time.Date(2017, 11, 2, 15, 4, 5, 123000000, time.UTC)
+	for _, prefix := range []string{jeprof, memprof, cpuprof} {
+		name := prefix + ts.Format(profileTimeFormat)
+		p, parsed, err := parseProfileName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != prefix || !parsed.Equal(ts) {
+			t.Errorf("%s: expected (%s, %s), but found (%s, %s)", name, prefix, ts, p, parsed)
+		}
+	}
+
+	defer atomic.StoreInt32(&profileNodeID, 0)
+	atomic.StoreInt32(&profileNodeID, 7)
+	name := memprof + profileSuffix(ts)
+	if e := "memprof.n7.2017-11-02T15_04_05.123"; name != e {
+		t.Errorf("expected %s, but found %s", e, name)
+	}
+	if p, parsed, err := parseProfileName(name); err != nil {
+		t.Fatal(err)
+	} else if p != memprof || !parsed.Equal(ts) {
+		t.Errorf("%s: expected (%s, %s), but found (%s, %s)", name, memprof, ts, p, parsed)
+	}
+
+	for _, name := range []string{
+		"memprof", "memprof.", "memprof.0001", "backtrace.out", "memprof.n7", "memprof.nX.0001",
+	} {
+		if _, _, err := parseProfileName(name); !testutils.IsError(err, "is not a profile file name") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+// TestGCProfilesNodeID verifies that profiles named with and without the node
+// ID are ordered by timestamp.
+func TestGCProfilesNodeID(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestGCProfilesNodeID.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(n int) { minProfilesToKeep = n }(minProfilesToKeep)
+	minProfilesToKeep = 2
+
+	ts := time.Date(2017, 11, 2, 15, 4, 5, 0, time.UTC)
+	names := []string{
+		memprof + "n7." + ts.Format(profileTimeFormat),
+		memprof + ts.Add(time.Minute).Format(profileTimeFormat),
+		memprof + "n7." + ts.Add(2*time.Minute).Format(profileTimeFormat),
+		memprof + ts.Add(3*time.Minute).Format(profileTimeFormat),
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("profile"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gcProfiles(dir, memprof, 0)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, f := range files {
+		found = append(found, f.Name())
+	}
+	if e := []string{names[3], names[2]}; !reflect.DeepEqual(e, found) {
+		t.Errorf("expected %s, but found %s", e, found)
+	}
+}
+
+func TestProfileNamePrefix(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for prefix, expected := range map[string]string{
+		"":       "",
+		"nodeA-": "nodeA-",
+		"node.A": "",
+		"a/b-":   "",
+	} {
+		if actual := validProfileNamePrefix(prefix); actual != expected {
+			t.Errorf("%q: expected %q, but found %q", prefix, expected, actual)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "TestProfileNamePrefix.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(n int) { minProfilesToKeep = n }(minProfilesToKeep)
+	minProfilesToKeep = 1
+	defer func(prefix string) { profileNamePrefix = prefix }(profileNamePrefix)
+	profileNamePrefix = "nodeA-"
+
+	// Only the profiles with the prefix of this process are collected.
+	ts := time.Date(2017, 11, 2, 15, 4, 5, 0, time.UTC)
+	names := []string{
+		memprof + ts.Format(profileTimeFormat),
+		memprof + ts.Add(time.Minute).Format(profileTimeFormat),
+		profilePrefix(memprof) + ts.Format(profileTimeFormat),
+		profilePrefix(memprof) + ts.Add(time.Minute).Format(profileTimeFormat),
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("profile"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gcProfiles(dir, profilePrefix(memprof), 0)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, f := range files {
+		found = append(found, f.Name())
+	}
+	if e := []string{names[0], names[1], names[3]}; !reflect.DeepEqual(e, found) {
+		t.Errorf("expected %s, but found %s", e, found)
+	}
+}
+
+func TestClampCPUProfileHz(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		hz, expected int
+		clamped      bool
+	}{
+		{-5, 1, true},
+		{0, 1, true},
+		{1, 1, false},
+		{500, 500, false},
+		{maxCPUProfileHz, maxCPUProfileHz, false},
+		{maxCPUProfileHz + 1, maxCPUProfileHz, true},
+	}
+	for _, tc := range testCases {
+		if hz, clamped := clampCPUProfileHz(tc.hz); hz != tc.expected || clamped != tc.clamped {
+			t.Errorf("%d: expected (%d, %t), but found (%d, %t)", tc.hz, tc.expected, tc.clamped, hz, clamped)
+		}
+	}
+}
+
+func TestProfileFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestProfileFile.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	path := filepath.Join(dir, memprof+"0001")
+	f, err := createProfileFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	// The incomplete profile must not be visible under its final name, nor
+	// be picked up by gcProfiles.
+	if paths, err := filepath.Glob(filepath.Join(dir, memprof+"*")); err != nil {
+		t.Fatal(err)
+	} else if len(paths) != 0 {
+		t.Fatalf("unexpected profiles before finish: %s", paths)
+	}
+	if err := f.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "hello world" {
+		t.Fatalf("unexpected profile contents %q", b)
+	}
+
+	f, err = createProfileFile(filepath.Join(dir, memprof+"0002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.abort()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the finished profile, found %d files", len(files))
+	}
+}
+
+func TestMaybeUploadProfile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestMaybeUploadProfile.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(uri string, u ProfileUploader) {
+		profileUploadURI, profileUploader = uri, u
+	}(profileUploadURI, profileUploader)
+	profileUploadURI = "nodelocal:///profiles"
+	type upload struct {
+		uri, name, content string
+	}
+	uploads := make(chan upload, 1)
+	profileUploader = func(
+		_ context.Context, _ *cluster.Settings, uri, name string, content io.ReadSeeker,
+	) error {
+		b, err := ioutil.ReadAll(content)
+		uploads <- upload{uri, name, string(b)}
+		return err
+	}
+
+	path := filepath.Join(dir, memprof+"0001")
+	if err := ioutil.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	maybeUploadProfile(context.Background(), path)
+	// The upload must not be affected by the local profile being removed.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if u, e := <-uploads, (upload{profileUploadURI, memprof + "0001", "hello world"}); u != e {
+		t.Errorf("expected %+v, but found %+v", e, u)
+	}
+}
+
+func TestWriteMemProfilesSource(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	prevDump := jemallocHeapDump
+	defer func() { jemallocHeapDump = prevDump }()
+	jemallocHeapDump = func(path string) error {
+		return ioutil.WriteFile(path, []byte("jemalloc"), 0644)
+	}
+
+	testCases := []struct {
+		source   string
+		expected []string
+	}{
+		{"both", []string{jeprof + "1", memprof + "1"}},
+		{"go", []string{memprof + "1"}},
+		{"jemalloc", []string{jeprof + "1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.source, func(t *testing.T) {
+			source, err := parseMemProfileSource(tc.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir, err := ioutil.TempDir("", "TestWriteMemProfilesSource.")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(dir)
+			}()
+
+			if err := writeMemProfiles(context.Background(), dir, "1", source); err != nil {
+				t.Fatal(err)
+			}
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, f := range files {
+				actual = append(actual, f.Name())
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, but found %v", tc.expected, actual)
+			}
+		})
+	}
+
+	if _, err := parseMemProfileSource("tcmalloc"); !testutils.IsError(err, "invalid memory profile source") {
+		t.Errorf("expected an invalid source error, got %v", err)
+	}
+}
+
+func TestToggleProfilers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	paused, changed := profilersPaused()
+	if paused {
+		t.Fatal("expected the profilers to run initially")
+	}
+	if !toggleProfilers() {
+		t.Fatal("expected the profilers to be paused")
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected the pause to be notified")
+	}
+	paused, changed = profilersPaused()
+	if !paused {
+		t.Fatal("expected the profilers to be paused")
+	}
+	if toggleProfilers() {
+		t.Fatal("expected the profilers to be resumed")
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected the resumption to be notified")
+	}
+}
+
+func TestWritePanicProfiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestWritePanicProfiles.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	writePanicProfiles(context.Background(), dir)
+	for _, suffix := range []string{".goroutine", ".heap"} {
+		paths, err := filepath.Glob(filepath.Join(dir, panicprof+"*"+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 {
+			t.Fatalf("expected a single %s profile, found %v", suffix, paths)
+		}
+	}
+	// Only the first panic writes profiles.
+	writePanicProfiles(context.Background(), dir)
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 2 {
+		t.Fatalf("expected 2 files, found %d", len(files))
+	}
+}
+
+func TestGCPanicProfiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestGCPanicProfiles.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	files := []string{
+		panicprof + "2006-01-02T15_04_05.1.goroutine",
+		panicprof + "2006-01-02T15_04_05.1.heap",
+		panicprof + "n3.2006-01-02T15_04_05.2.goroutine",
+		panicprof + "n3.2006-01-02T15_04_05.2.goroutine" + profileChecksumSuffix,
+		panicprof + "2006-01-02T15_04_05.3.heap",
+		memprof + "2006-01-02T15_04_05.0",
+	}
+	for _, name := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(expected []string) {
+		t.Helper()
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, info := range infos {
+			actual = append(actual, info.Name())
+		}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected %v, but found %v", expected, actual)
+		}
+	}
+
+	gcPanicProfiles(dir, 0)
+	check(append([]string(nil), files...))
+	gcPanicProfiles(dir, 3)
+	check(append([]string(nil), files...))
+	gcPanicProfiles(dir, 2)
+	check(append([]string(nil), files[2:]...))
+	gcPanicProfiles(dir, 1)
+	check([]string{files[4], files[5]})
+}
+
+func TestProfileChecksum(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestProfileChecksum.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(prev bool) { profileChecksum = prev }(profileChecksum)
+	profileChecksum = true
+
+	// Write three profiles of 11 bytes, each with a checksum file.
+	data := []byte("hello world")
+	var paths []string
+	for i := 1; i <= 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%s%04d", memprof, i))
+		f, err := createProfileFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.finish(); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	b, err := ioutil.ReadFile(paths[0] + profileChecksumSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%x  %s\n", sha256.Sum256(data), filepath.Base(paths[0]))
+	if string(b) != expected {
+		t.Fatalf("expected checksum file %q, found %q", expected, b)
+	}
+	checksumSize := int64(len(b))
+
+	// The budget fits two profiles, but not their checksum files as well, so
+	// the two oldest profiles are removed along with their checksum files.
+	gcProfiles(dir, memprof, 2*int64(len(data))+checksumSize)
+	found, err := filepath.Glob(filepath.Join(dir, memprof+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	if e := []string{paths[2], paths[2] + profileChecksumSuffix}; !reflect.DeepEqual(e, found) {
+		t.Fatalf("expected %s, found %s", e, found)
+	}
+}
+
+func TestProfileFileThrottledWrite(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestProfileFileThrottledWrite.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if makeProfileWriteLimiter(0) != nil {
+		t.Fatal("expected no limiter for a rate of 0")
+	}
+	if burst := makeProfileWriteLimiter(1000).Burst(); burst != 1000 {
+		t.Fatalf("expected a burst of 1000, got %d", burst)
+	}
+
+	defer func(prev *rate.Limiter) { profileWriteLimiter = prev }(profileWriteLimiter)
+	profileWriteLimiter = makeProfileWriteLimiter(1 << 30)
+
+	data := bytes.Repeat([]byte("0123456789"), 3*profileWriteChunkSize/10+1)
+	path := filepath.Join(dir, "profile")
+	f, err := createProfileFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write(data); err != nil || n != len(data) {
+		t.Fatalf("expected to write %d bytes, wrote %d: %v", len(data), n, err)
+	}
+	if err := f.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(written, data) {
+		t.Fatalf("expected %d bytes to be written as-is, found %d", len(data), len(written))
+	}
+}
+
+func TestVerifyProfiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestVerifyProfiles.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	var buf bytes.Buffer
+	if err := pprof.WriteHeapProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// A jemalloc profile, as written by prof.dump, abridged.
+	jemallocProfile := []byte(`heap_v2/524288
+  t*: 28106: 56637512 [0: 0]
+  t0: 28106: 56637512 [0: 0]
+@ 0x7f6b8d3b3b0c 0x7f6b8d3a2a38
+  t*: 1: 4096 [0: 0]
+
+MAPPED_LIBRARIES:
+00400000-01d4c000 r-xp 00000000 08:01 3670024 /usr/local/bin/cockroach
+`)
+	suffix := timeutil.Now().Format(profileTimeFormat)
+	truncatedSuffix := timeutil.Now().Add(time.Second).Format(profileTimeFormat)
+	for name, data := range map[string][]byte{
+		memprof + suffix:                buf.Bytes(),
+		cpuprof + suffix:                buf.Bytes()[:buf.Len()/2],
+		jeprof + suffix:                 jemallocProfile,
+		jeprof + truncatedSuffix:        jemallocProfile[:len(jemallocProfile)/2],
+		"cockroach.log":                 []byte("not a profile"),
+		"." + memprof + suffix + ".tmp": nil,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	checked, corrupt, err := verifyProfiles(dir, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 || corrupt != 2 {
+		t.Fatalf("expected 2 of 4 profiles to be corrupt, found %d of %d", corrupt, checked)
+	}
+	// The profiles are reported in the order of their names.
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], cpuprof+suffix+": ") ||
+		lines[1] != jeprof+truncatedSuffix+": truncated jemalloc profile: missing the mapped libraries" {
+		t.Errorf("expected the truncated cpu and jemalloc profiles to be reported, got %q", out.String())
+	}
+}
+
+func TestFormatOutputDirBudget(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		logBudget, profileBudget int64
+		avail                    uint64
+		used                     int64
+		expected                 string
+		exceeded                 bool
+	}{
+		{100 << 20, 300 << 20, 1 << 30, 0,
+			"log files (--log-dir-max-size=100 MiB) and profiles (300 MiB) may use up to 400 MiB, with 1.0 GiB available",
+			false},
+		{100 << 20, 300 << 20, 200 << 20, 0,
+			"log files (--log-dir-max-size=100 MiB) and profiles (300 MiB) may use up to 400 MiB, with 200 MiB available",
+			true},
+		// Files already in the directory will be garbage collected to make room.
+		{100 << 20, 300 << 20, 200 << 20, 300 << 20,
+			"log files (--log-dir-max-size=100 MiB) and profiles (300 MiB) may use up to 400 MiB, with 500 MiB available",
+			false},
+	}
+	for i, tc := range testCases {
+		desc, exceeded := formatOutputDirBudget(tc.logBudget, tc.profileBudget, tc.avail, tc.used)
+		if desc != tc.expected || exceeded != tc.exceeded {
+			t.Errorf("%d: expected %q, %t, got %q, %t", i, tc.expected, tc.exceeded, desc, exceeded)
+		}
+	}
+}
+
+func TestStartupCPUProfiler(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestStartupCPUProfiler.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	ctx := context.Background()
+	p, err := startStartupCPUProfile(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is written before the profile is stopped.
+	p.setDir(ctx, dir)
+	paths, err := filepath.Glob(filepath.Join(dir, startupcpuprof+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 0 {
+		t.Fatalf("expected no profile yet, found %v", paths)
+	}
+
+	p.stop()
+	p.stop()
+	paths, err = filepath.Glob(filepath.Join(dir, startupcpuprof+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected a single startup profile, found %v", paths)
+	}
+	if err := verifyProfile(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A nil profiler, i.e. without --cpu-profile-on-start, is a no-op.
+	var nilProfiler *startupCPUProfiler
+	nilProfiler.setDir(ctx, dir)
+	nilProfiler.stop()
+}
+
+func TestProfileFailureTracker(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tracker := profileFailureTracker{name: "test"}
+	for i := 1; i <= profileWriteFailureThreshold; i++ {
+		if tracker.paused() {
+			t.Fatalf("%d: unexpectedly paused", i)
+		}
+		tracker.failed(ctx, errors.New("disk full"))
+	}
+	if !tracker.paused() {
+		t.Fatal("expected the tracker to be paused")
+	}
+	tracker.succeeded(ctx)
+	if tracker.paused() || tracker.failures != 0 {
+		t.Fatalf("expected the tracker to be reset, found %+v", tracker)
+	}
+}
+
+func TestRecordStoreIdentity(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestRecordStoreIdentity.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	specs := []base.StoreSpec{{Path: dir}, {InMemory: true}}
+	for _, nodeID := range []roachpb.NodeID{1, 2} {
+		clusterID := uuid.MakeV4()
+		recordStoreIdentity(context.Background(), specs, nodeID, clusterID)
+
+		for filename, expected := range map[string]string{
+			nodeIDFilename:    fmt.Sprintf("%d\n", nodeID),
+			clusterIDFilename: clusterID.String() + "\n",
+		} {
+			b, err := ioutil.ReadFile(filepath.Join(dir, filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != expected {
+				t.Errorf("%s: expected %q, but found %q", filename, expected, b)
+			}
+		}
+	}
+
+	// No temporary files should be left behind.
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 0 {
+		t.Errorf("unexpected leftover files: %s", paths)
+	}
+}
+
+func TestDialClientGRPCConnMaxOffset(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	defer func(prev server.MaxOffsetType) { serverCfg.MaxOffset = prev }(serverCfg.MaxOffset)
+	serverCfg.MaxOffset = server.MaxOffsetType(250 * time.Millisecond)
+
+	for _, tc := range []struct {
+		useServerMaxOffset bool
+		expected           time.Duration
+	}{
+		{false, 0},
+		{true, 250 * time.Millisecond},
+	} {
+		// Without grpc.WithBlock, dialing doesn't need a server to connect to.
+		_, clock, stopper, err := dialClientGRPCConn("127.0.0.1:0", tc.useServerMaxOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stopper.Stop(context.TODO())
+		if maxOffset := clock.MaxOffset(); maxOffset != tc.expected {
+			t.Errorf("useServerMaxOffset=%t: expected max offset %s, got %s",
+				tc.useServerMaxOffset, tc.expected, maxOffset)
+		}
+	}
+}
+
+func TestInitExternalIODir(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestInitExternalIODir.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	// The temp dir itself may be behind a symlink (e.g. on macOS).
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "root")
+	secret := filepath.Join(dir, "secret")
+	for _, d := range []string{root, secret} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { externalIODir, externalIORoot = "", "" }()
+	testCases := []struct {
+		dir, root string
+		expected  string
+		err       string
+	}{
+		{filepath.Join(root, "extern"), "", filepath.Join(root, "extern"), ""},
+		{filepath.Join(dir, "link", "extern"), "", filepath.Join(root, "extern"), ""},
+		{filepath.Join(dir, "link", "extern"), filepath.Join(dir, "link"), filepath.Join(root, "extern"), ""},
+		{filepath.Join(root, "escape"), "", secret, ""},
+		{filepath.Join(root, "escape"), root, "", "which is outside of external-io-root"},
+		{filepath.Join(root, "dangling", "x"), "", "", "is a dangling symlink"},
+		{"disabled", root, "", ""},
+	}
+	for i, tc := range testCases {
+		externalIODir, externalIORoot = tc.dir, tc.root
+		actual, err := initExternalIODir(context.TODO(), base.StoreSpec{InMemory: true})
+		if !testutils.IsError(err, tc.err) {
+			t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+		} else if actual != tc.expected {
+			t.Errorf("%d: expected %q, got %q", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseCGroupCPULimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		input    string
+		expected float64
+		ok       bool
+	}{
+		{"200000 100000\n", 2, true},
+		{"50000 100000", 0.5, true},
+		{"max 100000\n", 0, false},
+		{"-1 100000", 0, false},
+		{"100000", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range testCases {
+		limit, ok := parseCGroupCPULimit(tc.input)
+		if ok != tc.ok || limit != tc.expected {
+			t.Errorf("%q: expected %v, %t, got %v, %t", tc.input, tc.expected, tc.ok, limit, ok)
+		}
+	}
+
+	for _, tc := range []struct {
+		procs    int
+		cpus     float64
+		mismatch bool
+	}{
+		{4, 4, false},
+		{1, 0.5, false},
+		{5, 4, false},
+		{32, 4, true},
+		{2, 4, true},
+	} {
+		if mismatch := goProcsMismatch(tc.procs, tc.cpus); mismatch != tc.mismatch {
+			t.Errorf("%d procs, %v CPUs: expected mismatch %t", tc.procs, tc.cpus, tc.mismatch)
+		}
+	}
+}
+
+func TestCheckStoreClusterIDs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestCheckStoreClusterIDs.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	clusterA, clusterB := uuid.MakeV4().String(), uuid.MakeV4().String()
+	var specs []base.StoreSpec
+	for i, clusterID := range []string{clusterA, "", clusterA} {
+		path := filepath.Join(dir, fmt.Sprintf("s%d", i))
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if clusterID != "" {
+			if err := ioutil.WriteFile(filepath.Join(path, clusterIDFilename), []byte(clusterID+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		specs = append(specs, base.StoreSpec{Path: path})
+	}
+	specs = append(specs, base.StoreSpec{InMemory: true})
+
+	ids, err := readStoreClusterIDs(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0].path != specs[0].Path || ids[1].clusterID != clusterA {
+		t.Fatalf("unexpected cluster IDs %+v", ids)
+	}
+	if err := checkStoreClusterIDs(ids, "", ""); err != nil {
+		t.Error(err)
+	}
+	if err := checkStoreClusterIDs(ids, clusterA, "--cluster-id"); err != nil {
+		t.Error(err)
+	}
+	if err := checkStoreClusterIDs(ids, clusterB, "--cluster-id"); !testutils.IsError(err,
+		fmt.Sprintf("store %s belongs to cluster %s, but --cluster-id belongs to cluster %s",
+			specs[0].Path, clusterA, clusterB)) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mixed := append(ids, storeClusterID{path: "/other", clusterID: clusterB})
+	if err := checkStoreClusterIDs(mixed, "", ""); !testutils.IsError(err,
+		fmt.Sprintf("store /other belongs to cluster %s, but store %s belongs to cluster %s",
+			clusterB, specs[0].Path, clusterA)) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestResolveJoinList(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestResolveJoinList.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	path := filepath.Join(dir, "join-list")
+	content := "# seed nodes\nhost1:26257\n\n  host2  \n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	joinList := base.JoinListType{"host0,@" + path, "host3"}
+	resolved, err := resolveJoinList(joinList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := base.JoinListType{"host0:26257", "host1:26257", "host2:26257", "host3:26257"}
+	if !reflect.DeepEqual(expected, resolved) {
+		t.Errorf("expected %v, but found %v", expected, resolved)
+	}
+
+	if _, err := resolveJoinList(base.JoinListType{"@" + filepath.Join(dir, "missing")}); !testutils.IsError(err, "unable to read --join file") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := resolveJoinList(base.JoinListType{"host0,host1:port"}); !testutils.IsError(err, `invalid --join address "host1:port"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNormalizeJoinAddr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		addr     string
+		expected string
+		err      string
+	}{
+		{"host", "host:26257", ""},
+		{"host:1234", "host:1234", ""},
+		{"host:", "host:26257", ""},
+		{"10.0.0.1", "10.0.0.1:26257", ""},
+		{"::1", "[::1]:26257", ""},
+		{"[::1]", "[::1]:26257", ""},
+		{"[::1]:1234", "[::1]:1234", ""},
+		{"host:port", "", `invalid port "port"`},
+		{"host:99999", "", `invalid port "99999"`},
+		{"a:b:c", "", "too many colons"},
+	}
+	for i, tc := range testCases {
+		addr, err := normalizeJoinAddr(tc.addr)
+		if !testutils.IsError(err, tc.err) {
+			t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+			continue
+		}
+		if addr != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, addr)
+		}
+	}
+}
+
+func TestResolveAdvertiseAddr(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	lookup := func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "node.svc":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.5")}, {IP: net.ParseIP("10.0.0.6")}}, nil
+		case "node6.svc":
+			return []net.IPAddr{{IP: net.ParseIP("fd00::5")}}, nil
+		case "empty.svc":
+			return nil, nil
+		}
+		return nil, errors.Errorf("no such host")
+	}
+
+	testCases := []struct {
+		addr     string
+		expected string
+		host     string
+		err      string
+	}{
+		{"node.svc:26257", "10.0.0.5:26257", "node.svc", ""},
+		{"node6.svc:1234", "[fd00::5]:1234", "node6.svc", ""},
+		{"10.0.0.1:26257", "10.0.0.1:26257", "", ""},
+		{"[::1]:26257", "[::1]:26257", "", ""},
+		{":26257", ":26257", "", ""},
+		{"missing.svc:26257", "", "", "unable to resolve --advertise-host missing.svc: no such host"},
+		{"empty.svc:26257", "", "", "--advertise-host empty.svc resolves to no address"},
+		{"node.svc", "", "", "invalid advertised address"},
+	}
+	for i, tc := range testCases {
+		addr, host, err := resolveAdvertiseAddr(context.Background(), tc.addr, lookup)
+		if !testutils.IsError(err, tc.err) {
+			t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+			continue
+		}
+		if addr != tc.expected || host != tc.host {
+			t.Errorf("%d: expected %q (%q), but found %q (%q)", i, tc.expected, tc.host, addr, host)
+		}
+	}
+}
+
+func TestChooseLoggingSetup(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	disk1 := base.StoreSpec{Path: "/mnt/1"}
+	disk2 := base.StoreSpec{Path: "/mnt/2"}
+	mem := base.StoreSpec{InMemory: true}
+
+	testCases := []struct {
+		specs          []base.StoreSpec
+		logDir         string
+		logDirSet      bool
+		logToStderrSet bool
+		perStoreLogs   bool
+		expected       loggingSetup
+	}{
+		// The log directory defaults to the first on-disk store.
+		{[]base.StoreSpec{disk1}, "", false, false, false,
+			loggingSetup{logDir: "/mnt/1/logs", outputDir: "/mnt/1/logs", silenceStderr: true}},
+		{[]base.StoreSpec{mem, disk1}, "", false, false, false,
+			loggingSetup{logDir: "/mnt/1/logs", outputDir: "/mnt/1/logs", silenceStderr: true}},
+		// Several on-disk stores make the default ambiguous.
+		{[]base.StoreSpec{disk1, disk2}, "", false, false, false,
+			loggingSetup{logDir: "/mnt/1/logs", outputDir: "/mnt/1/logs", silenceStderr: true, ambiguousLogDirs: true}},
+		// No on-disk store means no log files.
+		{[]base.StoreSpec{mem}, "", false, false, false,
+			loggingSetup{outputDir: "."}},
+		// An explicit log directory is used as-is, even if empty.
+		{[]base.StoreSpec{disk1, disk2}, "/var/log", true, false, false,
+			loggingSetup{logDir: "/var/log", outputDir: "/var/log", silenceStderr: true}},
+		{[]base.StoreSpec{disk1}, "", true, false, false,
+			loggingSetup{outputDir: "."}},
+		// An explicit --logtostderr is left alone.
+		{[]base.StoreSpec{disk1}, "", false, true, false,
+			loggingSetup{logDir: "/mnt/1/logs", outputDir: "/mnt/1/logs"}},
+		// --per-store-logs makes the first store a deliberate choice, and adds
+		// the logs directory of each on-disk store.
+		{[]base.StoreSpec{disk1, mem, disk2}, "", false, false, true,
+			loggingSetup{logDir: "/mnt/1/logs", outputDir: "/mnt/1/logs", silenceStderr: true,
+				storeLogDirs: []string{"/mnt/1/logs", "/mnt/2/logs"}}},
+		{[]base.StoreSpec{disk1, disk2}, "/var/log", true, false, true,
+			loggingSetup{logDir: "/var/log", outputDir: "/var/log", silenceStderr: true,
+				storeLogDirs: []string{"/mnt/1/logs", "/mnt/2/logs"}}},
+	}
+	for i, tc := range testCases {
+		setup := chooseLoggingSetup(tc.specs, tc.logDir, tc.logDirSet, tc.logToStderrSet, tc.perStoreLogs)
+		if !reflect.DeepEqual(tc.expected, setup) {
+			t.Errorf("%d: expected %+v, but found %+v", i, tc.expected, setup)
+		}
+	}
+}
+
+func TestCheckStoreDirs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestCheckStoreDirs.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// A missing store directory is created, and in-memory stores are ignored.
+	store := filepath.Join(dir, "store")
+	if err := checkStoreDirs([]base.StoreSpec{{Path: store}, {InMemory: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := ioutil.ReadDir(store); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 0 {
+		t.Fatalf("expected no leftover probe files, found %d", len(files))
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(file, "store")
+	if err := checkStoreDirs([]base.StoreSpec{{Path: bad}}); !testutils.IsError(err,
+		fmt.Sprintf("store directory %s cannot be created", bad)) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStoreContainingPath(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	specs := []base.StoreSpec{{Path: "/mnt/s2"}, {InMemory: true}, {Path: "/mnt/s3"}}
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/mnt/s1/cockroach-temp123", ""},
+		{"/mnt/s2/cockroach-temp123", "/mnt/s2"},
+		{"/mnt/s3", "/mnt/s3"},
+		{"/mnt/s3/a/b", "/mnt/s3"},
+		{"/mnt/s30/cockroach-temp123", ""},
+		{"/mnt/..s3", ""},
+	}
+	for i, tc := range testCases {
+		storePath, ok := storeContainingPath(tc.path, specs)
+		if ok != (tc.expected != "") || storePath != tc.expected {
+			t.Errorf("%d: expected %q, but found %q (%t)", i, tc.expected, storePath, ok)
+		}
+	}
+}
+
+func TestDescribeTimingConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	cfg := server.Config{Config: new(base.Config)}
+	cfg.RaftConfig.SetDefaults()
+	cfg.MaxOffset = server.MaxOffsetType(500 * time.Millisecond)
+	if _, warnings := describeTimingConfig(cfg); len(warnings) != 0 {
+		t.Errorf("unexpected warnings for the default configuration: %v", warnings)
+	}
+
+	// A 10ms tick makes for a 150ms election timeout and a 450ms liveness
+	// duration, renewed every 225ms.
+	cfg.RaftTickInterval = 10 * time.Millisecond
+	_, warnings := describeTimingConfig(cfg)
+	if len(warnings) != 3 {
+		t.Fatalf("expected 3 warnings, found %v", warnings)
+	}
+	for i, expected := range []string{
+		"raft election timeout", "node liveness renewal interval", "node liveness duration",
+	} {
+		if !strings.HasPrefix(warnings[i], expected) {
+			t.Errorf("%d: expected %q to start with %q", i, warnings[i], expected)
+		}
+	}
+}
+
+func TestFormatStartupMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	expected := `# HELP cockroach_start_duration_seconds Time taken by the node to start.
+# TYPE cockroach_start_duration_seconds gauge
+cockroach_start_duration_seconds 1.5
+# HELP cockroach_initial_boot Whether the node was started for the first time.
+# TYPE cockroach_initial_boot gauge
+cockroach_initial_boot 1
+# HELP cockroach_node_id The ID of the node.
+# TYPE cockroach_node_id gauge
+cockroach_node_id 3
+`
+	if out := string(formatStartupMetrics(1500*time.Millisecond, true, 3)); out != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, out)
+	}
+}
+
+func TestFormatStartupSummary(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	startTime := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	fields := []startupSummaryField{
+		{"listen addr:", "localhost:26257"},
+		{"external I/O path: ", "<disabled>"},
+		{"store[0]:", `path=/mnt/"data"`},
+	}
+
+	summary, err := formatStartupSummary(startTime, 1500*time.Millisecond, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CockroachDB node starting at 2017-10-01 12:00:00 +0000 UTC (took 1.5s)
+listen addr:         localhost:26257
+external I/O path:   <disabled>
+store[0]:            path=/mnt/"data"
+`
+	if summary != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, summary)
+	}
+
+	structured, err := formatStructuredStartupSummary(startTime, 1500*time.Millisecond, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"started_at":"2017-10-01T12:00:00Z","took":"1.5s",` +
+		`"listen_addr":"localhost:26257","external_I/O_path":"\u003cdisabled\u003e",` +
+		`"store[0]":"path=/mnt/\"data\""}`
+	if structured != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, structured)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(structured), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(fields)+2 {
+		t.Errorf("expected %d fields, found %v", len(fields)+2, decoded)
+	}
+}
+
+func TestPromptYesNo(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		answer   string
+		expected bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{" yes ", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+		{"yep\n", false},
+	}
+	for i, tc := range testCases {
+		var out bytes.Buffer
+		ok, err := promptYesNo(strings.NewReader(tc.answer), &out, "Continue?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.expected {
+			t.Errorf("%d: expected %t, but found %t", i, tc.expected, ok)
+		}
+		if e := "Continue? [y/N] "; out.String() != e {
+			t.Errorf("%d: expected prompt %q, but found %q", i, e, out.String())
+		}
+	}
+}
+
+func TestResolveInsecure(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		secureSet, secure, insecureSet, insecure bool
+		expected                                 bool
+		expectedErr                              string
+	}{
+		{false, true, false, false, false, ""},
+		{false, true, true, true, true, ""},
+		{true, true, false, false, false, ""},
+		{true, false, false, false, true, ""},
+		{true, true, true, false, false, ""},
+		{true, false, true, true, true, ""},
+		{true, true, true, true, false, "--secure=true conflicts with --insecure=true"},
+		{true, false, true, false, false, "--secure=false conflicts with --insecure=false"},
+	}
+	for i, tc := range testCases {
+		insecure, err := resolveInsecure(tc.secureSet, tc.secure, tc.insecureSet, tc.insecure)
+		if !testutils.IsError(err, tc.expectedErr) {
+			t.Errorf("%d: expected %q, but found %v", i, tc.expectedErr, err)
+		} else if err == nil && insecure != tc.expected {
+			t.Errorf("%d: expected %t, but found %t", i, tc.expected, insecure)
+		}
+	}
+}
+
+func TestFormatTempStorageCap(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	desc, over := formatTempStorageCap(32<<30, 40<<30, 100<<30)
+	if e := "32 GiB (device: 40 GiB available of 100 GiB)"; desc != e || over {
+		t.Errorf("expected (%q, false), but found (%q, %t)", e, desc, over)
+	}
+	if _, over := formatTempStorageCap(50<<30, 40<<30, 100<<30); !over {
+		t.Error("expected a cap larger than the available space to be reported")
+	}
+}
+
+func TestVerifyTempStorage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestVerifyTempStorage.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if err := verifyTempStorage(dir, 3<<20+1); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 0 {
+		t.Fatalf("expected the test file to be removed, found %d files", len(files))
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := verifyTempStorage(missing, 1<<20); !testutils.IsError(err, "unable to create a file") {
+		t.Fatalf("expected an error creating the test file, got %v", err)
+	}
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for host, expected := range map[string]bool{
+		"localhost": true,
+		"127.0.0.1": true,
+		"127.1.2.3": true,
+		"::1":       true,
+		"":          false,
+		"0.0.0.0":   false,
+		"::":        false,
+		"10.0.0.1":  false,
+		"myhost":    false,
+	} {
+		if actual := isLoopbackHost(host); actual != expected {
+			t.Errorf("%q: expected %t, but found %t", host, expected, actual)
+		}
+	}
+}
+
+func TestInitTempStorageConfigTempDirPrefix(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestInitTempStorageConfig.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(d, p string) { tempDir, startCtx.tempDirPrefix = d, p }(tempDir, startCtx.tempDirPrefix)
+	store := base.StoreSpec{Path: filepath.Join(dir, "store")}
+	ctx := context.Background()
+
+	startCtx.tempDirPrefix = "n1-temp"
+	var paths []string
+	for i := 0; i < 2; i++ {
+		tempDir = ""
+		cfg, kept, err := initTempStorageConfig(ctx, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Mon.Stop(ctx)
+		if !strings.HasPrefix(filepath.Base(cfg.Path), "n1-temp") {
+			t.Errorf("expected the temp dir to have the custom prefix, found %s", cfg.Path)
+		}
+		if len(kept) != 0 {
+			t.Errorf("expected no temp dir to be kept, found %s", kept)
+		}
+		paths = append(paths, cfg.Path)
+	}
+	// The temp dir created first was cleaned up through the record file.
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", paths[0], err)
+	}
+	if _, err := os.Stat(paths[1]); err != nil {
+		t.Error(err)
+	}
+
+	// With --temp-dir-cleanup=dry-run, the abandoned temp dir is kept and
+	// returned for logging.
+	defer func(m tempDirCleanupMode) { startCtx.tempDirCleanup = m }(startCtx.tempDirCleanup)
+	startCtx.tempDirCleanup = tempDirCleanupDryRun
+	tempDir = ""
+	cfg, kept, err := initTempStorageConfig(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Mon.Stop(ctx)
+	if !reflect.DeepEqual(kept, paths[1:]) {
+		t.Errorf("expected %s to be kept, found %s", paths[1:], kept)
+	}
+	if _, err := os.Stat(paths[1]); err != nil {
+		t.Error(err)
+	}
+	startCtx.tempDirCleanup = tempDirCleanupOn
+
+	startCtx.tempDirPrefix = "a/b"
+	tempDir = ""
+	if _, _, err := initTempStorageConfig(ctx, store); !testutils.IsError(err, "invalid --temp-dir-prefix") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInitTempStorageConfigInMemoryStoreWithTempDir(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestInitTempStorageConfig.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(d string) { tempDir = d }(tempDir)
+	defer func(v bytesOrPercentageValue) { *diskTempStorageSizeValue = v }(*diskTempStorageSizeValue)
+	tempDir = filepath.Join(dir, "scratch")
+	if err := os.Mkdir(tempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := diskTempStorageSizeValue.Set("10%"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cfg, _, err := initTempStorageConfig(ctx, base.StoreSpec{InMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Mon.Stop(ctx)
+
+	if cfg.InMemory {
+		t.Error("expected on-disk temp storage with an explicit --temp-dir")
+	}
+	if filepath.Dir(cfg.Path) != tempDir {
+		t.Errorf("expected temp storage inside %s, found %s", tempDir, cfg.Path)
+	}
+	usage, err := getFileSystemUsage(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := int64(usage.Total) * 10 / 100; cfg.MaxSizeBytes != e {
+		t.Errorf("expected the percentage to resolve to %d bytes of the temp dir's device, found %d",
+			e, cfg.MaxSizeBytes)
+	}
+
+	// Percentages of free space are accepted as well.
+	if err := diskTempStorageSizeValue.Set("10%free"); err != nil {
+		t.Fatal(err)
+	}
+	cfg2, _, err := initTempStorageConfig(ctx, base.StoreSpec{InMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg2.Mon.Stop(ctx)
+}
+
+func TestDescribeTempStorageDisabled(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, inMem := range []bool{false, true} {
+		cfg := base.TempStorageConfig{InMemory: inMem, Path: "/nonexistent"}
+		if desc, over := describeTempStorage(cfg); desc != "disabled" || over {
+			t.Errorf("in memory %t: expected (disabled, false), but found (%s, %t)", inMem, desc, over)
+		}
+	}
+}
+
+func TestWaitForListenerRelease(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	if err := waitForListenerRelease(addr, 100*time.Millisecond); !testutils.IsError(err,
+		"node still accepting connections on "+addr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() { _ = ln.Close() })
+	if err := waitForListenerRelease(addr, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountLocalLeases(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	rangeInfo := func(source, leaseholder roachpb.NodeID) serverpb.RangeInfo {
+		var r serverpb.RangeInfo
+		r.SourceNodeID = source
+		if leaseholder != 0 {
+			r.State.Lease = &roachpb.Lease{Replica: roachpb.ReplicaDescriptor{NodeID: leaseholder}}
+		}
+		return r
+	}
+	ranges := []serverpb.RangeInfo{
+		rangeInfo(1, 1),
+		rangeInfo(1, 2),
+		rangeInfo(1, 0),
+		rangeInfo(1, 1),
+	}
+	if count := countLocalLeases(ranges); count != 2 {
+		t.Errorf("expected 2 local leases, found %d", count)
+	}
+}
+
+// recordingAdminClient records the drain requests it receives and answers
+// each of them with an empty stream.
+type recordingAdminClient struct {
+	serverpb.AdminClient
+	mu struct {
+		syncutil.Mutex
+		reqs []serverpb.DrainRequest
+	}
+}
+
+func (c *recordingAdminClient) Drain(
+	_ context.Context, req *serverpb.DrainRequest, _ ...grpc.CallOption,
+) (serverpb.Admin_DrainClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.reqs = append(c.mu.reqs, *req)
+	return emptyDrainClient{}, nil
+}
+
+type emptyDrainClient struct {
+	serverpb.Admin_DrainClient
+}
+
+func (emptyDrainClient) Recv() (*serverpb.DrainResponse, error) {
+	return nil, io.EOF
+}
+
+// rangesStatusClient answers Ranges requests with ranges, or with err if it
+// is set.
+type rangesStatusClient struct {
+	serverpb.StatusClient
+	ranges []serverpb.RangeInfo
+	err    error
+}
+
+func (c rangesStatusClient) Ranges(
+	context.Context, *serverpb.RangesRequest, ...grpc.CallOption,
+) (*serverpb.RangesResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &serverpb.RangesResponse{Ranges: c.ranges}, nil
+}
+
+func TestTransferLeasesUndrainOnError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	undrain := serverpb.DrainRequest{Off: []int32{int32(serverpb.DrainMode_LEASES)}}
+	testCases := []struct {
+		sc          rangesStatusClient
+		expectedErr string
+	}{
+		// The node has shed its leases: it stays in the leases drain mode until
+		// it shuts down.
+		{rangesStatusClient{}, ""},
+		{rangesStatusClient{err: errors.New("boom")}, "unable to count the leases held by the node: boom"},
+		{rangesStatusClient{err: context.Canceled}, "unable to count the leases held by the node"},
+	}
+	for i, tc := range testCases {
+		c := &recordingAdminClient{}
+		var buf bytes.Buffer
+		err := transferLeases(context.Background(), c, tc.sc, &buf)
+		if !testutils.IsError(err, tc.expectedErr) {
+			t.Errorf("%d: expected error %q, found %v", i, tc.expectedErr, err)
+		}
+		c.mu.Lock()
+		var undrained bool
+		for _, req := range c.mu.reqs {
+			if reflect.DeepEqual(req, undrain) {
+				undrained = true
+			}
+		}
+		c.mu.Unlock()
+		if expected := tc.expectedErr != ""; undrained != expected {
+			t.Errorf("%d: expected undrain %t, found %t; output:\n%s", i, expected, undrained, buf.String())
+		}
+	}
+}
+
+func TestGracefulShutdownError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		sig        os.Signal
+		configured bool
+		exitCode   int
+	}{
+		{syscall.SIGINT, false, 1},
+		{syscall.SIGTERM, false, 0},
+		{syscall.SIGQUIT, false, 0},
+		{syscall.SIGINT, true, 128 + int(syscall.SIGINT)},
+		{syscall.SIGTERM, true, 128 + int(syscall.SIGTERM)},
+		{syscall.SIGQUIT, true, 128 + int(syscall.SIGQUIT)},
+	}
+	for i, tc := range testCases {
+		exitCode := 0
+		if err := gracefulShutdownError(tc.sig, tc.configured); err != nil {
+			cliErr, ok := err.(*cliError)
+			if !ok {
+				t.Fatalf("%d: expected a *cliError, found %T", i, err)
+			}
+			exitCode = cliErr.exitCode
+		}
+		if exitCode != tc.exitCode {
+			t.Errorf("%d: expected exit code %d for %s, found %d", i, tc.exitCode, tc.sig, exitCode)
+		}
+	}
+}
+
+func TestLocalHealthError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		err     error
+		healthy bool
+	}{
+		{nil, true},
+		// The node answered, but it can't heartbeat its liveness record.
+		{grpc.Errorf(codes.Unavailable, errNodeNotLiveDesc), true},
+		{grpc.Errorf(codes.Unavailable, "transport is closing"), false},
+		{grpc.Errorf(codes.DeadlineExceeded, "context deadline exceeded"), false},
+		{errors.New("boom"), false},
+	}
+	for i, tc := range testCases {
+		if err := localHealthError(tc.err); (err == nil) != tc.healthy {
+			t.Errorf("%d: expected healthy %t for %v, found %v", i, tc.healthy, tc.err, err)
+		}
+	}
+}
+
+// drainModesAdminClient answers drain requests with on as the active drain
+// modes.
+type drainModesAdminClient struct {
+	serverpb.AdminClient
+	on []int32
+}
+
+func (c drainModesAdminClient) Drain(
+	context.Context, *serverpb.DrainRequest, ...grpc.CallOption,
+) (serverpb.Admin_DrainClient, error) {
+	return &drainModesDrainClient{on: c.on}, nil
+}
+
+type drainModesDrainClient struct {
+	serverpb.Admin_DrainClient
+	on   []int32
+	sent bool
+}
+
+func (c *drainModesDrainClient) Recv() (*serverpb.DrainResponse, error) {
+	if c.sent {
+		return nil, io.EOF
+	}
+	c.sent = true
+	return &serverpb.DrainResponse{On: c.on}, nil
+}
+
+func TestNodeDrained(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	graceful := []int32{int32(serverpb.DrainMode_CLIENT), int32(serverpb.DrainMode_LEASES)}
+	lease := func(holder roachpb.NodeID) serverpb.RangeInfo {
+		var r serverpb.RangeInfo
+		r.SourceNodeID = 1
+		r.State.Lease = &roachpb.Lease{Replica: roachpb.ReplicaDescriptor{NodeID: holder}}
+		return r
+	}
+	testCases := []struct {
+		on          []int32
+		sc          rangesStatusClient
+		drained     bool
+		expectedErr string
+	}{
+		{nil, rangesStatusClient{}, false, ""},
+		{[]int32{int32(serverpb.DrainMode_CLIENT)}, rangesStatusClient{}, false, ""},
+		// The drain modes are active, but the node still holds a lease.
+		{graceful, rangesStatusClient{ranges: []serverpb.RangeInfo{lease(2), lease(1)}}, false, ""},
+		{graceful, rangesStatusClient{ranges: []serverpb.RangeInfo{lease(2)}}, true, ""},
+		{graceful, rangesStatusClient{err: errors.New("boom")}, false,
+			"unable to count the leases held by the node: boom"},
+	}
+	for i, tc := range testCases {
+		drained, err := nodeDrained(context.Background(), drainModesAdminClient{on: tc.on}, tc.sc)
+		if !testutils.IsError(err, tc.expectedErr) {
+			t.Errorf("%d: expected error %q, found %v", i, tc.expectedErr, err)
+		}
+		if drained != tc.drained {
+			t.Errorf("%d: expected drained %t, found %t", i, tc.drained, drained)
+		}
+	}
+}
+
+func TestCheckNodeLeft(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	status := func(nodeID roachpb.NodeID, live bool) serverpb.DecommissionStatusResponse_Status {
+		return serverpb.DecommissionStatusResponse_Status{NodeID: nodeID, IsLive: live}
+	}
+	testCases := []struct {
+		statuses    []serverpb.DecommissionStatusResponse_Status
+		expectedErr string
+	}{
+		{nil, "node 2 is not reported by its peer"},
+		{[]serverpb.DecommissionStatusResponse_Status{status(1, false)},
+			"node 2 is not reported by its peer"},
+		{[]serverpb.DecommissionStatusResponse_Status{status(1, true), status(2, true)},
+			"node 2 is still reported as live"},
+		{[]serverpb.DecommissionStatusResponse_Status{status(1, true), status(2, false)}, ""},
+	}
+	for i, tc := range testCases {
+		resp := &serverpb.DecommissionStatusResponse{Status: tc.statuses}
+		if err := checkNodeLeft(resp, 2); !testutils.IsError(err, tc.expectedErr) {
+			t.Errorf("%d: expected error %q, found %v", i, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestCheckListenAddrs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		addr, httpAddr string
+		expected       string
+	}{
+		{":26257", ":8080", ""},
+		{"10.0.0.1:26257", "127.0.0.1:26257", ""},
+		{"10.0.0.1:0", "10.0.0.1:0", ""},
+		{"10.0.0.1:26257", "10.0.0.1:26257", "collide"},
+		{":26257", "127.0.0.1:26257", "collide"},
+		{"[::1]:8080", "[::]:8080", "collide"},
+		{"0.0.0.0:8080", "10.0.0.1:8080", "collide"},
+		{"10.0.0.1", ":8080", "invalid listen address"},
+		{":26257", "10.0.0.1", "invalid HTTP listen address"},
+	}
+	for i, tc := range testCases {
+		err := checkListenAddrs(tc.addr, tc.httpAddr)
+		if !testutils.IsError(err, tc.expected) {
+			t.Errorf("%d: expected %q, but found %v", i, tc.expected, err)
+		}
+	}
+}
+
+func TestIsAddrInUse(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("windows reports a different error")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	_, err = net.Listen("tcp", ln.Addr().String())
+	if err == nil {
+		t.Fatal("expected the second listener to fail")
+	}
+	if !isAddrInUse(err) {
+		t.Errorf("expected %v to report the address in use", err)
+	}
+	if isAddrInUse(errors.New("listen tcp: some other error")) {
+		t.Error("expected other errors not to report the address in use")
+	}
+}
+
+func TestFindPortHolderProc(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestFindPortHolderProc.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// Port 26257 (0x66D1) is listened on by the socket with inode 4242, and
+	// connected to by the one with inode 4343.
+	const tcp = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:66D1 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 4242 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:D431 0100007F:66D1 01 00000000:00000000 00:00000000 00000000  1000        0 4343 1 0000000000000000 20 4 30 10 -1
+`
+	if err := os.MkdirAll(filepath.Join(dir, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(tcp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for pid, inode := range map[string]string{"12": "4343", "34": "4242"} {
+		fdDir := filepath.Join(dir, pid, "fd")
+		if err := os.MkdirAll(fdDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("socket:["+inode+"]", filepath.Join(fdDir, "3")); err != nil {
+			t.Skipf("unable to create symlinks: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, pid, "comm"), []byte("cockroach\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pid, command, ok := findPortHolderProc(dir, 26257); !ok || pid != 34 || command != "cockroach" {
+		t.Errorf("expected PID 34 (cockroach), but found %d (%s), %t", pid, command, ok)
+	}
+	if _, _, ok := findPortHolderProc(dir, 26258); ok {
+		t.Error("expected no process to be found for another port")
+	}
+}
+
+func TestReadLocalityFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	dir, err := ioutil.TempDir("", "TestReadLocalityFile.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	testCases := []struct {
+		content  string
+		flag     string
+		expected string
+	}{
+		{"region=us-east,zone=a", "", "region=us-east,zone=a"},
+		{"# comment\nregion=us-east\n\nzone=a\n", "", "region=us-east,zone=a"},
+		{"region=us-east,zone=a", "zone=b", "region=us-east,zone=b"},
+		{"region=us-east,zone=a", "zone=b,rack=12", "region=us-east,zone=b,rack=12"},
+		{"", "zone=b", "zone=b"},
+	}
+	for i, tc := range testCases {
+		path := filepath.Join(dir, fmt.Sprintf("locality%d", i))
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var flagLocality roachpb.Locality
+		if tc.flag != "" {
+			if err := flagLocality.Set(tc.flag); err != nil {
+				t.Fatal(err)
+			}
+		}
+		locality, err := readLocalityFile(path, flagLocality)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if actual := locality.String(); actual != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestDrainMessagesDrainFields(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	req := serverpb.DrainRequest{
+		On:              []int32{int32(serverpb.DrainMode_CLIENT), int32(serverpb.DrainMode_JOBS)},
+		Shutdown:        true,
+		ClientDrainWait: int64(30 * time.Second),
+		JobsDrainWait:   int64(time.Minute),
+	}
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedReq serverpb.DrainRequest
+	if err := decodedReq.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req, decodedReq) {
+		t.Errorf("expected %+v, but found %+v", req, decodedReq)
+	}
+
+	resp := serverpb.DrainResponse{
+		On:              []int32{int32(serverpb.DrainMode_CLIENT)},
+		ClientsCanceled: 3,
+		JobsWaited:      []int64{1, 300, 1 << 40},
+		JobsTimedOut:    []int64{1 << 40},
+	}
+	if data, err = resp.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	var decodedResp serverpb.DrainResponse
+	if err := decodedResp.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp, decodedResp) {
+		t.Errorf("expected %+v, but found %+v", resp, decodedResp)
+	}
+
+	var buf bytes.Buffer
+	reportDrainOutcome(&buf, drainOutcome{})
+	reportDrainOutcome(&buf, drainOutcome{
+		clientsCanceled: 3,
+		jobsWaited:      []int64{1, 2},
+		jobsTimedOut:    []int64{2},
+	})
+	if expected := "force-closed 3 client connections\n" +
+		"waited for 2 jobs to checkpoint: 1, 2\n" +
+		"1 job did not checkpoint in time: 2\n"; buf.String() != expected {
+		t.Errorf("expected %q, but found %q", expected, buf.String())
+	}
+}
+
+func TestDisableProfiling(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	prevCtx, prevEnv, prevConfig := startCtx.disableProfiling, disableProfilingEnv, profilingConfig
+	defer func() {
+		startCtx.disableProfiling, disableProfilingEnv = prevCtx, prevEnv
+		profilingConfig = prevConfig
+		runtime.SetBlockProfileRate(prevConfig.blockProfileRate)
+		runtime.SetMutexProfileFraction(prevConfig.mutexProfileFraction)
+	}()
+
+	profilingConfig.memProfileInterval = time.Minute
+	startCtx.disableProfiling, disableProfilingEnv = false, false
+	if profilingDisabled() {
+		t.Fatal("expected profiling to be enabled")
+	}
+	if s := profilingConfigString(); !strings.Contains(s, "mem=every 1m0s") {
+		t.Errorf("unexpected profiling config %q", s)
+	}
+
+	disableProfilingEnv = true
+	if !profilingDisabled() {
+		t.Fatal("expected COCKROACH_DISABLE_PROFILING to disable profiling")
+	}
+	disableProfilingEnv = false
+	startCtx.disableProfiling = true
+	if !profilingDisabled() {
+		t.Fatal("expected --disable-profiling to disable profiling")
+	}
+
+	turnOffProfilers()
+	if s := profilingConfigString(); s != "disabled" {
+		t.Errorf("expected profiling config \"disabled\", found %q", s)
+	}
+	if profilingConfig.memProfileInterval != 0 || runtime.SetMutexProfileFraction(-1) != 0 {
+		t.Errorf("expected profilers to be turned off, found %+v", profilingConfig)
+	}
+}
+
+func TestMountedFileSystem(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	mounts := []gosigar.FileSystem{
+		{DirName: "/", SysTypeName: "ext4"},
+		{DirName: "/dev/shm", SysTypeName: "tmpfs"},
+		{DirName: "/mnt/data", SysTypeName: "xfs"},
+		{DirName: "/mnt/data", SysTypeName: "ramfs"},
+	}
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "ext4"},
+		{"/var/tmp", "ext4"},
+		{"/dev/shm", "tmpfs"},
+		{"/dev/shm/crdb", "tmpfs"},
+		{"/dev/shmem", "ext4"},
+		{"/mnt/data/temp", "ramfs"},
+	}
+	for _, tc := range testCases {
+		fs, ok := mountedFileSystem(tc.path, mounts)
+		fsType := fs.SysTypeName
+		if !ok || fsType != tc.expected {
+			t.Errorf("%s: expected %s, but found %q (found=%t)", tc.path, tc.expected, fsType, ok)
+		}
+		if memoryBackedFileSystems[fsType] != (tc.expected == "tmpfs" || tc.expected == "ramfs") {
+			t.Errorf("%s: unexpected memory-backed classification of %s", tc.path, fsType)
+		}
+	}
+	if _, ok := mountedFileSystem("/data", mounts[1:]); ok {
+		t.Error("expected no file system for a path outside of all mounts")
+	}
+}
+
+func TestOversizedStoreDevices(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	devices := []*storeDevice{
+		{mountPoint: "/mnt/a", capacity: 100 << 30, stores: []string{"/mnt/a/1"}, size: 50 << 30},
+		{mountPoint: "/mnt/b", capacity: 100 << 30, stores: []string{"/mnt/b/1"}, size: 200 << 30},
+		{mountPoint: "/mnt/c", capacity: 100 << 30, stores: []string{"/mnt/c/1", "/mnt/c/2"}, size: 120 << 30},
+		{mountPoint: "/mnt/d", capacity: 100 << 30, stores: []string{"/mnt/d/1", "/mnt/d/2"}, size: 100 << 30},
+	}
+	expected := []string{
+		"store /mnt/b/1 is configured with a size of 200 GiB, but its device (mounted on /mnt/b) only has 100 GiB",
+		"stores /mnt/c/1, /mnt/c/2 are configured with a combined size of 120 GiB, but their device (mounted on /mnt/c) only has 100 GiB",
+	}
+	if problems := oversizedStoreDevices(devices); !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected\n%s\nfound\n%s", strings.Join(expected, "\n"), strings.Join(problems, "\n"))
+	}
+}
+
+func TestExcessiveJoinClockOffsets(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	offsets := []joinClockOffset{
+		{addr: "a:26257", offset: 100 * time.Millisecond, uncertainty: time.Millisecond},
+		{addr: "b:26257", offset: -300 * time.Millisecond, uncertainty: 10 * time.Millisecond},
+		// Could be within the threshold given the uncertainty.
+		{addr: "c:26257", offset: 300 * time.Millisecond, uncertainty: 100 * time.Millisecond},
+		{addr: "d:26257", err: errors.New("connection refused")},
+	}
+
+	expected := []string{
+		"the clock of join target b:26257 is offset by -300ms (±10ms) from the local clock, " +
+			"more than 50% of the maximum offset of 500ms",
+	}
+	if problems := excessiveJoinClockOffsets(offsets, 500*time.Millisecond); !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %q, found %q", expected, problems)
+	}
+
+	var descs []string
+	for _, o := range offsets {
+		descs = append(descs, o.String())
+	}
+	expected = []string{
+		"a:26257 +100ms (±1ms)",
+		"b:26257 -300ms (±10ms)",
+		"c:26257 +300ms (±100ms)",
+		"d:26257 <unknown>",
+	}
+	if !reflect.DeepEqual(expected, descs) {
+		t.Errorf("expected %q, found %q", expected, descs)
+	}
+}
+
+// unresponsiveAdminClient accepts drain requests but never responds to them.
+type unresponsiveAdminClient struct {
+	serverpb.AdminClient
+}
+
+func (unresponsiveAdminClient) Drain(
+	ctx context.Context, _ *serverpb.DrainRequest, _ ...grpc.CallOption,
+) (serverpb.Admin_DrainClient, error) {
+	return unresponsiveDrainClient{ctx: ctx}, nil
+}
+
+type unresponsiveDrainClient struct {
+	serverpb.Admin_DrainClient
+	ctx context.Context
+}
+
+func (c unresponsiveDrainClient) Recv() (*serverpb.DrainResponse, error) {
+	<-c.ctx.Done()
+	return nil, c.ctx.Err()
+}
+
+func TestDrainTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	defer func(d time.Duration) { quitCtx.drainWait = d }(quitCtx.drainWait)
+	quitCtx.drainWait = time.Minute
+
+	req := serverpb.DrainRequest{
+		On:              []int32{int32(serverpb.DrainMode_CLIENT), int32(serverpb.DrainMode_JOBS)},
+		ClientDrainWait: int64(10 * time.Second),
+		JobsDrainWait:   int64(time.Minute),
+	}
+	// The wait for jobs is part of --drain-wait.
+	if e, a := 70*time.Second, drainTimeout(req); e != a {
+		t.Errorf("expected %s, found %s", e, a)
+	}
+}
+
+func TestErrTryHardShutdownProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	requested := []int32{int32(serverpb.DrainMode_CLIENT), int32(serverpb.DrainMode_LEASES)}
+	testCases := []struct {
+		err      errTryHardShutdown
+		expected string
+	}{
+		{errTryHardShutdown{error: errors.New("boom"), requested: requested},
+			"boom (the node reported no drain progress before the error)"},
+		{errTryHardShutdown{error: errors.New("boom"), responses: 2, requested: requested},
+			"boom (drain was 0% complete after 2 drain responses: 0 of 2 drain modes entered)"},
+		{errTryHardShutdown{error: errors.New("boom"), responses: 3, requested: requested,
+			on: []int32{int32(serverpb.DrainMode_CLIENT)}},
+			"boom (drain was 50% complete after 3 drain responses: 1 of 2 drain modes entered (client))"},
+		{errTryHardShutdown{error: errors.New("boom"), responses: 1},
+			"boom (after 1 drain response)"},
+		// The jobs drain mode is never reported as active.
+		{errTryHardShutdown{error: errors.New("boom"), responses: 1,
+			requested: append([]int32{int32(serverpb.DrainMode_JOBS)}, requested...), on: requested},
+			"boom (drain was 100% complete after 1 drain response: 2 of 2 drain modes entered (client, leases))"},
+		{errTryHardShutdown{error: errors.New("boom"), responses: 1,
+			requested: []int32{int32(serverpb.DrainMode_JOBS)}},
+			"boom (after 1 drain response)"},
+	}
+	for i, tc := range testCases {
+		if a := tc.err.Error(); a != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, a)
+		}
+	}
+}
+
+func TestDoShutdownProbeTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	defer func(d time.Duration) { quitCtx.probeTimeout = d }(quitCtx.probeTimeout)
+	quitCtx.probeTimeout = 10 * time.Millisecond
+
+	_, err := doShutdown(context.Background(), unresponsiveAdminClient{}, serverpb.DrainRequest{})
+	if _, ok := err.(errProbeTimedOut); !ok {
+		t.Fatalf("expected the probe to time out, but found %v", err)
+	}
+	if !testutils.IsError(err, "did not respond to the connectivity probe within 10ms") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// flakyHealthAdminClient fails the first failures health checks with an
+// Unavailable error, like a node that isn't live yet.
+type flakyHealthAdminClient struct {
+	serverpb.AdminClient
+	failures int
+	calls    *int
+}
+
+func (c flakyHealthAdminClient) Health(
+	context.Context, *serverpb.HealthRequest, ...grpc.CallOption,
+) (*serverpb.HealthResponse, error) {
+	*c.calls++
+	if *c.calls <= c.failures {
+		return nil, grpc.Errorf(codes.Unavailable, errNodeNotLiveDesc)
+	}
+	return &serverpb.HealthResponse{}, nil
+}
+
+func TestWaitForNodeHealth(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		failures    int
+		timeout     time.Duration
+		calls       int
+		expectedErr string
+	}{
+		{0, 10 * time.Second, 1, ""},
+		// The node becomes healthy after a couple of retries.
+		{2, 10 * time.Second, 3, ""},
+		// The node never becomes healthy; the first attempt fails and the
+		// timeout expires before the first retry.
+		{math.MaxInt32, 50 * time.Millisecond, 1,
+			"node not healthy after 50ms: .*node is not live"},
+	}
+	for i, tc := range testCases {
+		var calls int
+		c := flakyHealthAdminClient{failures: tc.failures, calls: &calls}
+		err := waitForNodeHealth(context.Background(), c, tc.timeout)
+		if !testutils.IsError(err, tc.expectedErr) {
+			t.Errorf("%d: expected error %q, found %v", i, tc.expectedErr, err)
+		}
+		if calls != tc.calls {
+			t.Errorf("%d: expected %d health checks, found %d", i, tc.calls, calls)
+		}
+	}
+}
+
+func TestCacheSizeWarning(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const gib = 1 << 30
+	testCases := []struct {
+		cache, sqlPool, total int64
+		expected              string
+	}{
+		{4 * gib, 4 * gib, 16 * gib, ""},
+		{8 * gib, 4 * gib, 16 * gib, ""},
+		{9 * gib, 4 * gib, 16 * gib,
+			"--cache=9.0 GiB is larger than the 8.0 GiB that can safely be used for the cache " +
+				"given the 16 GiB of total memory and --max-sql-memory=4.0 GiB; " +
+				"the node risks running out of memory, consider lowering --cache"},
+		{1 * gib, 16 * gib, 16 * gib,
+			"--cache=1.0 GiB is larger than the 0 B that can safely be used for the cache " +
+				"given the 16 GiB of total memory and --max-sql-memory=16 GiB; " +
+				"the node risks running out of memory, consider lowering --cache"},
+	}
+	for i, tc := range testCases {
+		if w := cacheSizeWarning(tc.cache, tc.sqlPool, tc.total); w != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, w)
+		}
+	}
+}
+
+func TestInMemorySizeWarning(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const gib = 1 << 30
+	specs := []base.StoreSpec{
+		{InMemory: true, SizeInBytes: 2 * gib},
+		{InMemory: true, SizePercent: 25},
+		{Path: "/mnt/1", SizeInBytes: 100 * gib},
+	}
+	if size := inMemoryStoresSize(specs, 16*gib); size != 6*gib {
+		t.Fatalf("expected in-memory stores of 6 GiB, but found %d", size)
+	}
+
+	testCases := []struct {
+		stores, cache, sqlPool, total int64
+		expected                      string
+	}{
+		{6 * gib, 2 * gib, 4 * gib, 16 * gib, ""},
+		{6 * gib, 4 * gib, 4 * gib, 16 * gib,
+			"the in-memory stores (6.0 GiB), --cache (4.0 GiB) and --max-sql-memory (4.0 GiB) " +
+				"add up to 14 GiB, more than the 12 GiB of the 16 GiB of total memory that can " +
+				"safely be used for them"},
+	}
+	for i, tc := range testCases {
+		if w := inMemorySizeWarning(tc.stores, tc.cache, tc.sqlPool, tc.total); w != tc.expected {
+			t.Errorf("%d: expected %q, but found %q", i, tc.expected, w)
+		}
+	}
 }
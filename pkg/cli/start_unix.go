@@ -17,11 +17,21 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 
 	"github.com/cockroachdb/cockroach/pkg/cli/cliflags"
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/sdnotify"
 )
 
@@ -48,7 +58,86 @@ func maybeRerunBackground() (bool, error) {
 		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = stderr
-		return true, sdnotify.Exec(cmd)
+		if err := sdnotify.Exec(cmd); err != nil {
+			return true, &cliError{
+				exitCode: backgroundExitCode(err),
+				severity: log.Severity_ERROR,
+				cause:    errors.Wrap(err, "node failed to start in the background"),
+			}
+		}
+		return true, nil
 	}
 	return false, nil
 }
+
+// backgroundExitCode determines the exit code to use when the node started
+// with --background terminated before becoming ready. The child's own exit
+// code is propagated when available, using the 128+signal convention if it
+// was killed by a signal.
+func backgroundExitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			if code := status.ExitStatus(); code > 0 {
+				return code
+			}
+		}
+	}
+	return 1
+}
+
+// notifyCPUProfileRotation relays SIGUSR2 to ch, which forces an immediate
+// rotation of the cpu profile, and returns the name of the signal.
+func notifyCPUProfileRotation(ch chan<- os.Signal) string {
+	signal.Notify(ch, syscall.SIGUSR2)
+	return "SIGUSR2"
+}
+
+// notifyProfilerToggle relays SIGUSR1 to ch, which pauses or resumes the
+// periodic profilers, and returns the name of the signal. SIGTSTP is left
+// alone so that the process can still be suspended from a terminal.
+func notifyProfilerToggle(ch chan<- os.Signal) string {
+	signal.Notify(ch, syscall.SIGUSR1)
+	return "SIGUSR1"
+}
+
+// findPortHolder returns the ID and command name of the process listening on
+// port. The proc filesystem is used where available, and lsof otherwise.
+func findPortHolder(port int) (pid int, command string, ok bool) {
+	if pid, command, ok := findPortHolderProc("/proc", port); ok {
+		return pid, command, true
+	}
+	out, err := exec.Command("lsof", "-nP", "-t", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN").Output()
+	if err != nil {
+		return 0, "", false
+	}
+	pid, err = strconv.Atoi(strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]))
+	if err != nil {
+		return 0, "", false
+	}
+	if out, err := exec.Command("ps", "-o", "comm=", "-p", strconv.Itoa(pid)).Output(); err == nil {
+		command = filepath.Base(strings.TrimSpace(string(out)))
+	}
+	return pid, command, true
+}
+
+// allowRoot suppresses the warning about running the node as root, for the
+// rare deployments where it is legitimate.
+var allowRoot = envutil.EnvOrDefaultBool("COCKROACH_ALLOW_ROOT", false)
+
+// maybeWarnRunningAsRoot warns if the node runs as root. Besides the
+// security implications, the files it creates are then owned by root, which
+// causes permission errors if the node is later run as a regular user.
+func maybeWarnRunningAsRoot(ctx context.Context) {
+	if os.Geteuid() != 0 || allowRoot {
+		return
+	}
+	log.Shout(ctx, log.Severity_WARNING,
+		"RUNNING AS ROOT!\n\n"+
+			"- Running the node as root is discouraged; a compromised node has full control over the machine.\n"+
+			"- Files created by the node (stores, logs) will be owned by root.\n\n"+
+			"Consider running the node as a dedicated unprivileged user.\n"+
+			"Set COCKROACH_ALLOW_ROOT=true to suppress this warning.")
+}
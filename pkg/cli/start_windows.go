@@ -14,6 +14,33 @@
 
 package cli
 
+import (
+	"os"
+
+	"golang.org/x/net/context"
+)
+
 func maybeRerunBackground() (bool, error) {
 	return false, nil
 }
+
+// notifyCPUProfileRotation is a no-op: there is no signal to force the
+// rotation of the cpu profile on Windows.
+func notifyCPUProfileRotation(ch chan<- os.Signal) string {
+	return ""
+}
+
+// notifyProfilerToggle is a no-op: there is no signal to pause the periodic
+// profilers on Windows.
+func notifyProfilerToggle(ch chan<- os.Signal) string {
+	return ""
+}
+
+// findPortHolder always fails: the process listening on a port isn't looked
+// up on Windows.
+func findPortHolder(port int) (pid int, command string, ok bool) {
+	return 0, "", false
+}
+
+// maybeWarnRunningAsRoot is a no-op: there is no root user on Windows.
+func maybeWarnRunningAsRoot(ctx context.Context) {}
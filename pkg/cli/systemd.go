@@ -0,0 +1,59 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/sdnotify"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+)
+
+// startSDWatchdog sends a watchdog keepalive to systemd at half the given
+// interval for as long as the stopper runs, but only when the healthy check
+// passes. A node that stays unhealthy thus gets restarted by systemd. Each
+// check is bounded by a quarter of the interval, so that a slow check that
+// passes still leaves a keepalive within the interval of the previous one.
+func startSDWatchdog(
+	ctx context.Context,
+	stopper *stop.Stopper,
+	interval time.Duration,
+	healthy func(context.Context) error,
+) {
+	stopper.RunWorker(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, interval/4)
+				err := healthy(checkCtx)
+				cancel()
+				if err != nil {
+					log.Warningf(ctx, "not sending systemd watchdog keepalive: %s", err)
+					continue
+				}
+				if err := sdnotify.Notify("WATCHDOG=1"); err != nil {
+					log.Warning(ctx, err)
+				}
+			case <-stopper.ShouldStop():
+				return
+			}
+		}
+	})
+}
@@ -107,7 +107,7 @@ func runDebugZip(cmd *cobra.Command, args []string) error {
 		return usageAndError(cmd)
 	}
 
-	conn, _, stopper, err := getClientGRPCConn()
+	conn, _, stopper, err := getClientGRPCConn(false /* useServerMaxOffset */)
 	if err != nil {
 		return err
 	}
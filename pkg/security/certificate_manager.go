@@ -226,6 +226,16 @@ func (cm *CertificateManager) LoadCertificates() error {
 		if err := checkCertIsValid(nodeCert); checkCertIsValid(cm.nodeCert) == nil && err != nil {
 			return errors.Wrap(err, "reload would lose valid node cert")
 		}
+		// Make sure the new certificates can actually be used to serve TLS
+		// (e.g. the node key matches the node certificate) before swapping
+		// them in, as connections would otherwise start failing.
+		if checkCertIsValid(caCert) == nil && checkCertIsValid(nodeCert) == nil {
+			if _, err := newServerTLSConfig(
+				nodeCert.FileContents, nodeCert.KeyFileContents, caCert.FileContents,
+			); err != nil {
+				return errors.Wrap(err, "reload would produce an unusable server TLS config")
+			}
+		}
 	}
 
 	// Swap everything.
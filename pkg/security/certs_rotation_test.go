@@ -190,3 +190,51 @@ func TestRotateCerts(t *testing.T) {
 			return nil
 		})
 }
+
+// TestRotateCertsRejectsMismatchedKey verifies that a reload which would leave
+// the node with a key that does not match its certificate is rejected and the
+// previously loaded certificates remain in use.
+func TestRotateCertsRejectsMismatchedKey(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	// Do not mock cert access for this test.
+	security.ResetAssetLoader()
+	defer ResetTest()
+	certsDir, err := ioutil.TempDir("", "certs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(certsDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if err := generateAllCerts(certsDir); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := security.NewCertificateManager(certsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeCert := cm.NodeCert()
+
+	// Replace the node key with the (valid, but unrelated) root client key.
+	clientKey, err := ioutil.ReadFile(filepath.Join(certsDir, "client.root.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(certsDir, "node.key"), clientKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.LoadCertificates(); !testutils.IsError(err, "unusable server TLS config") {
+		t.Fatalf("expected unusable server TLS config error, got: %v", err)
+	}
+	if cm.NodeCert() != nodeCert {
+		t.Fatal("expected node certificate to be unchanged after rejected reload")
+	}
+	if _, err := cm.GetServerTLSConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1018,6 +1018,9 @@ func (s *adminServer) Health(
 	if !isLive {
 		return nil, grpc.Errorf(codes.Unavailable, "node is not live")
 	}
+	if req.Ready && s.server.pgServer.IsDraining() {
+		return nil, grpc.Errorf(codes.Unavailable, "node is draining")
+	}
 	return &serverpb.HealthResponse{}, nil
 }
 
@@ -1141,13 +1144,20 @@ func (s *adminServer) Drain(req *serverpb.DrainRequest, stream serverpb.Admin_Dr
 
 	_ = s.server.Undrain(off)
 
-	nowOn, err := s.server.Drain(on)
+	nowOn, drainRes, err := s.server.DrainWithOptions(on, DrainOptions{
+		ClientWait:    time.Duration(req.ClientDrainWait),
+		JobsWait:      time.Duration(req.JobsDrainWait),
+		LeaseStrategy: serverpb.DrainStrategy(req.Strategy),
+	})
 	if err != nil {
 		return err
 	}
 
 	res := serverpb.DrainResponse{
-		On: make([]int32, len(nowOn)),
+		On:              make([]int32, len(nowOn)),
+		ClientsCanceled: int32(drainRes.ClientsCanceled),
+		JobsWaited:      drainRes.JobsWaited,
+		JobsTimedOut:    drainRes.JobsTimedOut,
 	}
 	for i := range nowOn {
 		res.On[i] = int32(nowOn[i])
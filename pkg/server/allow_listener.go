@@ -0,0 +1,62 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// allowListener is a net.Listener which closes the connections that don't
+// come from the networks in its allow list, as configured with
+// Config.InsecureAllow.
+type allowListener struct {
+	net.Listener
+	ctx   context.Context
+	allow InsecureAllowList
+}
+
+// Accept implements the net.Listener interface.
+func (l *allowListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || l.allow.Allows(addr.IP) {
+			return conn, nil
+		}
+		log.Infof(l.ctx, "rejecting connection from %s, which is not in the insecure allow list",
+			conn.RemoteAddr())
+		_ = conn.Close()
+	}
+}
+
+// maybeRestrictListener wraps ln so that it only accepts the connections
+// allowed by s.cfg.InsecureAllow, if set, when running in insecure mode.
+func (s *Server) maybeRestrictListener(ln net.Listener) net.Listener {
+	if !s.cfg.Insecure || len(s.cfg.InsecureAllow) == 0 {
+		return ln
+	}
+	return &allowListener{
+		Listener: ln,
+		ctx:      s.AnnotateCtx(context.Background()),
+		allow:    s.cfg.InsecureAllow,
+	}
+}
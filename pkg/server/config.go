@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"math"
 	"net"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -49,6 +50,9 @@ import (
 // Context defaults.
 const (
 	defaultCGroupMemPath = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+	// defaultCGroupV2MemPath is where the memory limit lives when the unified
+	// (v2) cgroup hierarchy is mounted. A value of "max" means no limit.
+	defaultCGroupV2MemPath = "/sys/fs/cgroup/memory.max"
 	// DefaultCacheSize is the default size of the RocksDB cache. We default the
 	// cache size and SQL memory pool size to 128 MiB. Larger values might
 	// provide significantly better performance, but we're not sure what type of
@@ -114,6 +118,72 @@ func (mo *MaxOffsetType) String() string {
 	return time.Duration(*mo).String()
 }
 
+// InsecureAllowList is the list of networks from which a node running in
+// insecure mode accepts connections. An empty list accepts connections from
+// anywhere.
+type InsecureAllowList []*net.IPNet
+
+// Type implements the pflag.Value interface.
+func (l *InsecureAllowList) Type() string {
+	return "CIDRs"
+}
+
+// Set implements the pflag.Value interface. It accepts a comma-separated
+// list of networks in CIDR notation, or of single IP addresses, and appends
+// them to the list.
+func (l *InsecureAllowList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return errors.Errorf("invalid IP address or network: %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			*l = append(*l, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return errors.Errorf("invalid IP address or network: %q", s)
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+// String implements the pflag.Value interface.
+func (l *InsecureAllowList) String() string {
+	return strings.Join(l.Strings(), ",")
+}
+
+// Strings returns the networks in the list in CIDR notation.
+func (l InsecureAllowList) Strings() []string {
+	s := make([]string, len(l))
+	for i, n := range l {
+		s[i] = n.String()
+	}
+	return s
+}
+
+// Allows returns whether connections from ip are accepted. Connections from
+// the loopback interface are always accepted, so that the node can still be
+// administered locally.
+func (l InsecureAllowList) Allows(ip net.IP) bool {
+	if len(l) == 0 || ip.IsLoopback() {
+		return true
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // Config holds parameters needed to setup a server.
 type Config struct {
 	// Embed the base context.
@@ -226,6 +296,11 @@ type Config struct {
 	// it is ready.
 	PIDFile string
 
+	// InsecureAllow, if not empty, restricts the clients and nodes from which
+	// a node running in insecure mode accepts connections to those in the
+	// given networks. It is only valid in insecure mode.
+	InsecureAllow InsecureAllowList
+
 	// EnableWebSessionAuthentication enables session-based authentication for
 	// the Admin API's HTTP endpoints.
 	EnableWebSessionAuthentication bool
@@ -263,6 +338,29 @@ func (cfg Config) HistogramWindowInterval() time.Duration {
 // GetTotalMemory returns either the total system memory or if possible the
 // cgroups available memory.
 func GetTotalMemory(ctx context.Context) (int64, error) {
+	total, _, err := getTotalMemory(ctx)
+	return total, err
+}
+
+// DescribeTotalMemory describes the memory returned by GetTotalMemory and
+// where it comes from, so that a node can report it once logging is set up.
+// GetTotalMemory itself stays quiet when it succeeds, since it is also used
+// to resolve flags like --cache=25% before that.
+func DescribeTotalMemory(ctx context.Context) (string, error) {
+	total, systemMem, err := getTotalMemory(ctx)
+	if err != nil {
+		return "", err
+	}
+	if total < systemMem {
+		return fmt.Sprintf("%s, from the cgroups memory limit (system memory %s)",
+			humanizeutil.IBytes(total), humanizeutil.IBytes(systemMem)), nil
+	}
+	return fmt.Sprintf("%s of system memory", humanizeutil.IBytes(total)), nil
+}
+
+// getTotalMemory implements GetTotalMemory, and also returns the total
+// system memory.
+func getTotalMemory(ctx context.Context) (total, systemMem int64, _ error) {
 	totalMem, err := func() (int64, error) {
 		mem := gosigar.Mem{}
 		if err := mem.Get(); err != nil {
@@ -275,32 +373,39 @@ func GetTotalMemory(ctx context.Context) (int64, error) {
 		return int64(mem.Total), nil
 	}()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-	checkTotal := func(x int64) (int64, error) {
+	checkTotal := func(x int64) (int64, int64, error) {
 		if x <= 0 {
 			// https://github.com/elastic/gosigar/issues/72
-			return 0, fmt.Errorf("inferred memory size %d is suspicious, considering invalid", x)
+			return 0, 0, fmt.Errorf("inferred memory size %d is suspicious, considering invalid", x)
 		}
-		return x, nil
+		return x, totalMem, nil
 	}
 	if runtime.GOOS != "linux" {
 		return checkTotal(totalMem)
 	}
 
-	var buf []byte
-	if buf, err = ioutil.ReadFile(defaultCGroupMemPath); err != nil {
+	buf, err := ioutil.ReadFile(defaultCGroupMemPath)
+	if os.IsNotExist(err) {
+		// Fall back to the unified hierarchy used by cgroups v2.
+		buf, err = ioutil.ReadFile(defaultCGroupV2MemPath)
+	}
+	if err != nil {
 		log.Infof(ctx, "can't read available memory from cgroups (%s), using system memory %s instead", err,
 			humanizeutil.IBytes(totalMem))
 		return checkTotal(totalMem)
 	}
 
-	cgAvlMem, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	cgAvlMem, limited, err := parseCGroupMemLimit(string(buf))
 	if err != nil {
 		log.Infof(ctx, "can't parse available memory from cgroups (%s), using system memory %s instead", err,
 			humanizeutil.IBytes(totalMem))
 		return checkTotal(totalMem)
 	}
+	if !limited {
+		return checkTotal(totalMem)
+	}
 
 	if cgAvlMem == 0 || cgAvlMem > math.MaxInt64 {
 		log.Infof(ctx, "available memory from cgroups (%s) is unsupported, using system memory %s instead",
@@ -317,6 +422,21 @@ func GetTotalMemory(ctx context.Context) (int64, error) {
 	return checkTotal(int64(cgAvlMem))
 }
 
+// parseCGroupMemLimit parses the contents of a cgroups memory limit file,
+// either memory.limit_in_bytes (v1) or memory.max (v2). limited is false if
+// the file sets no limit, which cgroups v2 spells "max".
+func parseCGroupMemLimit(contents string) (limit uint64, limited bool, _ error) {
+	contents = strings.TrimSpace(contents)
+	if contents == "max" {
+		return 0, false, nil
+	}
+	limit, err := strconv.ParseUint(contents, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return limit, true, nil
+}
+
 // setOpenFileLimit sets the soft limit for open file descriptors to the hard
 // limit if needed. Returns an error if the hard limit is too low. Returns the
 // value to set maxOpenFiles to for each store.
@@ -396,11 +516,77 @@ func (cfg *Config) String() string {
 	if cfg.PIDFile != "" {
 		fmt.Fprintln(w, "PID file\t", cfg.PIDFile)
 	}
+	if len(cfg.InsecureAllow) > 0 {
+		fmt.Fprintln(w, "insecure allow\t", cfg.InsecureAllow.String())
+	}
 	_ = w.Flush()
 
 	return buf.String()
 }
 
+// ReportedConfig is a structured overview of the server configuration
+// parameters, suitable for comparing the effective configuration of nodes.
+// Durations are rendered as strings and sizes in bytes.
+type ReportedConfig struct {
+	Addr                     string   `json:"addr"`
+	AdvertiseAddr            string   `json:"advertise_addr"`
+	HTTPAddr                 string   `json:"http_addr"`
+	Insecure                 bool     `json:"insecure"`
+	JoinList                 []string `json:"join,omitempty"`
+	Stores                   []string `json:"stores"`
+	Attrs                    string   `json:"attrs,omitempty"`
+	Locality                 string   `json:"locality,omitempty"`
+	MaxOffset                string   `json:"max_offset"`
+	CacheSize                int64    `json:"cache_size"`
+	SQLMemoryPoolSize        int64    `json:"sql_memory_pool_size"`
+	TempStorageMaxSize       int64    `json:"temp_storage_max_size"`
+	ScanInterval             string   `json:"scan_interval"`
+	ScanMaxIdleTime          string   `json:"scan_max_idle_time"`
+	MetricsSampleInterval    string   `json:"metrics_sample_interval"`
+	RaftTickInterval         string   `json:"raft_tick_interval"`
+	RaftElectionTimeoutTicks int      `json:"raft_election_timeout_ticks"`
+	EventLogEnabled          bool     `json:"event_log_enabled"`
+	Linearizable             bool     `json:"linearizable"`
+	ListeningURLFile         string   `json:"listening_url_file,omitempty"`
+	PIDFile                  string   `json:"pid_file,omitempty"`
+	InsecureAllow            []string `json:"insecure_allow,omitempty"`
+}
+
+// Reported returns the structured overview of the configuration.
+func (cfg *Config) Reported() ReportedConfig {
+	rc := ReportedConfig{
+		Addr:                     cfg.Addr,
+		AdvertiseAddr:            cfg.AdvertiseAddr,
+		HTTPAddr:                 cfg.HTTPAddr,
+		Insecure:                 cfg.Insecure,
+		JoinList:                 []string(cfg.JoinList),
+		Attrs:                    cfg.Attrs,
+		MaxOffset:                cfg.MaxOffset.String(),
+		CacheSize:                cfg.CacheSize,
+		SQLMemoryPoolSize:        cfg.SQLMemoryPoolSize,
+		TempStorageMaxSize:       cfg.TempStorageConfig.MaxSizeBytes,
+		ScanInterval:             cfg.ScanInterval.String(),
+		ScanMaxIdleTime:          cfg.ScanMaxIdleTime.String(),
+		MetricsSampleInterval:    cfg.MetricsSampleInterval.String(),
+		RaftTickInterval:         cfg.RaftTickInterval.String(),
+		RaftElectionTimeoutTicks: cfg.RaftElectionTimeoutTicks,
+		EventLogEnabled:          cfg.EventLogEnabled,
+		Linearizable:             cfg.Linearizable,
+		ListeningURLFile:         cfg.ListeningURLFile,
+		PIDFile:                  cfg.PIDFile,
+	}
+	for _, spec := range cfg.Stores.Specs {
+		rc.Stores = append(rc.Stores, spec.String())
+	}
+	if len(cfg.Locality.Tiers) > 0 {
+		rc.Locality = cfg.Locality.String()
+	}
+	if len(cfg.InsecureAllow) > 0 {
+		rc.InsecureAllow = cfg.InsecureAllow.Strings()
+	}
+	return rc
+}
+
 // Report logs an overview of the server configuration parameters via
 // the given context.
 func (cfg *Config) Report(ctx context.Context) {
@@ -15,6 +15,7 @@
 package server
 
 import (
+	"net"
 	"os"
 	"reflect"
 	"testing"
@@ -27,6 +28,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/gossip/resolver"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
@@ -51,6 +53,67 @@ func TestParseInitNodeAttributes(t *testing.T) {
 	}
 }
 
+func TestReportedConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	cfg := MakeConfig(context.TODO(), cluster.MakeTestingClusterSettings())
+	cfg.JoinList = []string{"localhost:12345"}
+	cfg.MaxOffset = MaxOffsetType(250 * time.Millisecond)
+	cfg.Stores = base.StoreSpecList{Specs: []base.StoreSpec{{InMemory: true, SizeInBytes: base.MinimumStoreSize * 100}}}
+
+	rc := cfg.Reported()
+	if e := []string{"localhost:12345"}; !reflect.DeepEqual(rc.JoinList, e) {
+		t.Errorf("expected join list %v, found %v", e, rc.JoinList)
+	}
+	if e := []string{cfg.Stores.Specs[0].String()}; !reflect.DeepEqual(rc.Stores, e) {
+		t.Errorf("expected stores %v, found %v", e, rc.Stores)
+	}
+	if e := "250ms"; rc.MaxOffset != e {
+		t.Errorf("expected max offset %s, found %s", e, rc.MaxOffset)
+	}
+	if rc.CacheSize != cfg.CacheSize || rc.ScanInterval != cfg.ScanInterval.String() {
+		t.Errorf("unexpected reported config %+v", rc)
+	}
+}
+
+func TestInsecureAllowList(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var l InsecureAllowList
+	if !l.Allows(net.ParseIP("8.8.8.8")) {
+		t.Error("expected an empty list to allow all addresses")
+	}
+	if err := l.Set("10.0.0.0/8, 192.168.1.7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set("fd00::/8"); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "10.0.0.0/8,192.168.1.7/32,fd00::/8", l.String(); e != a {
+		t.Errorf("expected %s, found %s", e, a)
+	}
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"fd00::1", true},
+		{"2001:db8::1", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+	} {
+		if a := l.Allows(net.ParseIP(tc.ip)); a != tc.allowed {
+			t.Errorf("%s: expected allowed=%t, found %t", tc.ip, tc.allowed, a)
+		}
+	}
+	for _, v := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		if err := l.Set(v); !testutils.IsError(err, "invalid IP address or network") {
+			t.Errorf("%q: expected an invalid address error, found %v", v, err)
+		}
+	}
+}
+
 // TestParseJoinUsingAddrs verifies that JoinList is parsed
 // correctly.
 func TestParseJoinUsingAddrs(t *testing.T) {
@@ -200,3 +263,33 @@ func TestFilterGossipBootstrapResolvers(t *testing.T) {
 		t.Fatalf("expected resolver to be %q; got %q", resolverSpecs[1], filtered[0].Addr())
 	}
 }
+
+func TestParseCGroupMemLimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		contents    string
+		limit       uint64
+		limited     bool
+		expectedErr string
+	}{
+		// cgroups v2 memory.max without a limit.
+		{"max\n", 0, false, ""},
+		{"2147483648\n", 2 << 30, true, ""},
+		{"9223372036854771712", 9223372036854771712, true, ""},
+		{"", 0, false, "invalid syntax"},
+		{"lots\n", 0, false, "invalid syntax"},
+		{"-1\n", 0, false, "invalid syntax"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.contents, func(t *testing.T) {
+			limit, limited, err := parseCGroupMemLimit(tc.contents)
+			if !testutils.IsError(err, tc.expectedErr) {
+				t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+			}
+			if limit != tc.limit || limited != tc.limited {
+				t.Fatalf("expected (%d, %t), got (%d, %t)", tc.limit, tc.limited, limit, limited)
+			}
+		})
+	}
+}
@@ -545,11 +545,22 @@ type grpcGatewayServer interface {
 }
 
 // ListenError is returned from Start when we fail to start listening on either
-// the main Cockroach port or the HTTP port, so that the CLI can instruct the
-// user on what might have gone wrong.
+// the main Cockroach port or the HTTP port, or when the advertised address
+// cannot be resolved, so that the CLI can instruct the user on what might have
+// gone wrong.
 type ListenError struct {
 	error
 	Addr string
+	// Advertise is set when the error concerns the advertised address rather
+	// than one of the addresses being listened on. The advertised address is
+	// often textually equal to the listen address, so Addr alone cannot
+	// distinguish the two.
+	Advertise bool
+}
+
+// Cause returns the error of the listener, so that errors.Cause can unwrap it.
+func (le ListenError) Cause() error {
+	return le.error
 }
 
 func inspectEngines(
@@ -679,6 +690,7 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}
 	log.Eventf(ctx, "listening on port %s", s.cfg.Addr)
+	ln = s.maybeRestrictListener(ln)
 	unresolvedListenAddr, err := officialAddr(ctx, s.cfg.Addr, ln.Addr(), os.Hostname)
 	if err != nil {
 		return err
@@ -686,7 +698,11 @@ func (s *Server) Start(ctx context.Context) error {
 	s.cfg.Addr = unresolvedListenAddr.String()
 	unresolvedAdvertAddr, err := officialAddr(ctx, s.cfg.AdvertiseAddr, ln.Addr(), os.Hostname)
 	if err != nil {
-		return err
+		return ListenError{
+			error:     err,
+			Addr:      s.cfg.AdvertiseAddr,
+			Advertise: true,
+		}
 	}
 	s.cfg.AdvertiseAddr = unresolvedAdvertAddr.String()
 
@@ -715,6 +731,7 @@ func (s *Server) Start(ctx context.Context) error {
 			Addr:  s.cfg.HTTPAddr,
 		}
 	}
+	httpLn = s.maybeRestrictListener(httpLn)
 	unresolvedHTTPAddr, err := officialAddr(ctx, s.cfg.HTTPAddr, httpLn.Addr(), os.Hostname)
 	if err != nil {
 		return err
@@ -1265,7 +1282,10 @@ If problems persist, please see ` + base.DocsURL("cluster-setup-troubleshooting.
 	return nil
 }
 
-func (s *Server) doDrain(modes []serverpb.DrainMode, setTo bool) ([]serverpb.DrainMode, error) {
+func (s *Server) doDrain(
+	modes []serverpb.DrainMode, setTo bool, opts DrainOptions,
+) ([]serverpb.DrainMode, DrainResult, error) {
+	var res DrainResult
 	for _, mode := range modes {
 		switch mode {
 		case serverpb.DrainMode_CLIENT:
@@ -1275,17 +1295,42 @@ func (s *Server) doDrain(modes []serverpb.DrainMode, setTo bool) ([]serverpb.Dra
 				// the pgServer has given sessions a chance to finish ongoing
 				// work.
 				defer s.leaseMgr.SetDraining(setTo)
-				return s.pgServer.SetDraining(setTo)
+				var err error
+				res.ClientsCanceled, err = s.pgServer.SetDrainingWithWait(setTo, opts.ClientWait)
+				return err
 			}(); err != nil {
-				return nil, err
+				return nil, res, err
 			}
 		case serverpb.DrainMode_LEASES:
+			if setTo && opts.LeaseStrategy != serverpb.DrainStrategy_FAST {
+				// The lease transfers aren't ordered according to the strategy
+				// yet: all the strategies transfer the leases as quickly as
+				// possible.
+				log.Infof(s.AnnotateCtx(context.TODO()),
+					"drain strategy %s is not supported yet, transferring leases with strategy %s",
+					opts.LeaseStrategy, serverpb.DrainStrategy_FAST)
+			}
 			s.nodeLiveness.SetDraining(context.TODO(), setTo)
 			if err := s.node.SetDraining(setTo); err != nil {
-				return nil, err
+				return nil, res, err
+			}
+		case serverpb.DrainMode_JOBS:
+			// Waiting for checkpoints is a one-off which leaves no state
+			// behind, so there's nothing to undo.
+			if !setTo {
+				continue
+			}
+			wait := opts.JobsWait
+			if wait == 0 {
+				wait = defaultJobsDrainWait
+			}
+			ctx := s.AnnotateCtx(context.TODO())
+			res.JobsWaited, res.JobsTimedOut = s.jobRegistry.WaitForCheckpoints(ctx, wait)
+			if len(res.JobsTimedOut) > 0 {
+				log.Warningf(ctx, "jobs %v did not checkpoint within %s", res.JobsTimedOut, wait)
 			}
 		default:
-			return nil, errors.Errorf("unknown drain mode: %s", mode)
+			return nil, res, errors.Errorf("unknown drain mode: %s", mode)
 		}
 	}
 	var nowOn []serverpb.DrainMode
@@ -1295,7 +1340,32 @@ func (s *Server) doDrain(modes []serverpb.DrainMode, setTo bool) ([]serverpb.Dra
 	if s.node.IsDraining() {
 		nowOn = append(nowOn, serverpb.DrainMode_LEASES)
 	}
-	return nowOn, nil
+	return nowOn, res, nil
+}
+
+// defaultJobsDrainWait is the time the JOBS drain mode waits for jobs to
+// checkpoint unless DrainOptions.JobsWait says otherwise.
+const defaultJobsDrainWait = 10 * time.Second
+
+// DrainOptions tunes how DrainWithOptions carries out the drain modes.
+type DrainOptions struct {
+	// ClientWait is the time SQL sessions are given to finish before they are
+	// canceled by the CLIENT mode. Zero means the default.
+	ClientWait time.Duration
+	// JobsWait bounds the time the JOBS mode waits for jobs to checkpoint.
+	// Zero means defaultJobsDrainWait.
+	JobsWait time.Duration
+	// LeaseStrategy hints how the LEASES mode orders the lease transfers.
+	LeaseStrategy serverpb.DrainStrategy
+}
+
+// DrainResult reports on the work done by DrainWithOptions.
+type DrainResult struct {
+	// ClientsCanceled is the number of SQL sessions that had to be canceled.
+	ClientsCanceled int
+	// JobsWaited lists the IDs of the jobs that were waited on to checkpoint,
+	// and JobsTimedOut those among them that didn't in time.
+	JobsWaited, JobsTimedOut []int64
 }
 
 // Drain idempotently activates the given DrainModes on the Server in the order
@@ -1306,14 +1376,23 @@ func (s *Server) doDrain(modes []serverpb.DrainMode, setTo bool) ([]serverpb.Dra
 // On failure, the system may be in a partially drained state and should be
 // recovered by calling Undrain() with the same (or a larger) slice of modes.
 func (s *Server) Drain(on []serverpb.DrainMode) ([]serverpb.DrainMode, error) {
-	return s.doDrain(on, true)
+	nowOn, _, err := s.DrainWithOptions(on, DrainOptions{})
+	return nowOn, err
+}
+
+// DrainWithOptions is like Drain, but allows tuning the drain modes through
+// opts. It additionally reports on the work done.
+func (s *Server) DrainWithOptions(
+	on []serverpb.DrainMode, opts DrainOptions,
+) ([]serverpb.DrainMode, DrainResult, error) {
+	return s.doDrain(on, true, opts)
 }
 
 // Undrain idempotently deactivates the given DrainModes on the Server in the
 // order in which they are supplied.
 // On success, returns any remaining active drain modes.
 func (s *Server) Undrain(off []serverpb.DrainMode) []serverpb.DrainMode {
-	nowActive, err := s.doDrain(off, false)
+	nowActive, _, err := s.doDrain(off, false, DrainOptions{})
 	if err != nil {
 		panic(fmt.Sprintf("error returned to Undrain: %s", err))
 	}
@@ -188,7 +188,7 @@ func (j *Job) Progressed(
 			fractionCompleted, j.id,
 		)
 	}
-	return j.update(ctx, func(_ *client.Txn, status *Status, payload *Payload) (bool, error) {
+	if err := j.update(ctx, func(_ *client.Txn, status *Status, payload *Payload) (bool, error) {
 		if *status != StatusRunning {
 			return false, &InvalidStatusError{*j.id, *status, "update progress on"}
 		}
@@ -199,7 +199,13 @@ func (j *Job) Progressed(
 			progressedFn(ctx, payload.Details)
 		}
 		return true, nil
-	})
+	}); err != nil {
+		return err
+	}
+	// The progress was persisted, which is a checkpoint as far as a draining
+	// node is concerned.
+	j.registry.notifyCheckpoint(*j.id)
+	return nil
 }
 
 func isControllable(p *Payload, op string) error {
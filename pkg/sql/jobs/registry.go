@@ -16,6 +16,7 @@ package jobs
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -57,6 +58,10 @@ type Registry struct {
 		syncutil.Mutex
 		epoch int64
 		jobs  map[int64]*Job
+		// checkpointWaiters maps the ID of a running job to the channels to
+		// close once the job checkpoints its progress or stops running. See
+		// WaitForCheckpoints.
+		checkpointWaiters map[int64][]chan struct{}
 	}
 }
 
@@ -81,6 +86,7 @@ func MakeRegistry(
 	}
 	r.mu.epoch = 1
 	r.mu.jobs = make(map[int64]*Job)
+	r.mu.checkpointWaiters = make(map[int64][]chan struct{})
 	return r
 }
 
@@ -339,10 +345,84 @@ func (r *Registry) cancelAll(ctx context.Context) {
 	for jobID, job := range r.mu.jobs {
 		log.Warningf(ctx, "job %d: canceling due to liveness failure", jobID)
 		job.cancel()
+		r.notifyCheckpointLocked(jobID)
 	}
 	r.mu.jobs = make(map[int64]*Job)
 }
 
+// WaitForCheckpoints waits, for at most timeout, for each of the jobs running
+// on this node to checkpoint its progress or to stop running. It returns the
+// IDs of the jobs it waited on and of those among them that didn't checkpoint
+// in time, in ascending order. It is used when draining the node to reduce
+// the work lost by jobs that are resumed elsewhere.
+func (r *Registry) WaitForCheckpoints(
+	ctx context.Context, timeout time.Duration,
+) (waited, timedOut []int64) {
+	waiters := make(map[int64]chan struct{})
+	r.mu.Lock()
+	for jobID := range r.mu.jobs {
+		ch := make(chan struct{})
+		waiters[jobID] = ch
+		r.mu.checkpointWaiters[jobID] = append(r.mu.checkpointWaiters[jobID], ch)
+		waited = append(waited, jobID)
+	}
+	r.mu.Unlock()
+	sort.Slice(waited, func(i, j int) bool { return waited[i] < waited[j] })
+
+	deadline := time.After(timeout)
+	for _, jobID := range waited {
+		select {
+		case <-waiters[jobID]:
+			continue
+		case <-deadline:
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, jobID := range waited {
+		ch := waiters[jobID]
+		select {
+		case <-ch:
+			continue
+		default:
+		}
+		timedOut = append(timedOut, jobID)
+		// Stop being notified about the job.
+		chans := r.mu.checkpointWaiters[jobID]
+		for i := range chans {
+			if chans[i] == ch {
+				chans = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+		if len(chans) == 0 {
+			delete(r.mu.checkpointWaiters, jobID)
+		} else {
+			r.mu.checkpointWaiters[jobID] = chans
+		}
+	}
+	return waited, timedOut
+}
+
+// notifyCheckpoint wakes up the WaitForCheckpoints callers waiting on the job
+// with the given ID.
+func (r *Registry) notifyCheckpoint(jobID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifyCheckpointLocked(jobID)
+}
+
+func (r *Registry) notifyCheckpointLocked(jobID int64) {
+	r.mu.AssertHeld()
+	for _, ch := range r.mu.checkpointWaiters[jobID] {
+		close(ch)
+	}
+	delete(r.mu.checkpointWaiters, jobID)
+}
+
 func (r *Registry) newLease() *Lease {
 	nodeID := r.nodeID.Get()
 	if nodeID == 0 {
@@ -375,4 +455,5 @@ func (r *Registry) unregister(jobID int64) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.mu.jobs, jobID)
+	r.notifyCheckpointLocked(jobID)
 }
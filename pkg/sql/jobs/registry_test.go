@@ -16,6 +16,7 @@ package jobs
 
 import (
 	"math"
+	"reflect"
 	"testing"
 	"time"
 
@@ -143,3 +144,54 @@ func TestRegistryRegister(t *testing.T) {
 	registry.unregister(42)
 	registry.unregister(42)
 }
+
+func TestRegistryWaitForCheckpoints(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	var db *client.DB
+	var ex sqlutil.InternalExecutor
+	var gossip *gossip.Gossip
+	clock := hlc.NewClock(hlc.UnixNano, time.Nanosecond)
+	registry := MakeRegistry(clock, db, ex, gossip, FakeNodeID, FakeClusterID, cluster.NoSettings)
+
+	// Without running jobs, there is nothing to wait for.
+	if waited, timedOut := registry.WaitForCheckpoints(ctx, time.Hour); len(waited) != 0 || len(timedOut) != 0 {
+		t.Fatalf("expected no jobs, got waited=%v timedOut=%v", waited, timedOut)
+	}
+
+	for _, id := range []int64{3, 1, 2} {
+		if err := registry.register(id, &Job{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Job 1 checkpoints and job 2 finishes while the node drains; job 3 does
+	// neither by the time the drain is given up on.
+	drainCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		for {
+			registry.mu.Lock()
+			n := len(registry.mu.checkpointWaiters)
+			registry.mu.Unlock()
+			if n == 3 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		registry.notifyCheckpoint(1)
+		registry.unregister(2)
+		cancel()
+	}()
+	waited, timedOut := registry.WaitForCheckpoints(drainCtx, time.Hour)
+	if e := []int64{1, 2, 3}; !reflect.DeepEqual(e, waited) {
+		t.Errorf("expected to wait on %v, but waited on %v", e, waited)
+	}
+	if e := []int64{3}; !reflect.DeepEqual(e, timedOut) {
+		t.Errorf("expected %v to time out, but %v did", e, timedOut)
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if n := len(registry.mu.checkpointWaiters); n != 0 {
+		t.Errorf("expected the waiters to be cleaned up, found %d", n)
+	}
+}
@@ -23,7 +23,8 @@ import (
 func (s *Server) SetDrainingImpl(
 	drain bool, drainWait time.Duration, cancelWait time.Duration,
 ) error {
-	return s.setDrainingImpl(drain, drainWait, cancelWait)
+	_, err := s.setDrainingImpl(drain, drainWait, cancelWait)
+	return err
 }
 
 // OverwriteCancelMap overwrites all active connections' context.CancelFuncs so
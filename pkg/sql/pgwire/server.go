@@ -221,12 +221,23 @@ func (s *Server) Metrics() *ServerMetrics {
 // what will happen to connections in different states:
 // https://github.com/cockroachdb/cockroach/blob/master/docs/RFCS/20160425_drain_modes.md
 func (s *Server) SetDraining(drain bool) error {
-	return s.setDrainingImpl(drain, drainMaxWait, cancelMaxWait)
+	_, err := s.setDrainingImpl(drain, drainMaxWait, cancelMaxWait)
+	return err
+}
+
+// SetDrainingWithWait is like SetDraining, but gives sessions drainWait
+// (instead of the default) to finish before canceling them. A zero drainWait
+// uses the default. Returns the number of sessions that had to be canceled.
+func (s *Server) SetDrainingWithWait(drain bool, drainWait time.Duration) (int, error) {
+	if drainWait == 0 {
+		drainWait = drainMaxWait
+	}
+	return s.setDrainingImpl(drain, drainWait, cancelMaxWait)
 }
 
 func (s *Server) setDrainingImpl(
 	drain bool, drainWait time.Duration, cancelWait time.Duration,
-) error {
+) (int, error) {
 	// This anonymous function returns a copy of s.mu.connCancelMap if there are
 	// any active connections to cancel. We will only attempt to cancel
 	// connections that were active at the moment the draining switch happened.
@@ -255,7 +266,7 @@ func (s *Server) setDrainingImpl(
 		return connCancelMap
 	}()
 	if len(connCancelMap) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	// Spin off a goroutine that waits for all connections to signal that they
@@ -282,14 +293,20 @@ func (s *Server) setDrainingImpl(
 	}
 
 	// Cancel the contexts of all sessions if the server is still in draining
-	// mode.
+	// mode. Sessions which are already done by now don't count as canceled.
+	var canceled int
 	if stop := func() bool {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 		if !s.mu.draining {
 			return true
 		}
-		for _, cancel := range connCancelMap {
+		for done, cancel := range connCancelMap {
+			select {
+			case <-done:
+			default:
+				canceled++
+			}
 			// There is a possibility that different calls to SetDraining have
 			// overlapping connCancelMaps, but context.CancelFunc calls are
 			// idempotent.
@@ -297,15 +314,15 @@ func (s *Server) setDrainingImpl(
 		}
 		return false
 	}(); stop {
-		return nil
+		return 0, nil
 	}
 
 	select {
 	case <-time.After(cancelWait):
-		return errors.Errorf("some sessions did not respond to cancellation within %s", cancelWait)
+		return canceled, errors.Errorf("some sessions did not respond to cancellation within %s", cancelWait)
 	case <-allConnsDone:
 	}
-	return nil
+	return canceled, nil
 }
 
 // ServeConn serves a single connection, driving the handshake process
@@ -159,6 +159,7 @@ func TestLint(t *testing.T) {
 					":!util/log/clog.go",
 					":!util/log/color.go",
 					":!util/sdnotify/sdnotify_unix.go",
+					":!util/sdnotify/sdnotify_unix_test.go",
 				},
 			},
 		} {
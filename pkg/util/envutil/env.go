@@ -19,7 +19,9 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -32,6 +34,9 @@ type envVarInfo struct {
 	consumer string
 	present  bool
 	value    string
+	// defaultValue is the value used when the variable is not set, or empty
+	// if there is none.
+	defaultValue string
 }
 
 var envVarRegistry struct {
@@ -64,7 +69,7 @@ func checkVarName(name string) {
 // The bookkeeping enables a report of all influential environment
 // variables with "cockroach debug env". To keep this report useful,
 // all relevant environment variables should be read during start up.
-func getEnv(varName string, depth int) (string, bool) {
+func getEnv(varName string, defaultValue string, depth int) (string, bool) {
 	_, consumer, _, _ := runtime.Caller(depth + 1)
 	checkVarName(varName)
 
@@ -78,7 +83,9 @@ func getEnv(varName string, depth int) (string, bool) {
 		return f.value, f.present
 	}
 	v, found := os.LookupEnv(varName)
-	envVarRegistry.cache[varName] = envVarInfo{consumer: consumer, present: found, value: v}
+	envVarRegistry.cache[varName] = envVarInfo{
+		consumer: consumer, present: found, value: v, defaultValue: defaultValue,
+	}
 	return v, found
 }
 
@@ -91,20 +98,63 @@ func ClearEnvCache() {
 	envVarRegistry.cache = make(map[string]envVarInfo)
 }
 
+// sensitiveVarNameParts are the parts of the names of environment variables
+// whose values GetEnvReport redacts.
+var sensitiveVarNameParts = []string{"PASSWORD", "SECRET", "TOKEN", "CREDENTIAL"}
+
+// redactEnvValue returns value, or a placeholder if the environment variable
+// name may hold a secret.
+func redactEnvValue(name, value string) string {
+	for _, part := range sensitiveVarNameParts {
+		if strings.Contains(name, part) {
+			return "<redacted>"
+		}
+	}
+	return value
+}
+
 // GetEnvReport dumps all configuration variables that may have been
-// used and their value.
+// used, sorted by name, with their value and their default. Values that may
+// be secrets are redacted. COCKROACH_* variables that are set but haven't
+// been read are listed last, since they may be misspelled.
 func GetEnvReport() string {
 	envVarRegistry.mu.Lock()
 	defer envVarRegistry.mu.Unlock()
 
+	names := make([]string, 0, len(envVarRegistry.cache))
+	for k := range envVarRegistry.cache {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
 	var b bytes.Buffer
-	for k, v := range envVarRegistry.cache {
+	for _, k := range names {
+		v := envVarRegistry.cache[k]
+		def := ""
+		if v.defaultValue != "" {
+			def = ", default " + redactEnvValue(k, v.defaultValue)
+		}
 		if v.present {
-			fmt.Fprintf(&b, "%s = %s # %s\n", k, v.value, v.consumer)
+			fmt.Fprintf(&b, "%s = %s # %s%s\n", k, redactEnvValue(k, v.value), v.consumer, def)
 		} else {
-			fmt.Fprintf(&b, "# %s is not set (read from %s)\n", k, v.consumer)
+			fmt.Fprintf(&b, "# %s is not set (read from %s%s)\n", k, v.consumer, def)
 		}
 	}
+
+	var unused []string
+	for _, kv := range os.Environ() {
+		k := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			k = kv[:i]
+		}
+		if _, ok := envVarRegistry.cache[k]; !ok && strings.HasPrefix(k, "COCKROACH_") {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	for _, k := range unused {
+		fmt.Fprintf(&b, "# %s = %s is set but not used\n", k, redactEnvValue(k, os.Getenv(k)))
+	}
 	return b.String()
 }
 
@@ -158,19 +208,33 @@ func HomeDir() (string, error) {
 	return userAcct.HomeDir, nil
 }
 
+// ConfigHomeDir returns the base directory of the user's configuration
+// files, as determined by the env var XDG_CONFIG_HOME, if it exists, and
+// otherwise the .config directory in HomeDir.
+func ConfigHomeDir() (string, error) {
+	if configDir := os.Getenv("XDG_CONFIG_HOME"); len(configDir) > 0 {
+		return configDir, nil
+	}
+	homeDir, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config"), nil
+}
+
 // EnvString returns the value set by the specified environment variable. The
 // depth argument indicates the stack depth of the caller that should be
 // associated with the variable.
 // The returned boolean flag indicates if the variable is set.
 func EnvString(name string, depth int) (string, bool) {
-	return getEnv(name, depth+1)
+	return getEnv(name, "", depth+1)
 }
 
 // EnvOrDefaultString returns the value set by the specified
 // environment variable, if any, otherwise the specified default
 // value.
 func EnvOrDefaultString(name string, value string) string {
-	if v, present := getEnv(name, 1); present {
+	if v, present := getEnv(name, value, 1); present {
 		return v
 	}
 	return value
@@ -179,7 +243,7 @@ func EnvOrDefaultString(name string, value string) string {
 // EnvOrDefaultBool returns the value set by the specified environment
 // variable, if any, otherwise the specified default value.
 func EnvOrDefaultBool(name string, value bool) bool {
-	if str, present := getEnv(name, 1); present {
+	if str, present := getEnv(name, strconv.FormatBool(value), 1); present {
 		v, err := strconv.ParseBool(str)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing %s: %s", name, err))
@@ -192,7 +256,7 @@ func EnvOrDefaultBool(name string, value bool) bool {
 // EnvOrDefaultInt returns the value set by the specified environment
 // variable, if any, otherwise the specified default value.
 func EnvOrDefaultInt(name string, value int) int {
-	if str, present := getEnv(name, 1); present {
+	if str, present := getEnv(name, strconv.Itoa(value), 1); present {
 		v, err := strconv.ParseInt(str, 0, 0)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing %s: %s", name, err))
@@ -205,7 +269,7 @@ func EnvOrDefaultInt(name string, value int) int {
 // EnvOrDefaultInt64 returns the value set by the specified environment
 // variable, if any, otherwise the specified default value.
 func EnvOrDefaultInt64(name string, value int64) int64 {
-	if str, present := getEnv(name, 1); present {
+	if str, present := getEnv(name, strconv.FormatInt(value, 10), 1); present {
 		v, err := strconv.ParseInt(str, 0, 64)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing %s: %s", name, err))
@@ -218,7 +282,7 @@ func EnvOrDefaultInt64(name string, value int64) int64 {
 // EnvOrDefaultBytes returns the value set by the specified environment
 // variable, if any, otherwise the specified default value.
 func EnvOrDefaultBytes(name string, value int64) int64 {
-	if str, present := getEnv(name, 1); present {
+	if str, present := getEnv(name, humanizeutil.IBytes(value), 1); present {
 		v, err := humanizeutil.ParseBytes(str)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing %s: %s", name, err))
@@ -231,7 +295,7 @@ func EnvOrDefaultBytes(name string, value int64) int64 {
 // EnvOrDefaultDuration returns the value set by the specified environment
 // variable, if any, otherwise the specified default value.
 func EnvOrDefaultDuration(name string, value time.Duration) time.Duration {
-	if str, present := getEnv(name, 1); present {
+	if str, present := getEnv(name, value.String(), 1); present {
 		v, err := time.ParseDuration(str)
 		if err != nil {
 			panic(fmt.Sprintf("error parsing %s: %s", name, err))
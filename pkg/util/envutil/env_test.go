@@ -16,7 +16,9 @@ package envutil
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEnvOrDefault(t *testing.T) {
@@ -31,3 +33,52 @@ func TestEnvOrDefault(t *testing.T) {
 		t.Errorf("expected %d, got %d", def, act)
 	}
 }
+
+func TestGetEnvReport(t *testing.T) {
+	// Other COCKROACH_ variables in the environment would be reported as
+	// unused, so the test runs in an empty environment, which is restored for
+	// the tests that follow.
+	defer func(env []string) {
+		os.Clearenv()
+		for _, kv := range env {
+			if i := strings.IndexByte(kv, '='); i > 0 {
+				_ = os.Setenv(kv[:i], kv[i+1:])
+			}
+		}
+	}(os.Environ())
+	os.Clearenv()
+	ClearEnvCache()
+	defer ClearEnvCache()
+	if err := os.Setenv("COCKROACH_B", "2s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv("COCKROACH_A_PASSWORD", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv("COCKROACH_TYPO", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = EnvOrDefaultDuration("COCKROACH_B", time.Second)
+	_ = EnvOrDefaultInt("COCKROACH_C", 3)
+	_ = EnvOrDefaultString("COCKROACH_A_PASSWORD", "")
+
+	lines := strings.Split(strings.TrimSuffix(GetEnvReport(), "\n"), "\n")
+	expected := []string{
+		"COCKROACH_A_PASSWORD = <redacted> #",
+		"COCKROACH_B = 2s #",
+		"# COCKROACH_C is not set (read from ",
+		"# COCKROACH_TYPO = 1 is set but not used",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, but found:\n%s", len(expected), strings.Join(lines, "\n"))
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(lines[i], e) {
+			t.Errorf("%d: expected %q to start with %q", i, lines[i], e)
+		}
+	}
+	if !strings.HasSuffix(lines[1], ", default 1s") || !strings.HasSuffix(lines[2], ", default 3)") {
+		t.Errorf("expected defaults in:\n%s", strings.Join(lines, "\n"))
+	}
+}
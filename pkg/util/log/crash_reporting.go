@@ -21,6 +21,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -114,8 +115,21 @@ func Safe(v interface{}) SafeType {
 	return SafeType{V: v}
 }
 
+// panicHook holds the func() registered through SetPanicHook, if any.
+var panicHook atomic.Value
+
+// SetPanicHook registers a function that ReportPanic calls before reporting a
+// panic, e.g. to capture the state of the process at the time of the crash.
+// The function must not panic.
+func SetPanicHook(fn func()) {
+	panicHook.Store(fn)
+}
+
 // ReportPanic reports a panic has occurred on the real stderr.
 func ReportPanic(ctx context.Context, sv *settings.Values, r interface{}, depth int) {
+	if fn, ok := panicHook.Load().(func()); ok {
+		fn()
+	}
 	Shout(ctx, Severity_ERROR, "a panic has occurred!")
 
 	SendCrashReport(ctx, sv, depth+1, "", []interface{}{r})
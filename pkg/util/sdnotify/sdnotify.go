@@ -19,7 +19,15 @@
 // sdnotify.Exec() to run processes that implement this protocol.
 package sdnotify
 
-import "os/exec"
+import (
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// ErrExitedBeforeReady is returned by Exec when the command exits
+// successfully without having signaled readiness.
+var ErrExitedBeforeReady = errors.New("process exited before signaling readiness")
 
 // Ready sends a readiness signal using the systemd notification
 // protocol. It should be called (once) by a server after it has
@@ -29,11 +37,27 @@ func Ready() error {
 	return ready()
 }
 
+// Notify sends the given state, e.g. STOPPING=1, using the systemd
+// notification protocol. Like Ready, it does nothing when the process wasn't
+// given a notification socket, and it does nothing once a process started by
+// Exec is ready, since Exec only waits for readiness.
+func Notify(state string) error {
+	return notifyEnv(state)
+}
+
+// WatchdogInterval returns the interval within which the service manager
+// expects WATCHDOG=1 notifications from the process, if it enabled its
+// watchdog for it.
+func WatchdogInterval() (time.Duration, bool) {
+	return watchdogInterval()
+}
+
 // Exec the given command in the background using the systemd
 // notification protocol. This function returns once the command has
 // either exited or signaled that it is ready. If the command exits
 // with a non-zero status before signaling readiness, returns an
-// exec.ExitError.
+// exec.ExitError; if it exits with a zero status before signaling
+// readiness, returns ErrExitedBeforeReady.
 func Exec(cmd *exec.Cmd) error {
 	return bgExec(cmd)
 }
@@ -24,17 +24,29 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	envName  = "NOTIFY_SOCKET"
 	readyMsg = "READY=1"
 	netType  = "unixgram"
+	// readyOnlyEnvName is set by bgExec along with envName. It tells the
+	// process that the socket only waits for its readiness.
+	readyOnlyEnvName = "NOTIFY_SOCKET_READY_ONLY"
 )
 
 func ready() error {
-	return notifyEnv(readyMsg)
+	err := notifyEnv(readyMsg)
+	// The socket of bgExec is removed once the process is ready, so the
+	// variables are unset to make the later notifications no-ops.
+	if _, ok := os.LookupEnv(readyOnlyEnvName); ok {
+		_ = os.Unsetenv(envName)
+		_ = os.Unsetenv(readyOnlyEnvName)
+	}
+	return err
 }
 
 func notifyEnv(msg string) error {
@@ -45,6 +57,10 @@ func notifyEnv(msg string) error {
 }
 
 func notify(path, msg string) error {
+	// A leading @ denotes a socket in the abstract namespace.
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
 	addr := net.UnixAddr{
 		Net:  netType,
 		Name: path,
@@ -59,6 +75,23 @@ func notify(path, msg string) error {
 	return err
 }
 
+func watchdogInterval() (time.Duration, bool) {
+	usec, ok := os.LookupEnv("WATCHDOG_USEC")
+	if !ok {
+		return 0, false
+	}
+	// The watchdog may have been enabled for another process, e.g. the
+	// parent of this one.
+	if pid, ok := os.LookupEnv("WATCHDOG_PID"); ok && pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Microsecond, true
+}
+
 func bgExec(cmd *exec.Cmd) error {
 	l, err := listen()
 	if err != nil {
@@ -68,15 +101,16 @@ func bgExec(cmd *exec.Cmd) error {
 
 	if cmd.Env == nil {
 		// Default the environment to the parent process's, minus any
-		// existing versions of our variable.
-		varPrefix := fmt.Sprintf("%s=", envName)
+		// existing versions of our variables.
 		for _, v := range os.Environ() {
-			if !strings.HasPrefix(v, varPrefix) {
+			if !strings.HasPrefix(v, envName+"=") && !strings.HasPrefix(v, readyOnlyEnvName+"=") {
 				cmd.Env = append(cmd.Env, v)
 			}
 		}
 	}
-	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", envName, l.Path))
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("%s=%s", envName, l.Path),
+		fmt.Sprintf("%s=1", readyOnlyEnvName))
 
 	if err := cmd.Start(); err != nil {
 		return err
@@ -84,14 +118,30 @@ func bgExec(cmd *exec.Cmd) error {
 
 	// This can leak goroutines, but we don't really care because we
 	// always exit after calling this function.
-	ch := make(chan error, 2)
+	readyCh := make(chan error, 1)
+	exitCh := make(chan error, 1)
 	go func() {
-		ch <- l.wait()
+		readyCh <- l.wait()
 	}()
 	go func() {
-		ch <- cmd.Wait()
+		exitCh <- cmd.Wait()
 	}()
-	return <-ch
+	select {
+	case err := <-readyCh:
+		return err
+	case err := <-exitCh:
+		if err == nil {
+			select {
+			case err := <-readyCh:
+				// The process signaled readiness before exiting.
+				return err
+			default:
+			}
+			// The process never became ready, so don't report success.
+			return ErrExitedBeforeReady
+		}
+		return err
+	}
 }
 
 type listener struct {
@@ -18,7 +18,10 @@
 package sdnotify
 
 import (
+	"os"
+	"strconv"
 	"testing"
+	"time"
 
 	_ "github.com/cockroachdb/cockroach/pkg/util/log" // for flags
 )
@@ -42,3 +45,101 @@ func TestSDNotify(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNotify(t *testing.T) {
+	defer func(prev string) { _ = os.Setenv(envName, prev) }(os.Getenv(envName))
+	if err := os.Unsetenv(envName); err != nil {
+		t.Fatal(err)
+	}
+	// Without a notification socket, notifications are a no-op.
+	if err := Notify("STOPPING=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.close() }()
+	if err := os.Setenv(envName, l.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Notify("STOPPING=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := l.conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := string(buf[:n]); state != "STOPPING=1" {
+		t.Errorf("expected STOPPING=1, got %q", state)
+	}
+}
+
+func TestNotifyAfterBackgroundReady(t *testing.T) {
+	defer func(prev string) { _ = os.Setenv(envName, prev) }(os.Getenv(envName))
+	defer func() { _ = os.Unsetenv(readyOnlyEnvName) }()
+
+	l, err := listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(envName, l.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(readyOnlyEnvName, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan error)
+	go func() {
+		ch <- l.wait()
+	}()
+	if err := Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	// Like bgExec, stop listening once the process is ready. The later
+	// notifications must not fail.
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Notify("STOPPING=1"); err != nil {
+		t.Errorf("expected notifications after readiness to be dropped, got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer func(usec, pid string) {
+		_ = os.Setenv("WATCHDOG_USEC", usec)
+		_ = os.Setenv("WATCHDOG_PID", pid)
+	}(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"))
+
+	testCases := []struct {
+		usec, pid string
+		expected  time.Duration
+		ok        bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, true},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second, true},
+		{"30000000", strconv.Itoa(os.Getpid() + 1), 0, false},
+		{"0", "", 0, false},
+		{"abc", "", 0, false},
+	}
+	for _, tc := range testCases {
+		if err := os.Setenv("WATCHDOG_USEC", tc.usec); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Setenv("WATCHDOG_PID", tc.pid); err != nil {
+			t.Fatal(err)
+		}
+		if interval, ok := WatchdogInterval(); interval != tc.expected || ok != tc.ok {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: expected %s, %t, got %s, %t",
+				tc.usec, tc.pid, tc.expected, tc.ok, interval, ok)
+		}
+	}
+}
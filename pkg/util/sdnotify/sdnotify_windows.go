@@ -18,12 +18,21 @@ package sdnotify
 import (
 	"errors"
 	"os/exec"
+	"time"
 )
 
 func ready() error {
 	return nil
 }
 
+func notifyEnv(string) error {
+	return nil
+}
+
+func watchdogInterval() (time.Duration, bool) {
+	return 0, false
+}
+
 func bgExec(*exec.Cmd) error {
 	return errors.New("not implemented")
 }
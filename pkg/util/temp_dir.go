@@ -50,6 +50,29 @@ func RecordTempDir(recordPath, tempPath string) error {
 	return err
 }
 
+// RecordedTempDirs returns the temporary directories listed in the record
+// file specified by recordPath, without removing them. A missing record file
+// results in an empty list.
+func RecordedTempDirs(recordPath string) ([]string, error) {
+	f, err := os.Open(recordPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path := scanner.Text(); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, scanner.Err()
+}
+
 // CleanupTempDirs removes all directories listed in the record file specified
 // by recordPath.
 // It should be invoked before creating any new temporary directories to clean
@@ -20,6 +20,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -86,6 +87,40 @@ func TestRecordTempDir(t *testing.T) {
 	}
 }
 
+func TestRecordedTempDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	recordPath := filepath.Join(dir, "record-file")
+
+	// A missing record file lists nothing.
+	if paths, err := RecordedTempDirs(recordPath); err != nil {
+		t.Fatal(err)
+	} else if len(paths) != 0 {
+		t.Fatalf("expected no recorded temp dirs, got %v", paths)
+	}
+
+	expected := []string{"foo", "bar"}
+	for _, p := range expected {
+		if err := RecordTempDir(recordPath, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := RecordedTempDirs(recordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
 func TestCleanupTempDirs(t *testing.T) {
 	recordFile, err := ioutil.TempFile("", "record-file")
 	if err != nil {